@@ -11,7 +11,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -19,7 +19,6 @@ import (
 	"github.com/aandrew-me/tgpt/v2/src/helper"
 	"github.com/aandrew-me/tgpt/v2/src/imagegen"
 	"github.com/aandrew-me/tgpt/v2/src/mcp"
-	"github.com/aandrew-me/tgpt/v2/src/providers"
 	"github.com/aandrew-me/tgpt/v2/src/structs"
 	"github.com/aandrew-me/tgpt/v2/src/tools"
 	"github.com/aandrew-me/tgpt/v2/src/utils"
@@ -72,8 +71,41 @@ func (f *toolsFlagValue) IsBoolFlag() bool {
 	return true
 }
 
+// sandboxFlagValue is --sandbox, which may be given alone or with a spec
+// such as "net,time=5m".
+type sandboxFlagValue struct {
+	enabled bool
+	spec    string
+}
+
+func (f *sandboxFlagValue) String() string {
+	if !f.enabled {
+		return "false"
+	}
+	if f.spec == "" {
+		return "true"
+	}
+	return f.spec
+}
+
+func (f *sandboxFlagValue) Set(s string) error {
+	f.enabled = s != "false" && s != "0"
+	f.spec = ""
+	if f.enabled && s != "true" && s != "1" {
+		f.spec = s
+	}
+	return nil
+}
+
+func (f *sandboxFlagValue) IsBoolFlag() bool {
+	return true
+}
+
+// restoreTerminal gets ready for tgpt to exit: it resets the terminal and
+// stops commands the tools left running in the background.
 func restoreTerminal() {
 	bubbletea.RestoreTerminal()
+	tools.DefaultRegistry.StopCommands()
 }
 
 func loadConfig(configPath string) {
@@ -136,8 +168,10 @@ func shellSystemPrompt(useAliases bool) string {
 	return fmt.Sprintf(
 		"You are a powerful terminal assistant. Answer the needs of the user. "+
 			"You can execute commands in the command line if needed. Always wrap the command with the xml tag `<cmd>`. "+
-			"Only output a command when you think the user wants to execute a command. Execute only one command in one response. "+
+			"Only output a command when you think the user wants to execute a command. "+
+			"When a task needs several commands, wrap each one in its own `<cmd>` tag in the order they should run; they run one after another, each in a new shell, so `cd` does not carry over. "+
 			"The shell environment you are in is %s. The operating system you are on is %s. "+
+			helper.ShellPromptHint()+
 			extraContext+
 			"Examples: "+
 			"User: list the files in my home dir. "+
@@ -146,11 +180,28 @@ func shellSystemPrompt(useAliases bool) string {
 	)
 }
 
+// fixPrompt asks the model for a corrected command after result failed.
+func fixPrompt(result helper.CommandResult) string {
+	return fmt.Sprintf("The command `%s` failed with exit status %d. "+
+		"Work out the cause from its output and reply with the corrected command in `<cmd>` tags, "+
+		"or explain why it cannot be fixed without one.", result.Command, result.ExitCode)
+}
+
+// fixLimit is how many corrected commands to ask for after a failure: none
+// unless --fix is set.
+func fixLimit(fix bool, attempts int) int {
+	if !fix || attempts < 0 {
+		return 0
+	}
+	return attempts
+}
+
 // runInteractiveShellMode handles both the shell and alias interactive modes.
 func runInteractiveShellMode(
 	params structs.Params,
 	preprompt, logFile, initialInput string,
 	shouldExecuteCommand, useAliases bool,
+	fixAttempts int,
 ) {
 	if useAliases {
 		bold.Print("Interactive Shell mode with aliases started. Press Ctrl + C or type exit to quit.\n\n")
@@ -164,12 +215,14 @@ func runInteractiveShellMode(
 	var previousMessages []any
 	threadID := utils.RandomString(36)
 	history := []string{}
-	commandRegex := regexp.MustCompile(`<cmd>(.*?)</cmd>`)
+	// lastRequest is what the user last asked for; commands, including
+	// fixes, are kept under it in the command history.
+	lastRequest := ""
 
-	getAndPrintResponse := func(input string) string {
+	getResponse := func(input string, fromUser bool) []string {
 		input = strings.TrimSpace(input)
 		if input == "" {
-			return ""
+			return nil
 		}
 		if input == "exit" {
 			bold.Println("Exiting...")
@@ -179,6 +232,9 @@ func runInteractiveShellMode(
 		if len(logFile) > 0 {
 			utils.LogToFile(input, "USER_QUERY", logFile)
 		}
+		if fromUser {
+			lastRequest = input
+		}
 		// Use preprompt for first message
 		if len(previousMessages) == 0 {
 			input = preprompt + input
@@ -194,58 +250,70 @@ func runInteractiveShellMode(
 			utils.LogToFile(responseTxt, "ASSISTANT_RESPONSE", logFile)
 		}
 
-		matches := commandRegex.FindStringSubmatch(responseTxt)
-		if len(matches) > 1 {
-			return strings.TrimSpace(matches[1])
+		if cmds := helper.ExtractCommands(responseTxt); len(cmds) > 0 {
+			return cmds
 		}
 
 		previousMessages = append(previousMessages, responseObjects...)
-		history = append(history, input)
-		return ""
+		if fromUser {
+			history = append(history, input)
+		}
+		return nil
 	}
 
-	execCmd := func(cmd string) {
-		if cmd == "" {
-			return
+	record := func(content string) {
+		previousMessages = append(previousMessages, structs.DefaultMessage{Role: "user", Content: content})
+	}
+
+	// execCmds asks which of cmds to run and runs them in order, recording
+	// each result in the conversation. After a failure the user decides
+	// whether to go on. It returns the last failed result, if any.
+	execCmds := func(cmds []string) *helper.CommandResult {
+		choices, err := helper.ChooseCommands(cmds, shouldExecuteCommand)
+		if errors.Is(err, bubbletea.ErrInterrupted) {
+			handleExit()
 		}
-		var output string
-		executed := false
 
-		if shouldExecuteCommand {
-			fmt.Println()
-			output = helper.ExecuteCommandWithCapture(helper.ShellName, helper.ShellOptions, cmd, true, useAliases)
-			executed = true
-		} else {
-			confirmed, err := bubbletea.ConfirmMenu(fmt.Sprintf("\nExecute shell command: `%s` ?", cmd), true)
-			if errors.Is(err, bubbletea.ErrInterrupted) {
-				handleExit()
+		var failed *helper.CommandResult
+		stopped := false
+		for i, c := range choices {
+			if !c.Run || stopped {
+				record(fmt.Sprintf("Declined to execute command: %s", c.Command))
+				helper.RecordNotRun(lastRequest, c.Command)
+				continue
 			}
-			if confirmed {
-				output = helper.ExecuteCommandWithCapture(helper.ShellName, helper.ShellOptions, cmd, true, useAliases)
-				executed = true
+			if len(choices) > 1 {
+				bold.Printf("\n[%d/%d] %s\n", i+1, len(choices), c.Command)
+			}
+			result := helper.RunCapturedCommand(helper.ShellName, helper.ShellOptions, c.Command, useAliases)
+			record(result.Summary())
+			helper.RecordResult(lastRequest, result)
+			if result.ExitCode == 0 {
+				continue
+			}
+			failed = &result
+			if i < len(choices)-1 {
+				ok, err := bubbletea.ConfirmMenu(fmt.Sprintf("\nCommand failed with exit status %d. Continue with the remaining commands?", result.ExitCode), false)
+				if errors.Is(err, bubbletea.ErrInterrupted) {
+					handleExit()
+				}
+				stopped = !ok
 			}
 		}
+		return failed
+	}
 
-		// Add command execution to conversation context
-		if !executed {
-			previousMessages = append(previousMessages, structs.DefaultMessage{
-				Role:    "user",
-				Content: fmt.Sprintf("Declined to execute command: %s", cmd),
-			})
-			return
-		}
-		previousMessages = append(previousMessages, structs.DefaultMessage{
-			Role:    "user",
-			Content: fmt.Sprintf("Executed command: %s", cmd),
-		})
-
-		// Add command output to conversation context only if it's not empty
-		if output != "" {
-			outputMsg := structs.DefaultMessage{
-				Role:    "user",
-				Content: fmt.Sprintf("Command output:\n%s", output),
+	// runCmds runs cmds and, with --fix, asks for a corrected command after
+	// each failure until one succeeds or the attempts run out. Every
+	// proposed command is confirmed like any other.
+	runCmds := func(cmds []string) {
+		for attempt := 1; len(cmds) > 0; attempt++ {
+			failed := execCmds(cmds)
+			if failed == nil || attempt > fixAttempts {
+				return
 			}
-			previousMessages = append(previousMessages, outputMsg)
+			bold.Printf("\nCommand failed with exit status %d. Asking for a fix (attempt %d of %d)...\n", failed.ExitCode, attempt, fixAttempts)
+			cmds = getResponse(fixPrompt(*failed), false)
 		}
 	}
 
@@ -254,8 +322,7 @@ func runInteractiveShellMode(
 		blue.Println("╭─ You")
 		blue.Print("╰─> ")
 		fmt.Println(input)
-		cmd := getAndPrintResponse(input)
-		execCmd(cmd)
+		runCmds(getResponse(input, true))
 	}
 
 	for {
@@ -268,8 +335,7 @@ func runInteractiveShellMode(
 		if canceled {
 			handleExit()
 		}
-		cmd := getAndPrintResponse(input)
-		execCmd(cmd)
+		runCmds(getResponse(input, true))
 	}
 }
 
@@ -283,6 +349,9 @@ func main() {
 	var configPath string
 	for i := 0; i < len(os.Args); i++ {
 		arg := os.Args[i]
+		if arg == "--" {
+			break
+		}
 		if arg == "--config" || arg == "-config" {
 			if i+1 < len(os.Args) {
 				configPath = os.Args[i+1]
@@ -303,9 +372,14 @@ func main() {
 	terminate := make(chan os.Signal, 1)
 	signal.Notify(terminate, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<-terminate
-		restoreTerminal()
-		os.Exit(130)
+		for sig := range terminate {
+			// Ctrl+C while a tool runs a command stops only the command.
+			if sig == os.Interrupt && tools.DefaultRegistry.InterruptCommand() {
+				continue
+			}
+			restoreTerminal()
+			os.Exit(130)
+		}
 	}()
 
 	apiModel := flag.String("model", "", "Choose which model to use")
@@ -343,6 +417,12 @@ func main() {
 	isShell := flag.Bool("s", false, "Generate and Execute shell commands.")
 	flag.BoolVar(isShell, "shell", false, "Generate and Execute shell commands.")
 
+	isExplain := flag.Bool("explain", false, "Explain a shell command part by part")
+	isSuggest := flag.Bool("suggest", false, "Print only the generated shell command, for shell key bindings")
+	shellInit := flag.String("shell-init", "", "Print a key binding and --last hook for bash, zsh or fish")
+	showHistory := flag.Bool("history", false, "Search the commands generated with -s, -is and --suggest, and run, edit or copy one")
+	useLast := flag.Bool("last", false, "Add the last command run in the shell, its exit status and output to the prompt")
+
 	isImage := flag.Bool("img", false, "Generate images from text")
 	flag.BoolVar(isImage, "image", false, "Generate images from text")
 
@@ -354,6 +434,8 @@ func main() {
 
 	isInteractiveShell := flag.Bool("is", false, "Start shell interactive mode")
 	flag.BoolVar(isInteractiveShell, "interactive-shell", false, "Start shell interactive mode")
+	fixCommands := flag.Bool("fix", false, "Ask for a corrected command when one fails in interactive shell mode")
+	fixAttempts := flag.Int("fix-attempts", envInt("TGPT_FIX_ATTEMPTS", 3), "Maximum corrected commands to ask for after a failure with --fix")
 
 	isFind := flag.Bool("f", false, "Find information using web search")
 	flag.BoolVar(isFind, "find", false, "Find information using web search")
@@ -384,6 +466,21 @@ func main() {
 	var toolsFlag toolsFlagValue
 	flag.Var(&toolsFlag, "t", "Enable tools / MCP support")
 	flag.Var(&toolsFlag, "tools", "Enable tools / MCP support")
+	toolsConfig := flag.String("tools-config", os.Getenv("TOOLS_CONFIG"), "Path to custom command tools JSON file")
+	workspace := flag.String("workspace", os.Getenv("TGPT_WORKSPACE"), "Directory the file tools are confined to (defaults to the current directory)")
+	permissionsFile := flag.String("permissions", os.Getenv("TOOLS_PERMISSIONS"), "Path to tool permission rules JSON file")
+	maxToolSteps := flag.Int("max-tool-steps", envInt("TGPT_MAX_TOOL_STEPS", helper.DefaultMaxToolSteps), "Maximum rounds of tool calls per prompt")
+	toolTimeout := flag.String("tool-timeout", os.Getenv("TGPT_TOOL_TIMEOUT"), "Tool timeouts: a default and/or tool=duration overrides, comma-separated")
+	auditLogFile := flag.String("audit-log", os.Getenv("TGPT_AUDIT_LOG"), "Append a JSON line for every tool call to this file")
+	revertSession := flag.String("revert", "", "Restore the files changed by tools in a session (or \"last\")")
+	showChanges := flag.Bool("changes", false, "List the files changed by tools in a session with diffs")
+	planMode := flag.Bool("plan", false, "Queue file changes and commands for review instead of running them")
+	var sandboxFlag sandboxFlagValue
+	if spec, ok := os.LookupEnv("TGPT_SANDBOX"); ok {
+		_ = sandboxFlag.Set(spec)
+	}
+	flag.Var(&sandboxFlag, "sandbox", "Run generated commands and execute_command in a sandbox (optionally net,memory=,cpu=,time=,workdir=)")
+	toolOutputTokens := flag.Int("tool-output-tokens", envInt("TGPT_TOOL_OUTPUT_TOKENS", tools.DefaultOutputTokens), "Token budget for each tool result (0 for no limit)")
 
 	isVerbose := flag.Bool("vb", false, "Enable verbose output for debugging")
 	flag.BoolVar(isVerbose, "verbose", false, "Enable verbose output for debugging")
@@ -398,6 +495,22 @@ func main() {
 		}
 	}
 
+	if *revertSession != "" {
+		if err := helper.RevertSession(tools.DefaultCheckpointDir(), *revertSession); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *showChanges {
+		if err := helper.ShowChanges(tools.DefaultCheckpointDir(), flag.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if *mcpAdd {
 		if err := mcp.AddServerInteractive(context.Background(), *mcpConfig); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -443,6 +556,15 @@ func main() {
 		finalSearchProvider = "exa"
 	}
 
+	if sandboxFlag.enabled {
+		sandbox, err := tools.ParseSandbox(sandboxFlag.spec, *workspace)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		tools.DefaultRegistry.SetSandbox(sandbox)
+	}
+
 	var mcpConfigSet bool
 	flag.Visit(func(f *flag.Flag) {
 		if f.Name == "mcp-config" {
@@ -456,44 +578,95 @@ func main() {
 
 	mcpRequested := *mcpEnabled || mcpConfigSet || *mcpConfig != "" || *mcpServer != ""
 	if toolsFlag.enabled || mcpRequested {
-		if !providers.SupportsTools(finalProvider) {
-			pName := finalProvider
-			if pName == "" {
-				pName = "opencode"
+		// Providers without native function calling get the tools described
+		// in the system prompt instead (see helper.withPromptTools).
+		defaultTimeout, timeouts, err := tools.ParseToolTimeouts(*toolTimeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		outputTokens := *toolOutputTokens
+		if outputTokens == 0 {
+			outputTokens = -1
+		}
+		tools.DefaultRegistry.SetLimits(tools.ToolLimits{
+			Timeout:      defaultTimeout,
+			Timeouts:     timeouts,
+			OutputTokens: outputTokens,
+		})
+
+		sessionID := tools.NewSessionID()
+		if *auditLogFile != "" {
+			auditLog, err := tools.OpenAuditLog(*auditLogFile, sessionID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
 			}
-			fmt.Fprintf(os.Stderr, "Warning: provider %q does not support tools or MCP. Tools will be ignored.\n", pName)
+			// Entries are written unbuffered as they are recorded, so the
+			// log is left for the OS to close however tgpt exits.
+			tools.DefaultRegistry.SetAuditLog(auditLog)
+		}
+
+		if permissions, err := tools.LoadPermissions(*permissionsFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to load tool permissions: %v\n", err)
 		} else {
-			if toolsFlag.enabled {
-				tools.DefaultRegistry.RegisterBuiltinTools(toolsFlag.toolNames...)
+			tools.DefaultRegistry.SetPermissions(permissions)
+		}
+
+		if toolsFlag.enabled {
+			policy, err := tools.NewWorkspacePolicy(*workspace,
+				tools.ParsePatternList(os.Getenv("TGPT_WORKSPACE_ALLOW")),
+				tools.ParsePatternList(os.Getenv("TGPT_WORKSPACE_DENY")))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
 			}
+			tools.DefaultRegistry.SetWorkspace(policy)
+			tools.DefaultRegistry.SetHTTPAllowList(tools.ParsePatternList(os.Getenv("TGPT_HTTP_ALLOW")))
+			if dir := tools.DefaultCheckpointDir(); dir != "" {
+				tools.DefaultRegistry.SetCheckpoints(tools.NewCheckpoints(dir, sessionID))
+			}
+			tools.DefaultRegistry.RegisterBuiltinTools(toolsFlag.toolNames...)
 
-			if mcpRequested {
-				ctx := context.Background()
-				cfg, err := mcp.LoadConfig(*mcpConfig)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Warning: failed to load MCP config: %v\n", err)
-				} else if cfg != nil {
-					for name, sc := range cfg.MCPServers {
-						if err := mcpMgr.InitServer(ctx, name, sc); err != nil {
-							fmt.Fprintf(os.Stderr, "Warning: failed to init MCP server %s: %v\n", name, err)
-						}
+			customTools, err := tools.LoadCustomTools(*toolsConfig)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to load custom tools: %v\n", err)
+			} else {
+				tools.DefaultRegistry.RegisterCustomTools(customTools)
+			}
+		}
+
+		if mcpRequested {
+			ctx := context.Background()
+			cfg, err := mcp.LoadConfig(*mcpConfig)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to load MCP config: %v\n", err)
+			} else if cfg != nil {
+				for name, sc := range cfg.MCPServers {
+					if err := mcpMgr.InitServer(ctx, name, sc); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: failed to init MCP server %s: %v\n", name, err)
 					}
-				} else if *mcpEnabled && *mcpServer == "" {
-					fmt.Fprintf(os.Stderr, "Warning: no MCP config file found (checked mcp_config.json and ~/.config/tgpt/mcp_config.json)\n")
 				}
-				if *mcpServer != "" {
-					parts := strings.Fields(*mcpServer)
-					if len(parts) > 0 {
-						sc := mcp.ServerConfig{Command: parts[0], Args: parts[1:]}
-						if err := mcpMgr.InitServer(ctx, "cli-mcp", sc); err != nil {
-							fmt.Fprintf(os.Stderr, "Warning: failed to init MCP server %s: %v\n", *mcpServer, err)
-						}
+			} else if *mcpEnabled && *mcpServer == "" {
+				fmt.Fprintf(os.Stderr, "Warning: no MCP config file found (checked mcp_config.json and ~/.config/tgpt/mcp_config.json)\n")
+			}
+			if *mcpServer != "" {
+				parts := strings.Fields(*mcpServer)
+				if len(parts) > 0 {
+					sc := mcp.ServerConfig{Command: parts[0], Args: parts[1:]}
+					if err := mcpMgr.InitServer(ctx, "cli-mcp", sc); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: failed to init MCP server %s: %v\n", *mcpServer, err)
 					}
 				}
 			}
+		}
 
-			activeTools = tools.DefaultRegistry.GetOpenAITools()
+		activeTools = tools.DefaultRegistry.GetOpenAITools()
+		if *planMode {
+			tools.DefaultRegistry.SetPlan(tools.NewPlan())
 		}
+	} else if *planMode {
+		fmt.Fprintln(os.Stderr, "Warning: --plan has no effect without tools (-t or --mcp)")
 	}
 
 	mainParams := structs.Params{
@@ -508,6 +681,7 @@ func main() {
 		PrevMessages:    []any{},
 		RotateProviders: rotateStr,
 		Tools:           activeTools,
+		MaxToolSteps:    *maxToolSteps,
 	}
 
 	imageParams := structs.ImageParams{
@@ -522,6 +696,18 @@ func main() {
 
 	prompt := flag.Arg(promptArgIndex)
 
+	if *useLast {
+		last, err := helper.ReadLastCommand()
+		if err != nil {
+			utils.PrintError(err.Error())
+			os.Exit(1)
+		}
+		if strings.TrimSpace(prompt) == "" {
+			prompt = last.Question()
+		}
+		prompt += "\n\n" + last.Context()
+	}
+
 	pipedInput := ""
 	cleanPipedInput := ""
 	contextText := ""
@@ -614,6 +800,49 @@ func main() {
 				)
 			}
 
+		case *shellInit != "":
+			script, err := helper.ShellInitScript(*shellInit)
+			if err != nil {
+				utils.PrintError(err.Error())
+				os.Exit(1)
+			}
+			fmt.Print(script)
+
+		case *isSuggest:
+			trimmedPrompt := strings.TrimSpace(prompt)
+			if trimmedPrompt == "" {
+				utils.PrintError("You need to provide some text")
+				utils.PrintError(`Example: tgpt --suggest "find large files"`)
+				os.Exit(1)
+			}
+			if err := helper.SuggestCommand(*preprompt+trimmedPrompt, mainParams); err != nil {
+				utils.PrintError(err.Error())
+				os.Exit(1)
+			}
+
+		case *isExplain:
+			command := strings.TrimSpace(prompt)
+			if command == "" {
+				utils.PrintError("You need to provide a command")
+				utils.PrintError(`Example: tgpt --explain "find . -name '*.log' -mtime +7 -delete"`)
+				return
+			}
+			if err := helper.ExplainCommand(command, mainParams); err != nil {
+				utils.PrintError(err.Error())
+				os.Exit(1)
+			}
+
+		case *showHistory:
+			stdoutStat, err := os.Stdout.Stat()
+			interactive := err == nil && stdoutStat.Mode()&os.ModeCharDevice != 0 && stat.Mode()&os.ModeCharDevice != 0
+			if err := helper.ShowCommandHistory(strings.TrimSpace(prompt), mainParams, interactive); err != nil {
+				if errors.Is(err, bubbletea.ErrInterrupted) {
+					handleExit()
+				}
+				utils.PrintError(err.Error())
+				os.Exit(1)
+			}
+
 		case *isShell:
 			if len(prompt) > 0 {
 				trimmedPrompt := strings.TrimSpace(prompt)
@@ -629,6 +858,7 @@ func main() {
 						IsGetCommand: true,
 						AutoExec:     *shouldExecuteCommand,
 						IsGetSilent:  *isQuiet,
+						ShellPrompt:  trimmedPrompt,
 					},
 				)
 			} else {
@@ -685,6 +915,14 @@ func main() {
 					restoreTerminal()
 					os.Exit(0)
 				}
+				if input == "/plan" {
+					helper.TogglePlanMode(mainParams)
+					return
+				}
+				if input == "/undo-files" {
+					helper.UndoFileChanges()
+					return
+				}
 				if len(*logFile) > 0 {
 					utils.LogToFile(input, "USER_QUERY", *logFile)
 				}
@@ -705,6 +943,8 @@ func main() {
 				previousMessages = append(previousMessages, responseObjects...)
 				history = append(history, input)
 				lastResponse = responseTxt
+
+				helper.ReviewPlan(mainParams)
 			}
 
 			input := strings.TrimSpace(prompt)
@@ -767,7 +1007,7 @@ func main() {
 			}
 
 		case *isInteractiveShell:
-			runInteractiveShellMode(mainParams, *preprompt, *logFile, prompt, *shouldExecuteCommand, false)
+			runInteractiveShellMode(mainParams, *preprompt, *logFile, prompt, *shouldExecuteCommand, false, fixLimit(*fixCommands, *fixAttempts))
 
 		case *isFind:
 			/////////////////////
@@ -844,7 +1084,7 @@ func main() {
 			}
 
 		case *isInteractiveAlias:
-			runInteractiveShellMode(mainParams, *preprompt, *logFile, prompt, *shouldExecuteCommand, true)
+			runInteractiveShellMode(mainParams, *preprompt, *logFile, prompt, *shouldExecuteCommand, true, fixLimit(*fixCommands, *fixAttempts))
 
 		case *isHelp:
 			helper.ShowHelpMessage()
@@ -894,6 +1134,11 @@ func main() {
 		formattedInput := strings.TrimSpace(input)
 		helper.GetData(*preprompt+formattedInput+pipedInput, mainParams, structs.ExtraOptions{IsInteractive: false, IsNormal: true, Verbose: *isVerbose, AutoExec: *shouldExecuteCommand})
 	}
+
+	// With --plan, the queued steps are reviewed once the reply is complete.
+	helper.ReviewPlan(mainParams)
+	helper.PrintCheckpointNotice()
+	tools.DefaultRegistry.StopCommands()
 }
 
 func handleExit() {
@@ -902,3 +1147,11 @@ func handleExit() {
 	os.Exit(130)
 }
 
+// envInt returns the integer value of the environment variable key, or def
+// if it is unset or not a number.
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return def
+}
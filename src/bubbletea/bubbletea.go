@@ -247,3 +247,22 @@ func PromptInput(prompt string, history []string) (string, bool, error) {
 	}
 	return im.Value, im.Canceled, nil
 }
+
+// EditInput is like PromptInput, but starts with value filled in so the user
+// can edit it.
+func EditInput(prompt string, value string) (string, bool, error) {
+	m := InitialInputModel(prompt, nil)
+	m.textinput.CharLimit = 0
+	m.textinput.SetValue(value)
+	m.textinput.CursorEnd()
+	p := tea.NewProgram(m)
+	finalModel, err := p.Run()
+	if err != nil {
+		return "", false, err
+	}
+	im := finalModel.(InputModel)
+	if !im.Canceled {
+		fmt.Printf("%s%s\n", prompt, im.Value)
+	}
+	return im.Value, im.Canceled, nil
+}
@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"strings"
 
+	"charm.land/bubbles/v2/textinput"
 	tea "charm.land/bubbletea/v2"
 )
 
@@ -157,3 +158,126 @@ func ConfirmMenu(title string, defaultYes bool) (bool, error) {
 	}
 	return idx == 0, nil
 }
+
+// filterVisible is how many matches FilterMenu shows at once.
+const filterVisible = 10
+
+// FilterModel is a list narrowed down by a query typed above it.
+type FilterModel struct {
+	Title       string
+	Items       []string
+	Filter      func(query string) []int // indexes of the matching items, best first
+	Matches     []int
+	Cursor      int
+	Selected    int
+	Canceled    bool
+	Interrupted bool
+
+	input textinput.Model
+}
+
+// NewFilterModel returns a FilterModel showing the items matching query.
+func NewFilterModel(title string, items []string, filter func(string) []int, query string) FilterModel {
+	ti := textinput.New()
+	ti.Prompt = "> "
+	ti.SetValue(query)
+	ti.CursorEnd()
+	ti.Focus()
+	return FilterModel{Title: title, Items: items, Filter: filter, Matches: filter(query), Selected: -1, input: ti}
+}
+
+func (m FilterModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m FilterModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "ctrl+c":
+			m.Interrupted = true
+			return m, tea.Quit
+		case "esc":
+			m.Canceled = true
+			return m, tea.Quit
+		case "up", "ctrl+p":
+			if m.Cursor > 0 {
+				m.Cursor--
+			}
+			return m, nil
+		case "down", "ctrl+n":
+			if m.Cursor < len(m.Matches)-1 {
+				m.Cursor++
+			}
+			return m, nil
+		case "enter":
+			if len(m.Matches) > 0 {
+				m.Selected = m.Matches[m.Cursor]
+				return m, tea.Quit
+			}
+			return m, nil
+		}
+	}
+
+	query := m.input.Value()
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	if m.input.Value() != query {
+		m.Matches = m.Filter(m.input.Value())
+		m.Cursor = 0
+	}
+	return m, cmd
+}
+
+func (m FilterModel) View() tea.View {
+	var s strings.Builder
+	if m.Title != "" {
+		s.WriteString(m.Title + "\n")
+	}
+	s.WriteString(m.input.View() + "\n")
+
+	start := 0
+	if m.Cursor >= filterVisible {
+		start = m.Cursor - filterVisible + 1
+	}
+	end := min(start+filterVisible, len(m.Matches))
+	for i := start; i < end; i++ {
+		if i == m.Cursor {
+			s.WriteString(fmt.Sprintf("  \033[36m❯ %s\033[0m\n", m.Items[m.Matches[i]]))
+		} else {
+			s.WriteString(fmt.Sprintf("    %s\n", m.Items[m.Matches[i]]))
+		}
+	}
+	if len(m.Matches) == 0 {
+		s.WriteString("    \033[90mNo matches\033[0m\n")
+	}
+	s.WriteString(fmt.Sprintf("\n\033[90m%d/%d (Type to search, ↑/↓ to move, Enter to select, Esc to cancel)\033[0m\n", len(m.Matches), len(m.Items)))
+	return tea.NewView(s.String())
+}
+
+// FilterMenu lets the user search items and pick one. filter returns the
+// indexes of the items matching a query, best first; query is the initial
+// search. It returns the index of the chosen item, or an error if the menu
+// was canceled or interrupted.
+func FilterMenu(title string, items []string, filter func(query string) []int, query string) (int, error) {
+	if len(items) == 0 {
+		return -1, fmt.Errorf("no items provided")
+	}
+
+	p := tea.NewProgram(NewFilterModel(title, items, filter, query))
+	finalModel, err := p.Run()
+	if err != nil {
+		return -1, err
+	}
+
+	res, ok := finalModel.(FilterModel)
+	if !ok {
+		return -1, fmt.Errorf("selection failed")
+	}
+	if res.Interrupted {
+		return -1, ErrInterrupted
+	}
+	if res.Canceled || res.Selected < 0 {
+		return -1, ErrCanceled
+	}
+	return res.Selected, nil
+}
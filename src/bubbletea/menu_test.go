@@ -97,3 +97,34 @@ func TestSelectMenuEmpty(t *testing.T) {
 		t.Errorf("expected error when options are empty")
 	}
 }
+
+func TestFilterModelNarrowsAndSelects(t *testing.T) {
+	items := []string{"docker ps", "git status", "docker images"}
+	filter := func(query string) []int {
+		var matches []int
+		for i, item := range items {
+			if strings.Contains(item, query) {
+				matches = append(matches, i)
+			}
+		}
+		return matches
+	}
+	m := NewFilterModel("History", items, filter, "")
+	if len(m.Matches) != 3 {
+		t.Fatalf("expected every item to match an empty query, got %v", m.Matches)
+	}
+
+	var model tea.Model = m
+	for _, r := range "dock" {
+		model, _ = model.Update(tea.KeyPressMsg{Code: r, Text: string(r)})
+	}
+	model, _ = model.Update(tea.KeyPressMsg{Code: tea.KeyDown})
+	model, _ = model.Update(tea.KeyPressMsg{Code: tea.KeyEnter})
+	fm := model.(FilterModel)
+	if len(fm.Matches) != 2 || fm.Selected != 2 {
+		t.Errorf("expected the second docker command to be selected, got matches %v and selection %d", fm.Matches, fm.Selected)
+	}
+	if !strings.Contains(fm.View().Content, "2/3") {
+		t.Errorf("expected the match count in the view, got %q", fm.View().Content)
+	}
+}
@@ -0,0 +1,135 @@
+package helper
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aandrew-me/tgpt/v2/src/tools"
+)
+
+var cmdTagRe = regexp.MustCompile(`(?s)<cmd>(.*?)</cmd>`)
+
+// Choices offered for the commands of one response in interactive shell mode.
+const (
+	checklistRunAll = iota
+	checklistChoose
+	checklistEdit
+	checklistSkip
+)
+
+// CommandChoice is a command proposed in interactive shell mode and whether
+// the user chose to run it.
+type CommandChoice struct {
+	Command string
+	Run     bool
+}
+
+// ExtractCommands returns the commands wrapped in <cmd> tags in a response,
+// in the order they appear.
+func ExtractCommands(response string) []string {
+	var cmds []string
+	for _, m := range cmdTagRe.FindAllStringSubmatch(response, -1) {
+		if cmd := strings.TrimSpace(m[1]); cmd != "" {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+// ChooseCommands asks which of the commands proposed in one response to run.
+// A single command is confirmed as before; several are shown as a checklist
+// that can be run in full, picked from or edited first. With autoExec every
+// command runs unless one of them is high-risk. The choices keep the order
+// of cmds.
+func ChooseCommands(cmds []string, autoExec bool) ([]CommandChoice, error) {
+	choices := make([]CommandChoice, len(cmds))
+	for i, cmd := range cmds {
+		choices[i].Command = cmd
+	}
+	if len(cmds) == 0 {
+		return choices, nil
+	}
+
+	if len(cmds) == 1 {
+		risks := tools.AnalyzeCommand(cmds[0])
+		if autoExec && len(risks) == 0 {
+			fmt.Println()
+			choices[0].Run = true
+			return choices, nil
+		}
+		// High-risk commands are confirmed even with -y.
+		ok, err := commandConfirm(CommandPrompt(fmt.Sprintf("\nExecute shell command: `%s` ?", cmds[0]), risks), len(risks) == 0)
+		choices[0].Run = ok && err == nil
+		return choices, err
+	}
+
+	for {
+		anyRisky := false
+		bold.Printf("\nCommands: %d\n", len(choices))
+		for i, c := range choices {
+			codeText.Printf("%d. %s\n", i+1, c.Command)
+			if risks := tools.AnalyzeCommand(c.Command); len(risks) > 0 {
+				anyRisky = true
+				fmt.Println(indent(tools.FormatCommandRisks(risks), "   "))
+			}
+		}
+		if autoExec && !anyRisky {
+			return setAll(choices, true), nil
+		}
+
+		defaultChoice := checklistRunAll
+		if anyRisky {
+			defaultChoice = checklistChoose
+		}
+		choice, _, err := commandMenu("\nRun the commands?", []string{"Run all", "Choose commands to run", "Edit commands", "Skip all"}, defaultChoice)
+		if err != nil {
+			return setAll(choices, false), err
+		}
+
+		switch choice {
+		case checklistRunAll:
+			if anyRisky {
+				ok, err := commandConfirm("\nSome of the commands are high-risk. Run all of them?", false)
+				if err != nil {
+					return setAll(choices, false), err
+				}
+				if !ok {
+					continue
+				}
+			}
+			return setAll(choices, true), nil
+		case checklistChoose:
+			for i := range choices {
+				risks := tools.AnalyzeCommand(choices[i].Command)
+				ok, err := commandConfirm(CommandPrompt(fmt.Sprintf("\nRun %d. `%s` ?", i+1, choices[i].Command), risks), len(risks) == 0)
+				if err != nil {
+					return setAll(choices, false), err
+				}
+				choices[i].Run = ok
+			}
+			return choices, nil
+		case checklistEdit:
+			for i := range choices {
+				edited, canceled, err := editCommand(fmt.Sprintf("%d. $ ", i+1), choices[i].Command)
+				if err != nil {
+					return setAll(choices, false), err
+				}
+				if !canceled && strings.TrimSpace(edited) != "" {
+					choices[i].Command = strings.TrimSpace(edited)
+				}
+			}
+			// Edited commands are shown again and need approval, even with -y.
+			autoExec = false
+		default:
+			return setAll(choices, false), nil
+		}
+	}
+}
+
+func setAll(choices []CommandChoice, run bool) []CommandChoice {
+	for i := range choices {
+		choices[i].Run = run
+	}
+	return choices
+}
@@ -0,0 +1,117 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aandrew-me/tgpt/v2/src/bubbletea"
+	"github.com/aandrew-me/tgpt/v2/src/tools"
+)
+
+// revertConfirm asks before overwriting changes made after the tools wrote a
+// file. Tests replace it.
+var revertConfirm = bubbletea.ConfirmMenu
+
+// UndoFileChanges restores the files the tools changed in the current
+// session (the /undo-files command).
+func UndoFileChanges() {
+	cp := tools.DefaultRegistry.Checkpoints()
+	if cp == nil || cp.Len() == 0 {
+		bold.Println("No file changes to undo.")
+		return
+	}
+	done, err := revertFiles(cp.Revert)
+	for _, line := range done {
+		fmt.Println(line)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	bold.Printf("Undid changes to %d %s.\n", len(done), pluralFiles(len(done)))
+}
+
+// RevertSession restores the files changed in a past session (--revert).
+func RevertSession(root, session string) error {
+	done, err := revertFiles(func(force bool) ([]string, error) {
+		return tools.RevertCheckpoint(root, session, force)
+	})
+	for _, line := range done {
+		fmt.Println(line)
+	}
+	if err != nil {
+		return err
+	}
+	bold.Printf("Reverted %d %s.\n", len(done), pluralFiles(len(done)))
+	return nil
+}
+
+// revertFiles runs revert. If files were changed after the tools wrote them,
+// it lists them and asks before overwriting those changes.
+func revertFiles(revert func(force bool) ([]string, error)) ([]string, error) {
+	done, err := revert(false)
+	var changed *tools.ChangedFilesError
+	if !errors.As(err, &changed) {
+		return done, err
+	}
+	fmt.Fprintln(os.Stderr, "These files were changed after the tools wrote them:")
+	for _, path := range changed.Paths {
+		fmt.Fprintf(os.Stderr, "  %s\n", path)
+	}
+	ok, err := revertConfirm("\nRevert them anyway, losing those changes?", false)
+	if errors.Is(err, bubbletea.ErrInterrupted) {
+		bubbletea.RestoreTerminal()
+		os.Exit(130)
+	}
+	if err != nil || !ok {
+		return nil, errors.New("nothing was reverted")
+	}
+	return revert(true)
+}
+
+// ShowChanges lists the files changed in a session with a diff from their
+// snapshot to their current content (--changes). Without a session, the most
+// recent one is shown along with a list of the others.
+func ShowChanges(root, session string) error {
+	m, err := tools.LoadCheckpoint(root, session)
+	if err != nil {
+		return err
+	}
+
+	bold.Printf("Session %s (%s, %s): %d %s\n", m.Session, m.Started.Format("2006-01-02 15:04"), m.Workdir, len(m.Files), pluralFiles(len(m.Files)))
+	for _, f := range m.Files {
+		fmt.Println()
+		fmt.Println(tools.CheckpointDiff(root, *m, f))
+	}
+	fmt.Printf("\nRevert with: tgpt --revert %s\n", m.Session)
+
+	if session == "" {
+		sessions, err := tools.ListCheckpoints(root)
+		if err == nil && len(sessions) > 1 {
+			bold.Println("\nOther sessions:")
+			for _, s := range sessions[1:] {
+				fmt.Printf("  %s  %s  %d %s  %s\n", s.Session, s.Started.Format("2006-01-02 15:04"), len(s.Files), pluralFiles(len(s.Files)), s.Workdir)
+			}
+		}
+	}
+	return nil
+}
+
+// PrintCheckpointNotice tells the user how to review or undo the file
+// changes of a finished run.
+func PrintCheckpointNotice() {
+	cp := tools.DefaultRegistry.Checkpoints()
+	if cp == nil || cp.Len() == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\nChanged %d %s (session %s). Review with `tgpt --changes %s`, undo with `tgpt --revert %s`.\n",
+		cp.Len(), pluralFiles(cp.Len()), cp.Session(), cp.Session(), cp.Session())
+}
+
+func pluralFiles(n int) string {
+	if n == 1 {
+		return "file"
+	}
+	return "files"
+}
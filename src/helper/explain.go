@@ -0,0 +1,160 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aandrew-me/tgpt/v2/src/bubbletea"
+	"github.com/aandrew-me/tgpt/v2/src/clipboard"
+	"github.com/aandrew-me/tgpt/v2/src/structs"
+	"github.com/aandrew-me/tgpt/v2/src/tools"
+)
+
+// commandMenu and editCommand are replaced in tests, as is explainRequest,
+// which streams the explanation to the terminal when nil.
+var (
+	commandMenu    = bubbletea.SelectMenu
+	editCommand    = bubbletea.EditInput
+	explainRequest func(prompt string, params structs.Params) (string, error)
+)
+
+// Choices offered for a generated command.
+const (
+	commandExecute = iota
+	commandExplain
+	commandEdit
+	commandCopy
+)
+
+// RunGeneratedCommand asks what to do with a command generated by -s: run
+// it, explain it, edit it, or copy it to the clipboard. With -y it runs
+// straight away unless it is high-risk.
+func RunGeneratedCommand(command string, params structs.Params, extraOptions structs.ExtraOptions) error {
+	for {
+		risks := tools.AnalyzeCommand(command)
+		if extraOptions.AutoExec && len(risks) == 0 {
+			executeGeneratedCommand(command, extraOptions)
+			return nil
+		}
+
+		defaultChoice := commandExecute
+		if len(risks) > 0 {
+			defaultChoice = commandCopy
+		}
+		choice, _, err := commandMenu(CommandPrompt("\nExecute shell command?", risks),
+			[]string{"Execute", "Explain", "Edit", "Copy to clipboard"}, defaultChoice)
+		if err != nil {
+			if errors.Is(err, bubbletea.ErrInterrupted) {
+				return err
+			}
+			choice = commandCopy
+		}
+
+		switch choice {
+		case commandExecute:
+			executeGeneratedCommand(command, extraOptions)
+			return nil
+		case commandExplain:
+			if err := ExplainCommand(command, params); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			fmt.Println()
+			codeText.Println(command)
+			// Explaining does not count as approval.
+			extraOptions.AutoExec = false
+		case commandEdit:
+			edited, canceled, err := editCommand("$ ", command)
+			if errors.Is(err, bubbletea.ErrInterrupted) {
+				return err
+			}
+			if err == nil && !canceled && strings.TrimSpace(edited) != "" {
+				command = strings.TrimSpace(edited)
+			}
+			extraOptions.AutoExec = false
+		default:
+			RecordNotRun(extraOptions.ShellPrompt, command)
+			clipboard.CopyToClipboard(command)
+			return nil
+		}
+	}
+}
+
+// executeGeneratedCommand runs command like ExecuteCommand, in
+// extraOptions.ShellDir if set, recording it in the command history with the
+// prompt it was generated for.
+func executeGeneratedCommand(command string, extraOptions structs.ExtraOptions) {
+	err := runAttached(ShellName, ShellOptions, command, false, extraOptions.ShellDir)
+	recordRun(extraOptions.ShellPrompt, command, extraOptions.ShellDir, exitStatus(err))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// ExplainCommand prints a breakdown of command: each pipeline stage, flag
+// and glob. Explanations are cached, so explaining the same command again
+// (with --explain or from the -s menu) needs no request.
+func ExplainCommand(command string, params structs.Params) error {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return fmt.Errorf("no command to explain")
+	}
+	if ShellName == "" {
+		SetShellAndOSVars()
+	}
+
+	cachePath := explainCachePath(command)
+	if cachePath != "" {
+		if cached, err := os.ReadFile(cachePath); err == nil {
+			fmt.Println()
+			fmt.Println(strings.TrimSpace(string(cached)))
+			return nil
+		}
+	}
+
+	params.Tools = nil
+	params.SystemPrompt = ""
+	params.PrevMessages = nil
+	var explanation string
+	var err error
+	if explainRequest != nil {
+		explanation, err = explainRequest(explainPrompt(command), params)
+	} else {
+		explanation, _, err = MakeRequestAndGetData(explainPrompt(command), params, structs.ExtraOptions{IsNormal: true})
+	}
+	if err != nil {
+		return err
+	}
+	if cachePath != "" && strings.TrimSpace(explanation) != "" {
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0700); err == nil {
+			_ = os.WriteFile(cachePath, []byte(explanation), 0600)
+		}
+	}
+	return nil
+}
+
+func explainPrompt(command string) string {
+	return fmt.Sprintf(
+		"Explain the following %s command for %s part by part. "+
+			"Go through each command of a pipeline or chain in order, and under it list every flag, argument, glob, "+
+			"redirection and variable with what it does. Point out anything that deletes or overwrites data. "+
+			"Be brief, use a Markdown list, and do not suggest other commands.\n\nCommand: %s",
+		ShellName, OperatingSystem, command,
+	)
+}
+
+// explainCachePath returns where the explanation of command is cached, keyed
+// by the shell and the command: <user cache dir>/tgpt/explain/<hash>.md.
+func explainCachePath(command string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(ShellName + "\n" + OperatingSystem + "\n" + command))
+	return filepath.Join(dir, "tgpt", "explain", hex.EncodeToString(sum[:16])+".md")
+}
@@ -1,6 +1,11 @@
 package helper
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	stdhttp "net/http"
 	"net/http/httptest"
 	"os"
@@ -8,8 +13,11 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aandrew-me/tgpt/v2/src/bubbletea"
 	"github.com/aandrew-me/tgpt/v2/src/structs"
 	"github.com/aandrew-me/tgpt/v2/src/tools"
 	http "github.com/bogdanfinn/fhttp"
@@ -188,6 +196,35 @@ func TestToolDepthLimitClearsToolsAndReturnsResponse(t *testing.T) {
 	}
 }
 
+func TestMaxToolStepsKeepsToolsBelowLimit(t *testing.T) {
+	var toolsSent []bool
+	server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		body, _ := io.ReadAll(r.Body)
+		toolsSent = append(toolsSent, strings.Contains(string(body), `"tools":`))
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"done\"}}]}\n\n"))
+	}))
+	defer server.Close()
+
+	params := structs.Params{
+		Provider:     "openai",
+		Url:          server.URL,
+		Tools:        []any{"dummy_tool"},
+		MaxToolSteps: 20,
+	}
+
+	for _, depth := range []int{5, 20} {
+		if _, _, err := MakeRequestAndGetData("hello", params, structs.ExtraOptions{IsGetSilent: true, ToolDepth: depth}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if len(toolsSent) != 2 || !toolsSent[0] || toolsSent[1] {
+		t.Errorf("expected tools at depth 5 and none at depth 20 with --max-tool-steps 20, got %v", toolsSent)
+	}
+}
+
 func TestToolExecutionAutoExec(t *testing.T) {
 	step := 0
 	server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
@@ -369,3 +406,818 @@ func TestEscapePowerShellArg(t *testing.T) {
 		}
 	}
 }
+
+func TestExecuteToolCallsRunsReadOnlyCallsConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	record := func(e string) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}
+
+	// Both read-only calls must be running at the same time to get past the
+	// barrier; run sequentially they would time out.
+	barrier := make(chan struct{})
+	arrived := make(chan struct{}, 2)
+	readOnly := func(name string) tools.ToolHandler {
+		return func(ctx context.Context, args map[string]any) (string, error) {
+			arrived <- struct{}{}
+			select {
+			case <-barrier:
+			case <-time.After(5 * time.Second):
+				return "", fmt.Errorf("%s ran alone", name)
+			}
+			record(name)
+			return name + " result", nil
+		}
+	}
+	go func() {
+		<-arrived
+		<-arrived
+		close(barrier)
+	}()
+
+	spec := func(name string) tools.ToolSpec {
+		return tools.ToolSpec{Type: "function", Function: tools.FunctionSpec{Name: name, Parameters: map[string]any{"type": "object"}}}
+	}
+	tools.DefaultRegistry.RegisterWithMeta(spec("par_read_a"), tools.ToolMeta{Source: "custom", ReadOnly: true}, readOnly("par_read_a"))
+	tools.DefaultRegistry.RegisterWithMeta(spec("par_read_b"), tools.ToolMeta{Source: "custom", ReadOnly: true}, readOnly("par_read_b"))
+	tools.DefaultRegistry.RegisterWithMeta(spec("par_write"), tools.ToolMeta{Source: "custom"}, func(ctx context.Context, args map[string]any) (string, error) {
+		record("par_write")
+		return "write result", nil
+	})
+
+	calls := []structs.ToolCall{
+		{ID: "1", Type: "function", Function: structs.ToolCallFunction{Name: "par_read_a", Arguments: "{}"}},
+		{ID: "2", Type: "function", Function: structs.ToolCallFunction{Name: "par_read_b", Arguments: "{}"}},
+		{ID: "3", Type: "function", Function: structs.ToolCallFunction{Name: "par_write", Arguments: "{}"}},
+	}
+	msgs := executeToolCalls(calls, structs.Params{}, structs.ExtraOptions{AutoExec: true, IsGetSilent: true})
+
+	want := []string{"par_read_a result", "par_read_b result", "write result"}
+	for i, m := range msgs {
+		if m.ToolCallID != calls[i].ID || m.Content != want[i] {
+			t.Fatalf("message %d: expected %q for call %s, got %+v", i, want[i], calls[i].ID, m)
+		}
+	}
+	if len(events) != 3 || events[2] != "par_write" {
+		t.Fatalf("expected the mutating call to run after the read-only batch, got %v", events)
+	}
+}
+
+func TestRunToolCallWritesAuditLog(t *testing.T) {
+	tools.DefaultRegistry.RegisterWithMeta(tools.ToolSpec{
+		Type:     "function",
+		Function: tools.FunctionSpec{Name: "audited_echo", Parameters: map[string]any{"type": "object"}},
+	}, tools.ToolMeta{Source: "custom"}, func(ctx context.Context, args map[string]any) (string, error) {
+		return "echo", nil
+	})
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := tools.OpenAuditLog(path, tools.NewSessionID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tools.DefaultRegistry.SetAuditLog(audit)
+	defer func() {
+		tools.DefaultRegistry.SetAuditLog(nil)
+		audit.Close()
+	}()
+
+	tc := structs.ToolCall{ID: "1", Type: "function", Function: structs.ToolCallFunction{Name: "audited_echo", Arguments: `{"x":1}`}}
+	runToolCall(tc, structs.Params{Provider: "openai", ApiModel: "gpt-test"}, structs.ExtraOptions{AutoExec: true, IsGetSilent: true})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry tools.AuditEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("invalid audit log %q: %v", data, err)
+	}
+	if entry.Tool != "audited_echo" || entry.Source != "custom" || entry.Provider != "openai" || entry.Model != "gpt-test" ||
+		entry.Decision != tools.OutcomeAutoExec || entry.Status != "ok" || entry.SessionID != audit.SessionID() {
+		t.Errorf("unexpected audit entry: %+v", entry)
+	}
+}
+
+func TestReviewPlanAppliesChosenSteps(t *testing.T) {
+	var applied []string
+	for _, name := range []string{"plan_step_a", "plan_step_b"} {
+		name := name
+		tools.DefaultRegistry.RegisterWithMeta(tools.ToolSpec{
+			Type:     "function",
+			Function: tools.FunctionSpec{Name: name, Parameters: map[string]any{"type": "object"}},
+		}, tools.ToolMeta{Source: "custom"}, func(ctx context.Context, args map[string]any) (string, error) {
+			applied = append(applied, name)
+			return "done", nil
+		})
+	}
+
+	prevMenu, prevConfirm := planMenu, planConfirm
+	planMenu = func(title string, options []string, defaultIndex int) (int, string, error) {
+		return 1, options[1], nil
+	}
+	planConfirm = func(title string, defaultYes bool) (bool, error) {
+		return strings.Contains(title, "plan_step_b"), nil
+	}
+	tools.DefaultRegistry.SetPlan(tools.NewPlan())
+	defer func() {
+		planMenu, planConfirm = prevMenu, prevConfirm
+		tools.DefaultRegistry.SetPlan(nil)
+	}()
+
+	calls := []structs.ToolCall{
+		{ID: "1", Type: "function", Function: structs.ToolCallFunction{Name: "plan_step_a", Arguments: "{}"}},
+		{ID: "2", Type: "function", Function: structs.ToolCallFunction{Name: "plan_step_b", Arguments: "{}"}},
+	}
+	msgs := executeToolCalls(calls, structs.Params{}, structs.ExtraOptions{IsGetSilent: true})
+	if len(applied) != 0 || !strings.HasPrefix(msgs[1].Content, "Queued as step 2") {
+		t.Fatalf("expected both calls to be queued, got %v and %+v", applied, msgs)
+	}
+
+	ReviewPlan(structs.Params{})
+	if len(applied) != 1 || applied[0] != "plan_step_b" {
+		t.Errorf("expected only the chosen step to be applied, got %v", applied)
+	}
+	if tools.DefaultRegistry.Plan().Len() != 0 {
+		t.Error("expected the plan to be emptied after review")
+	}
+}
+
+func TestParsePromptToolCalls(t *testing.T) {
+	text := `Let me look.<tool_call>{"name": "read_file", "arguments": {"path": "go.mod"}}</tool_call>
+<tool_call> {"name": "glob", "arguments": "{\"pattern\": \"*.go\"}"} </tool_call>
+<tool_call>{"name": "grep", "arguments": {"pattern": </tool_call><tool_call>{"arguments": {}}</tool_call>`
+
+	calls, errs := parsePromptToolCalls(text, 2)
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 valid calls, got %+v", calls)
+	}
+	if calls[0].Function.Name != "read_file" || calls[0].Function.Arguments != `{"path": "go.mod"}` || calls[0].ID != "call_2_0" {
+		t.Errorf("unexpected first call: %+v", calls[0])
+	}
+	if calls[1].Function.Name != "glob" || calls[1].Function.Arguments != `{"pattern": "*.go"}` {
+		t.Errorf("expected string-encoded arguments to be unwrapped, got %+v", calls[1])
+	}
+	if len(errs) != 2 || !strings.Contains(errs[1], `missing "name"`) {
+		t.Errorf("expected two parse errors, got %q", errs)
+	}
+	if got := stripPromptToolCalls(text); got != "Let me look.\n\n" {
+		t.Errorf("unexpected stripped text %q", got)
+	}
+}
+
+func TestToolCallFilterHidesEnvelopes(t *testing.T) {
+	var f toolCallFilter
+	chunks := []string{"a <b> <to", "ol_call>{\"name\"", ": \"x\"}</tool_", "call> done <tool"}
+	var out strings.Builder
+	for _, c := range chunks {
+		out.WriteString(f.write(c))
+	}
+	out.WriteString(f.flush())
+	if out.String() != "a <b>  done <tool" {
+		t.Errorf("unexpected filtered output %q", out.String())
+	}
+}
+
+func TestPromptToolCallingLoop(t *testing.T) {
+	tools.DefaultRegistry.RegisterWithMeta(tools.ToolSpec{
+		Type: "function",
+		Function: tools.FunctionSpec{
+			Name:        "prompt_echo",
+			Description: "Echo a word",
+			Parameters:  map[string]any{"type": "object", "properties": map[string]any{"word": map[string]any{"type": "string"}}},
+		},
+	}, tools.ToolMeta{Source: "custom", ReadOnly: true}, func(ctx context.Context, args map[string]any) (string, error) {
+		return "echoed " + args["word"].(string), nil
+	})
+
+	var bodies []string
+	server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		delta := func(s string) string {
+			b, _ := json.Marshal(map[string]string{"type": "text-delta", "delta": s})
+			return "data: " + string(b) + "\n"
+		}
+		if len(bodies) == 1 {
+			_, _ = w.Write([]byte(delta("Checking.") + delta("<tool_call>{\"name\": \"prompt_echo\", ") + delta("\"arguments\": {\"word\": \"hi\"}}</tool_call>")))
+			return
+		}
+		_, _ = w.Write([]byte(delta("The tool said hi.")))
+	}))
+	defer server.Close()
+
+	params := structs.Params{
+		Provider: "fx",
+		Url:      server.URL,
+	}
+	for _, spec := range tools.DefaultRegistry.ListSpecs() {
+		if spec.Function.Name == "prompt_echo" {
+			params.Tools = []any{spec}
+		}
+	}
+
+	text, turnMessages, err := MakeRequestAndGetData("say hi", params, structs.ExtraOptions{IsNormal: true, AutoExec: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected a follow-up request with the tool result, got %d requests", len(bodies))
+	}
+	if !strings.Contains(bodies[0], "prompt_echo: Echo a word") || strings.Contains(bodies[0], `"tools"`) {
+		t.Errorf("expected the tool to be described in the prompt only, got %s", bodies[0])
+	}
+	if !strings.Contains(bodies[1], "echoed hi") {
+		t.Errorf("expected the follow-up to carry the tool result, got %s", bodies[1])
+	}
+	if text != "Checking.The tool said hi." {
+		t.Errorf("expected envelopes to be stripped from the returned text, got %q", text)
+	}
+	if len(turnMessages) != 4 {
+		t.Fatalf("expected user, assistant, tool results and final answer, got %d messages", len(turnMessages))
+	}
+	if last, ok := turnMessages[3].(structs.DefaultMessage); !ok || last.Content != "The tool said hi." {
+		t.Errorf("unexpected final message %+v", turnMessages[3])
+	}
+}
+
+func TestRunGeneratedCommandExplainAndEdit(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	t.Setenv("TGPT_HISTORY_FILE", filepath.Join(dir, "history.jsonl"))
+	prevShell, prevOptions := ShellName, ShellOptions
+	ShellName, ShellOptions = "sh", []string{"-c"}
+	marker := filepath.Join(dir, "ran")
+
+	var menuTitles []string
+	choices := []int{commandExplain, commandExplain, commandEdit, commandExecute}
+	prevMenu, prevEdit, prevExplain := commandMenu, editCommand, explainRequest
+	commandMenu = func(title string, options []string, defaultIndex int) (int, string, error) {
+		menuTitles = append(menuTitles, title)
+		c := choices[0]
+		choices = choices[1:]
+		return c, options[c], nil
+	}
+	editCommand = func(prompt, value string) (string, bool, error) {
+		return "touch " + marker, false, nil
+	}
+	requests := 0
+	explainRequest = func(prompt string, params structs.Params) (string, error) {
+		requests++
+		if !strings.Contains(prompt, "Command: ls -la | grep go") {
+			t.Errorf("unexpected explain prompt %q", prompt)
+		}
+		return "- `ls -la`: list all files", nil
+	}
+	defer func() {
+		ShellName, ShellOptions = prevShell, prevOptions
+		commandMenu, editCommand, explainRequest = prevMenu, prevEdit, prevExplain
+	}()
+
+	if err := RunGeneratedCommand("ls -la | grep go", structs.Params{}, structs.ExtraOptions{ShellPrompt: "find go files"}); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("expected the second explanation to come from the cache, got %d requests", requests)
+	}
+	if len(menuTitles) != 4 {
+		t.Errorf("expected the menu to be shown again after explaining and editing, got %d menus", len(menuTitles))
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected the edited command to run: %v", err)
+	}
+	entries, err := ReadCommandHistory()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected the run command in the history, got %+v (%v)", entries, err)
+	}
+	if e := entries[0]; e.Prompt != "find go files" || e.Command != "touch "+marker || !e.Executed || e.ExitCode == nil || *e.ExitCode != 0 {
+		t.Errorf("unexpected history entry %+v", e)
+	}
+}
+
+func TestRunGeneratedCommandConfirmsHighRiskWithAutoExec(t *testing.T) {
+	prevMenu := commandMenu
+	var defaults []int
+	commandMenu = func(title string, options []string, defaultIndex int) (int, string, error) {
+		defaults = append(defaults, defaultIndex)
+		if !strings.Contains(title, "High-risk command") {
+			t.Errorf("expected the risk to be shown, got %q", title)
+		}
+		return 0, "", bubbletea.ErrInterrupted
+	}
+	defer func() { commandMenu = prevMenu }()
+
+	err := RunGeneratedCommand("rm -rf /", structs.Params{}, structs.ExtraOptions{AutoExec: true})
+	if !errors.Is(err, bubbletea.ErrInterrupted) || len(defaults) != 1 || defaults[0] != commandCopy {
+		t.Errorf("expected a high-risk command to be asked about with copy preselected, got %v (%v)", defaults, err)
+	}
+}
+
+func TestParseScriptSteps(t *testing.T) {
+	text := "```bash\n" +
+		"# make the directory\n" +
+		"1. mkdir -p build\n" +
+		"2. $ cd build\n" +
+		"\n" +
+		"cmake .. \\\n" +
+		"  -DCMAKE_BUILD_TYPE=Release\n" +
+		"cat > notes.txt <<'EOF'\n" +
+		"first line\n" +
+		"EOF\n" +
+		"```\n"
+	want := []string{
+		"mkdir -p build",
+		"cd build",
+		"cmake .. \\\n  -DCMAKE_BUILD_TYPE=Release",
+		"cat > notes.txt <<'EOF'\nfirst line\nEOF",
+	}
+	got := ParseScriptSteps(text)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d steps, got %q", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("step %d: expected %q, got %q", i+1, want[i], got[i])
+		}
+	}
+}
+
+func TestShellStateChange(t *testing.T) {
+	tests := []struct {
+		step string
+		want string // substring of the description, "" for no state
+	}{
+		{"export GOPATH=$HOME/go", "sets a variable"},
+		{"NAME=demo", "sets a variable"},
+		{"NAME=demo make build", ""},
+		{"mkdir -p build && cd build", ""},
+		{"set -e", "shell option"},
+		{"set -gx EDITOR vim", "sets a variable"},
+		{"$env:PATH = \"C:\\tools;$env:PATH\"", "sets a variable"},
+		{"greet() { echo hi; }", "defines a function"},
+		{"function greet { echo hi; }", "defines a function"},
+		{"source ~/.bashrc", "sources a file"},
+		{". ./env.sh", "sources a file"},
+		{"python -m venv .venv && source .venv/bin/activate", "activates an environment"},
+		{"conda activate ml", "activates an environment"},
+		{"alias ll='ls -l'", "shell setting"},
+		{"echo $PATH | tr : '\\n'", ""},
+	}
+	for _, tt := range tests {
+		got := shellStateChange(tt.step)
+		if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+			t.Errorf("shellStateChange(%q) = %q, want %q", tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestRunScriptCarriesDirectoryAndStopsOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("TGPT_HISTORY_FILE", filepath.Join(dir, "history.jsonl"))
+	prevShell, prevOptions := ShellName, ShellOptions
+	ShellName, ShellOptions = "sh", []string{"-c"}
+	prevConfirm := commandConfirm
+	confirms := 0
+	commandConfirm = func(title string, defaultYes bool) (bool, error) {
+		confirms++
+		return false, nil
+	}
+	defer func() {
+		ShellName, ShellOptions = prevShell, prevOptions
+		commandConfirm = prevConfirm
+	}()
+
+	work := filepath.Join(dir, "work")
+	steps := []string{
+		"mkdir -p " + work,
+		"cd " + work,
+		"touch here",
+		"exit 2",
+		"touch after",
+	}
+	if err := runScript(steps, false, "set up a work dir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(work, "here")); err != nil {
+		t.Errorf("expected the working directory to carry over to the next step: %v", err)
+	}
+	if confirms != 1 {
+		t.Errorf("expected to be asked once whether to continue, got %d", confirms)
+	}
+	if _, err := os.Stat(filepath.Join(work, "after")); err == nil {
+		t.Error("expected the script to stop after the failed step")
+	}
+	entries, err := ReadCommandHistory()
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("expected the four steps that ran in the history, got %+v (%v)", entries, err)
+	}
+	if e := entries[2]; e.Command != "touch here" || e.Dir != work || e.Prompt != "set up a work dir" {
+		t.Errorf("expected a step to be recorded with the directory it ran in, got %+v", e)
+	}
+	if e := entries[3]; e.ExitCode == nil || *e.ExitCode != 2 {
+		t.Errorf("expected the failed step's exit status to be recorded, got %+v", e)
+	}
+}
+
+func TestRunCapturedCommandRecordsExitStatusAndStreams(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	result := RunCapturedCommand("sh", []string{"-c"}, "echo out; echo err >&2; exit 4", false)
+	if result.ExitCode != 4 || result.Stdout != "out\n" || result.Stderr != "err\n" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	summary := result.Summary()
+	for _, want := range []string{"Executed command: echo out", "Exit status: 4\n", "Duration: ", "Stdout:\nout\n", "Stderr:\nerr\n"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("expected the summary to contain %q, got %q", want, summary)
+		}
+	}
+
+	long := CommandResult{Command: "yes", Stdout: strings.Repeat("x", 2*commandResultLimit) + "last line\n"}
+	summary = long.Summary()
+	if !strings.Contains(summary, "characters omitted") || !strings.Contains(summary, "last line") || !strings.Contains(summary, "Stderr: (empty)") {
+		t.Errorf("expected long output to be shortened keeping its end, got %d characters", len(summary))
+	}
+}
+
+func TestExtractCommands(t *testing.T) {
+	response := "First create the venv <cmd>python3 -m venv .venv</cmd> then install:\n<cmd>.venv/bin/pip install -r requirements.txt</cmd><cmd> </cmd>"
+	got := ExtractCommands(response)
+	if len(got) != 2 || got[0] != "python3 -m venv .venv" || got[1] != ".venv/bin/pip install -r requirements.txt" {
+		t.Errorf("unexpected commands %q", got)
+	}
+	if got := ExtractCommands("no commands here"); len(got) != 0 {
+		t.Errorf("expected no commands, got %q", got)
+	}
+}
+
+func TestChooseCommandsEditThenPick(t *testing.T) {
+	prevMenu, prevEdit, prevConfirm := commandMenu, editCommand, commandConfirm
+	menus := []int{checklistEdit, checklistChoose}
+	commandMenu = func(title string, options []string, defaultIndex int) (int, string, error) {
+		c := menus[0]
+		menus = menus[1:]
+		return c, options[c], nil
+	}
+	editCommand = func(prompt, value string) (string, bool, error) {
+		if value == "echo two" {
+			return "echo 2", false, nil
+		}
+		return "", true, nil
+	}
+	var asked []string
+	commandConfirm = func(title string, defaultYes bool) (bool, error) {
+		asked = append(asked, title)
+		return !strings.Contains(title, "echo three"), nil
+	}
+	defer func() { commandMenu, editCommand, commandConfirm = prevMenu, prevEdit, prevConfirm }()
+
+	choices, err := ChooseCommands([]string{"echo one", "echo two", "echo three"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CommandChoice{{"echo one", true}, {"echo 2", true}, {"echo three", false}}
+	for i := range want {
+		if choices[i] != want[i] {
+			t.Errorf("choice %d: expected %+v, got %+v", i+1, want[i], choices[i])
+		}
+	}
+	if len(asked) != 3 || len(menus) != 0 {
+		t.Errorf("expected two menus and a question per command, got %d questions", len(asked))
+	}
+}
+
+func TestChooseCommandsAutoExec(t *testing.T) {
+	prevMenu := commandMenu
+	var defaults []int
+	commandMenu = func(title string, options []string, defaultIndex int) (int, string, error) {
+		defaults = append(defaults, defaultIndex)
+		return checklistSkip, options[checklistSkip], nil
+	}
+	defer func() { commandMenu = prevMenu }()
+
+	choices, _ := ChooseCommands([]string{"echo one", "echo two"}, true)
+	if !choices[0].Run || !choices[1].Run || len(defaults) != 0 {
+		t.Errorf("expected safe commands to run with -y, got %+v", choices)
+	}
+
+	choices, _ = ChooseCommands([]string{"echo one", "rm -rf ~"}, true)
+	if choices[0].Run || choices[1].Run || len(defaults) != 1 || defaults[0] != checklistChoose {
+		t.Errorf("expected a high-risk command to show the checklist with choose preselected, got %+v (%v)", choices, defaults)
+	}
+}
+
+func TestAddToShellHistoryFormats(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("history paths differ on Windows")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("HISTFILE", "")
+	t.Setenv("ZDOTDIR", "")
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	for _, dir := range []string{".local/share/fish", ".config/nushell", ".local/share/powershell/PSReadLine"} {
+		if err := os.MkdirAll(filepath.Join(home, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(home, ".config/nushell/history.txt"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	prevShell := ShellName
+	defer func() { ShellName = prevShell }()
+
+	tests := []struct {
+		shell, file, want string
+	}{
+		{"/bin/bash", ".bash_history", "echo 'a\\b'\nls\n"},
+		{"/usr/bin/zsh", ".zsh_history", ":0;echo 'a\\b'\\\nls\n"},
+		{"/usr/bin/fish", ".local/share/fish/fish_history", "- cmd: echo 'a\\\\b'\\nls\n  when: "},
+		{"/usr/bin/nu", ".config/nushell/history.txt", "echo 'a\\b'<\\n>ls\n"},
+		{"/usr/bin/pwsh", ".local/share/powershell/PSReadLine/ConsoleHost_history.txt", "echo 'a\\b'`\nls\n"},
+	}
+	for _, tt := range tests {
+		ShellName = tt.shell
+		AddToShellHistory("echo 'a\\b'\nls")
+		data, err := os.ReadFile(filepath.Join(home, tt.file))
+		if err != nil {
+			t.Errorf("%s: %v", tt.shell, err)
+			continue
+		}
+		if !strings.Contains(string(data), tt.want) {
+			t.Errorf("%s: expected history to contain %q, got %q", tt.shell, tt.want, data)
+		}
+	}
+
+	ShellName = "/bin/sh"
+	AddToShellHistory("ls")
+	if entries, _ := os.ReadDir(home); len(entries) != 4 {
+		t.Errorf("expected sh to leave no history file, found %d entries in home", len(entries))
+	}
+}
+
+func TestShellAdapterAliases(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not installed")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	config := filepath.Join(home, ".bashrc")
+	if err := os.WriteFile(config, []byte("alias greet='echo hello from alias'\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prevShell, prevOptions, prevConfig := ShellName, ShellOptions, ShellConfigFile
+	ShellName, ShellOptions, ShellConfigFile = bash, []string{"-c"}, config
+	defer func() { ShellName, ShellOptions, ShellConfigFile = prevShell, prevOptions, prevConfig }()
+
+	result := RunCapturedCommand(ShellName, ShellOptions, "greet", true)
+	if result.ExitCode != 0 || result.Stdout != "hello from alias\n" {
+		t.Errorf("expected the alias from .bashrc to run, got %+v", result)
+	}
+
+	if shellFor("/usr/local/bin/dash").kind != "sh" || shellFor("pwsh.exe").kind != "pwsh" {
+		t.Error("expected unknown shells to fall back to sh and pwsh.exe to be recognised")
+	}
+	if args := shellFor("nu").aliasArgs([]string{"-c"}, filepath.Join(home, "missing.nu"), "ls"); len(args) != 2 {
+		t.Errorf("expected nu to skip a missing config file, got %q", args)
+	}
+}
+
+func TestSuggestCommandPrintsOnlyTheCommand(t *testing.T) {
+	server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		for _, part := range []string{"```bash\n", "find . -size +100M", "\n```\n"} {
+			b, _ := json.Marshal(map[string]string{"type": "text-delta", "delta": part})
+			_, _ = w.Write([]byte("data: " + string(b) + "\n"))
+		}
+	}))
+	defer server.Close()
+	t.Setenv("TGPT_HISTORY_FILE", filepath.Join(t.TempDir(), "history.jsonl"))
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	err = SuggestCommand("find large files", structs.Params{Provider: "fx", Url: server.URL})
+	w.Close()
+	os.Stdout = stdout
+	out, _ := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "find . -size +100M\n" {
+		t.Errorf("expected only the command on stdout, got %q", out)
+	}
+	if entries, _ := ReadCommandHistory(); len(entries) != 1 || entries[0].Executed || entries[0].Prompt != "find large files" {
+		t.Errorf("expected the suggestion in the history as not run, got %+v", entries)
+	}
+}
+
+func TestShellInitScript(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "/usr/bin/fish"} {
+		script, err := ShellInitScript(shell)
+		if err != nil {
+			t.Fatalf("%s: %v", shell, err)
+		}
+		if !strings.Contains(script, "tgpt --suggest -- ") {
+			t.Errorf("%s: expected the snippet to pass the line to tgpt --suggest after --", shell)
+		}
+		name := filepath.Base(shell)
+		if path, err := exec.LookPath(name); err == nil {
+			check := exec.Command(path, "-n")
+			check.Stdin = strings.NewReader(script)
+			if out, err := check.CombinedOutput(); err != nil {
+				t.Errorf("%s: snippet does not parse: %v\n%s", name, err, out)
+			}
+		}
+	}
+	if _, err := ShellInitScript("tcsh"); err == nil || !strings.Contains(err.Error(), "supported: bash, zsh, fish") {
+		t.Errorf("expected an unsupported shell to fail, got %v", err)
+	}
+
+	// A line starting with a dash must reach tgpt as the prompt, not a flag.
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		return
+	}
+	bin := t.TempDir()
+	fake := "#!/bin/sh\nprintf '%s|' \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "tgpt"), []byte(fake), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	run := shellInitScripts["bash"] + `READLINE_LINE="--version"; __tgpt_suggest; printf %s "$READLINE_LINE"`
+	out, err := exec.Command(bash, "--norc", "-c", run).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out); got != "--suggest|--|--version|" {
+		t.Errorf("expected the line to follow --, tgpt got %q", got)
+	}
+}
+
+func TestShellHookCreatesPrivateStateDir(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	state := filepath.Join(t.TempDir(), "tgpt")
+	t.Setenv("TGPT_LAST_FILE", filepath.Join(state, "last_command"))
+	t.Setenv("TGPT_CAPTURE_OUTPUT", "")
+	if out, err := exec.Command(bash, "--norc", "-c", shellHookScripts["bash"]).CombinedOutput(); err != nil {
+		t.Fatalf("the hook failed: %v\n%s", err, out)
+	}
+	info, err := os.Stat(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o700 {
+		t.Errorf("expected the state directory to be private, got %v", info.Mode().Perm())
+	}
+}
+
+func TestReadLastCommand(t *testing.T) {
+	dir := t.TempDir()
+	state := filepath.Join(dir, "last_command")
+	t.Setenv("TGPT_LAST_FILE", state)
+
+	if _, err := ReadLastCommand(); err == nil || !strings.Contains(err.Error(), "--shell-init") {
+		t.Errorf("expected a hint about --shell-init when nothing was recorded, got %v", err)
+	}
+
+	log := filepath.Join(dir, "session.log")
+	earlier := "$ ls\nold output\n"
+	output := "\x1b[1;31mmake: *** [build] Error 1\x1b[0m\r\nprogress 10%\rprogress 100%\r\n"
+	if err := os.WriteFile(log, []byte(earlier+output+"$ "), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	record := fmt.Sprintf("exit: 2\ncwd: /src/app\ntime: %d\nlog: %s\nstart: %d\nend: %d\ncommand: make \\\n  build\n",
+		time.Now().Add(-time.Minute).Unix(), log, len(earlier), len(earlier)+len(output))
+	if err := os.WriteFile(state, []byte(record), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := ReadLastCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Command != "make \\\n  build" || last.ExitCode != 2 || last.Dir != "/src/app" {
+		t.Errorf("unexpected record %+v", last)
+	}
+	if last.Output != "make: *** [build] Error 1\nprogress 100%" {
+		t.Errorf("expected the command's output without escapes, got %q", last.Output)
+	}
+	context := last.Context()
+	for _, want := range []string{"Command: make \\\n  build", "Exit status: 2", "Ran: 1m", "Output:\nmake: ***"} {
+		if !strings.Contains(context, want) {
+			t.Errorf("expected the context to contain %q, got %q", want, context)
+		}
+	}
+	if !strings.Contains(last.Question(), "fail") {
+		t.Errorf("expected the default question to ask about the failure, got %q", last.Question())
+	}
+}
+
+func TestBashHookRecordsLastCommand(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil || runtime.GOOS == "windows" {
+		t.Skip("bash not available")
+	}
+	dir := t.TempDir()
+	state := filepath.Join(dir, "state", "last_command")
+	script, _ := ShellInitScript("bash")
+	rc := filepath.Join(dir, "bashrc")
+	if err := os.WriteFile(rc, []byte(script), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(bash, "--rcfile", rc, "-i")
+	cmd.Env = append(os.Environ(), "TGPT_LAST_FILE="+state, "HISTFILE="+filepath.Join(dir, "history"), "TGPT_CAPTURE_OUTPUT=")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader("ls " + filepath.Join(dir, "missing") + "\n\ntgpt --last\nexit 0\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("bash failed: %v\n%s", err, out)
+	}
+
+	t.Setenv("TGPT_LAST_FILE", state)
+	last, err := ReadLastCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Command != "ls "+filepath.Join(dir, "missing") || last.ExitCode == 0 || last.Dir != dir {
+		t.Errorf("expected the failed ls to be recorded and tgpt to be left out, got %+v", last)
+	}
+}
+
+func TestSearchHistory(t *testing.T) {
+	entries := []HistoryEntry{
+		{Prompt: "list docker containers", Command: "docker ps -a"},
+		{Prompt: "find large files", Command: "find . -size +100M"},
+		{Prompt: "stop all containers", Command: "docker stop $(docker ps -q)"},
+		{Prompt: "show disk usage", Command: "df -h"},
+	}
+	if got := SearchHistory(entries, ""); len(got) != 4 || got[0] != 3 {
+		t.Errorf("expected every entry, newest first, for an empty query, got %v", got)
+	}
+	if got := SearchHistory(entries, "dkr ps"); len(got) != 2 || got[0] != 0 {
+		t.Errorf("expected the fuzzy match on the command to rank first, got %v", got)
+	}
+	if got := SearchHistory(entries, "large"); len(got) != 1 || got[0] != 1 {
+		t.Errorf("expected a match on the prompt, got %v", got)
+	}
+	if got := SearchHistory(entries, "kubectl"); len(got) != 0 {
+		t.Errorf("expected no matches, got %v", got)
+	}
+}
+
+func TestShowCommandHistoryRunsSelectedEntry(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("TGPT_HISTORY_FILE", filepath.Join(dir, "history.jsonl"))
+	t.Setenv("SHELL", "/bin/sh")
+	work := filepath.Join(dir, "work")
+	if err := os.Mkdir(work, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ShowCommandHistory("", structs.Params{}, true); err == nil {
+		t.Error("expected an error when nothing has been recorded")
+	}
+	RecordCommand(HistoryEntry{Prompt: "make a marker", Command: "touch marker", Dir: work})
+	RecordNotRun("say hi", "echo hi")
+
+	prevHistory, prevMenu := historyMenu, commandMenu
+	historyMenu = func(title string, items []string, filter func(string) []int, query string) (int, error) {
+		matches := filter(query)
+		if len(matches) != 1 || !strings.Contains(items[matches[0]], "not run") {
+			t.Errorf("expected one unrun match for %q, got %v of %q", query, matches, items)
+		}
+		return matches[0], nil
+	}
+	commandMenu = func(title string, options []string, defaultIndex int) (int, string, error) {
+		return commandExecute, options[commandExecute], nil
+	}
+	defer func() { historyMenu, commandMenu = prevHistory, prevMenu }()
+
+	if err := ShowCommandHistory("marker", structs.Params{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(work, "marker")); err != nil {
+		t.Errorf("expected the selected command to run in the directory it was recorded in: %v", err)
+	}
+	entries, _ := ReadCommandHistory()
+	if len(entries) != 3 || !entries[2].Executed || entries[2].Prompt != "make a marker" || entries[2].Dir != work {
+		t.Errorf("expected the re-run to be recorded under the same prompt and directory, got %+v", entries)
+	}
+}
@@ -0,0 +1,261 @@
+package helper
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aandrew-me/tgpt/v2/src/bubbletea"
+	"github.com/aandrew-me/tgpt/v2/src/structs"
+)
+
+// historyMenu picks an entry in --history. Tests replace it.
+var historyMenu = bubbletea.FilterMenu
+
+// HistoryEntry is a command generated by tgpt, with the request it answered
+// and what became of it.
+type HistoryEntry struct {
+	Time     time.Time `json:"time"`
+	Prompt   string    `json:"prompt,omitempty"`
+	Command  string    `json:"command"`
+	Executed bool      `json:"executed"`
+	ExitCode *int      `json:"exit_code,omitempty"` // nil if the command was not run or did not exit normally
+	Dir      string    `json:"cwd,omitempty"`
+	Shell    string    `json:"shell,omitempty"`
+}
+
+// CommandHistoryPath returns the file generated commands are recorded in:
+// $TGPT_HISTORY_FILE, or tgpt/command_history.jsonl under $XDG_STATE_HOME
+// (~/.local/state by default).
+func CommandHistoryPath() string {
+	if path := os.Getenv("TGPT_HISTORY_FILE"); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "tgpt", "command_history.jsonl")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "state", "tgpt", "command_history.jsonl")
+}
+
+// RecordCommand appends e to the command history, filling in the time,
+// directory and shell when they are not set. Failures are only reported, so
+// recording never gets in the way of running the command.
+func RecordCommand(e HistoryEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Dir == "" {
+		e.Dir, _ = os.Getwd()
+	}
+	if e.Shell == "" {
+		e.Shell = currentShell().kind
+	}
+	line, err := json.Marshal(e)
+	if err == nil {
+		path := CommandHistoryPath()
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			var f *os.File
+			if f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err == nil {
+				_, err = f.Write(append(line, '\n'))
+				if closeErr := f.Close(); err == nil {
+					err = closeErr
+				}
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record the command in the history: %v\n", err)
+	}
+}
+
+// recordRun records a command that was run in dir (the current directory if
+// empty) and exited with code, -1 if it did not exit normally.
+func recordRun(prompt, command, dir string, code int) {
+	e := HistoryEntry{Prompt: prompt, Command: command, Executed: true, Dir: dir}
+	if code >= 0 {
+		e.ExitCode = &code
+	}
+	RecordCommand(e)
+}
+
+// RecordResult records a command run in interactive shell mode.
+func RecordResult(prompt string, r CommandResult) {
+	recordRun(prompt, r.Command, "", r.ExitCode)
+}
+
+// RecordNotRun records commands that were generated but not run.
+func RecordNotRun(prompt string, commands ...string) {
+	for _, command := range commands {
+		RecordCommand(HistoryEntry{Prompt: prompt, Command: command})
+	}
+}
+
+// exitStatus returns the exit status of a command that returned err, or -1
+// if it did not exit normally.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// ReadCommandHistory returns the recorded commands, oldest first. Lines that
+// cannot be parsed are skipped.
+func ReadCommandHistory() ([]HistoryEntry, error) {
+	file, err := os.Open(CommandHistoryPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err == nil && e.Command != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// SearchHistory returns the indexes of the entries whose prompt or command
+// fuzzy-matches query, best match first and newest first among equals. An
+// empty query matches every entry.
+func SearchHistory(entries []HistoryEntry, query string) []int {
+	type match struct{ index, score int }
+	var matches []match
+	for i, e := range entries {
+		score := max(fuzzyScore(query, e.Command), fuzzyScore(query, e.Prompt))
+		if score >= 0 {
+			matches = append(matches, match{i, score})
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		if matches[a].score != matches[b].score {
+			return matches[a].score > matches[b].score
+		}
+		return matches[a].index > matches[b].index
+	})
+	indexes := make([]int, len(matches))
+	for i, m := range matches {
+		indexes[i] = m.index
+	}
+	return indexes
+}
+
+// fuzzyScore returns how well text matches query, whose words must each
+// appear in text as a subsequence of its characters, ignoring case. Letters
+// that follow each other or start a word score higher. It returns -1 if text
+// does not match.
+func fuzzyScore(query, text string) int {
+	runes := []rune(strings.ToLower(text))
+	total := 0
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		score, pos, last := 0, 0, -2
+		for _, q := range word {
+			for pos < len(runes) && runes[pos] != q {
+				pos++
+			}
+			if pos == len(runes) {
+				return -1
+			}
+			score++
+			if pos == last+1 {
+				score += 2
+			}
+			if pos == 0 || !unicode.IsLetter(runes[pos-1]) && !unicode.IsDigit(runes[pos-1]) {
+				score += 3
+			}
+			last = pos
+			pos++
+		}
+		total += score
+	}
+	return total
+}
+
+// historyLabel is how an entry is shown in the --history list.
+func historyLabel(e HistoryEntry) string {
+	status := "not run"
+	if e.Executed {
+		status = "stopped"
+		if e.ExitCode != nil {
+			status = fmt.Sprintf("exit %d", *e.ExitCode)
+		}
+	}
+	label := fmt.Sprintf("%s  %-8s  %s", e.Time.Local().Format("2006-01-02 15:04"), status, strings.ReplaceAll(e.Command, "\n", " ⏎ "))
+	if e.Prompt != "" {
+		label += "  # " + strings.Join(strings.Fields(e.Prompt), " ")
+	}
+	return label
+}
+
+// ShowCommandHistory lets the user search the commands tgpt generated and
+// run, explain, edit or copy one of them as with -s, in the directory it was
+// recorded in if that still exists. query is the initial search. When stdout
+// is not a terminal the matches are printed instead, one command per line.
+func ShowCommandHistory(query string, params structs.Params, interactive bool) error {
+	entries, err := ReadCommandHistory()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no commands have been recorded yet; commands generated with -s, -is or --suggest are recorded in %s", CommandHistoryPath())
+	}
+
+	if !interactive {
+		for _, i := range SearchHistory(entries, query) {
+			fmt.Println(entries[i].Command)
+		}
+		return nil
+	}
+
+	labels := make([]string, len(entries))
+	for i, e := range entries {
+		labels[i] = historyLabel(e)
+	}
+	index, err := historyMenu("Command history", labels, func(q string) []int { return SearchHistory(entries, q) }, query)
+	if errors.Is(err, bubbletea.ErrCanceled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	entry := entries[index]
+	SetShellAndOSVars()
+	if entry.Prompt != "" {
+		bold.Printf("%s\n", entry.Prompt)
+	}
+	codeText.Println(entry.Command)
+	// Commands often depend on where they ran, so run it there again.
+	opts := structs.ExtraOptions{ShellPrompt: entry.Prompt}
+	if entry.Dir != "" {
+		if info, err := os.Stat(entry.Dir); err == nil && info.IsDir() {
+			opts.ShellDir = entry.Dir
+			faint.Printf("Runs in %s, where it was recorded\n", entry.Dir)
+		} else {
+			wd, _ := os.Getwd()
+			faint.Printf("Recorded in %s, which no longer exists; runs in %s\n", entry.Dir, wd)
+		}
+	}
+	return RunGeneratedCommand(entry.Command, params, opts)
+}
@@ -0,0 +1,169 @@
+package helper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lastOutputLimit is how many characters of the last command's output are
+// passed on with --last.
+const lastOutputLimit = 6000
+
+// lastLogReadLimit caps how much of the session log is read for one command.
+const lastLogReadLimit = 1 << 20
+
+var ansiEscapeRe = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[()][0-9A-Za-z]|\x1b[=>78]`)
+
+// LastCommand is what the hook from --shell-init recorded about the last
+// command run in the user's shell.
+type LastCommand struct {
+	Command  string
+	ExitCode int
+	Dir      string
+	Time     time.Time
+	Output   string // empty unless output capture is on
+}
+
+// LastCommandPath returns the state file the shell hook writes:
+// $TGPT_LAST_FILE, or tgpt/last_command under $XDG_STATE_HOME
+// (~/.local/state by default).
+func LastCommandPath() string {
+	if path := os.Getenv("TGPT_LAST_FILE"); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "tgpt", "last_command")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "state", "tgpt", "last_command")
+}
+
+// ReadLastCommand reads the last command recorded by the shell hook, with its
+// output if the session is being captured.
+func ReadLastCommand() (*LastCommand, error) {
+	path := LastCommandPath()
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no command has been recorded yet; add the hook from `tgpt --shell-init <bash|zsh|fish>` to your shell config")
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	last := &LastCommand{}
+	var logPath string
+	var start, end int64 = -1, -1
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	var command []string
+	inCommand := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inCommand {
+			command = append(command, line)
+			continue
+		}
+		key, value, _ := strings.Cut(line, ": ")
+		switch key {
+		case "exit":
+			last.ExitCode, _ = strconv.Atoi(strings.TrimSpace(value))
+		case "cwd":
+			last.Dir = value
+		case "time":
+			if secs, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+				last.Time = time.Unix(secs, 0)
+			}
+		case "log":
+			logPath = value
+		case "start":
+			start, _ = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		case "end":
+			end, _ = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		case "command":
+			// The command comes last and may span several lines.
+			command = append(command, value)
+			inCommand = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	last.Command = strings.TrimSpace(strings.Join(command, "\n"))
+	if last.Command == "" {
+		return nil, fmt.Errorf("%s does not hold a command", path)
+	}
+	if logPath != "" && start >= 0 && end > start {
+		last.Output = readLogRange(logPath, start, end)
+	}
+	return last, nil
+}
+
+// readLogRange returns the text a captured session printed between start and
+// end, without terminal escape sequences.
+func readLogRange(path string, start, end int64) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	if end-start > lastLogReadLimit {
+		start = end - lastLogReadLimit
+	}
+	data, err := io.ReadAll(io.NewSectionReader(file, start, end-start))
+	if err != nil && len(data) == 0 {
+		return ""
+	}
+	return cleanTerminalOutput(string(data))
+}
+
+// cleanTerminalOutput strips escape sequences and, for lines redrawn with a
+// carriage return such as progress bars, keeps only what was drawn last.
+func cleanTerminalOutput(text string) string {
+	text = ansiEscapeRe.ReplaceAllString(text, "")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if j := strings.LastIndex(line, "\r"); j >= 0 {
+			line = line[j+1:]
+		}
+		lines[i] = line
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// Question is what --last asks when no prompt is given.
+func (c LastCommand) Question() string {
+	if c.ExitCode != 0 {
+		return "Why did this command fail, and how do I fix it?"
+	}
+	return "Explain what this command did and what its output means."
+}
+
+// Context describes the command for the model.
+func (c LastCommand) Context() string {
+	var b strings.Builder
+	b.WriteString("Here is the last command I ran in my terminal:\n")
+	fmt.Fprintf(&b, "Command: %s\n", c.Command)
+	if c.Dir != "" {
+		fmt.Fprintf(&b, "Directory: %s\n", c.Dir)
+	}
+	fmt.Fprintf(&b, "Exit status: %d\n", c.ExitCode)
+	if !c.Time.IsZero() {
+		fmt.Fprintf(&b, "Ran: %s ago\n", time.Since(c.Time).Round(time.Second))
+	}
+	if c.Output != "" {
+		fmt.Fprintf(&b, "Output:\n%s\n", truncateMiddle(c.Output, lastOutputLimit))
+	} else {
+		b.WriteString("Output: not captured\n")
+	}
+	return b.String()
+}
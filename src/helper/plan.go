@@ -0,0 +1,138 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aandrew-me/tgpt/v2/src/bubbletea"
+	"github.com/aandrew-me/tgpt/v2/src/structs"
+	"github.com/aandrew-me/tgpt/v2/src/tools"
+)
+
+// planMenu and planConfirm ask how to handle a plan. Tests replace them.
+var (
+	planMenu    = bubbletea.SelectMenu
+	planConfirm = bubbletea.ConfirmMenu
+)
+
+// TogglePlanMode turns plan mode on or off for an interactive session (the
+// /plan command). Turning it off first reviews any steps still queued.
+func TogglePlanMode(params structs.Params) {
+	if tools.DefaultRegistry.Plan() != nil {
+		ReviewPlan(params)
+		tools.DefaultRegistry.SetPlan(nil)
+		bold.Println("Plan mode off: tools run as usual.")
+		return
+	}
+	if len(params.Tools) == 0 {
+		fmt.Fprintln(os.Stderr, "Plan mode needs tools: start tgpt with -t or --mcp.")
+		return
+	}
+	tools.DefaultRegistry.SetPlan(tools.NewPlan())
+	bold.Println("Plan mode on: file changes and commands are queued and reviewed after each reply. Type /plan again to leave it.")
+}
+
+// ReviewPlan shows the steps queued in plan mode and lets the user apply all
+// of them, choose which to apply, or discard them. The plan is left empty.
+func ReviewPlan(params structs.Params) {
+	plan := tools.DefaultRegistry.Plan()
+	if plan == nil {
+		return
+	}
+	steps := plan.Take()
+	if len(steps) == 0 {
+		return
+	}
+
+	noun := "steps"
+	if len(steps) == 1 {
+		noun = "step"
+	}
+	bold.Printf("\nPlan: %d queued %s\n", len(steps), noun)
+	for i, step := range steps {
+		boldBlue.Printf("\n%d. %s\n", i+1, step.Tool)
+		fmt.Println(step.Preview)
+	}
+
+	choice, _, err := planMenu("\nApply the plan?", []string{"Apply all", "Choose steps to apply", "Discard"}, 0)
+	if err != nil {
+		if errors.Is(err, bubbletea.ErrInterrupted) {
+			bubbletea.RestoreTerminal()
+			os.Exit(130)
+		}
+		choice = 2
+	}
+
+	var selected []int
+	switch choice {
+	case 0:
+		for i := range steps {
+			selected = append(selected, i)
+		}
+	case 1:
+		for i, step := range steps {
+			ok, err := planConfirm(fmt.Sprintf("Apply step %d (%s %s)?", i+1, step.Tool, formatToolArgs(step.Arguments)), true)
+			if errors.Is(err, bubbletea.ErrInterrupted) {
+				bubbletea.RestoreTerminal()
+				os.Exit(130)
+			}
+			if err == nil && ok {
+				selected = append(selected, i)
+			}
+		}
+	}
+	if len(selected) == 0 {
+		bold.Println("Plan discarded, nothing was changed.")
+		return
+	}
+	applyPlanSteps(steps, selected, params)
+}
+
+// applyPlanSteps runs the selected steps in order and stops at the first one
+// that fails, since later steps usually depend on it.
+func applyPlanSteps(steps []tools.PlanStep, selected []int, params structs.Params) {
+	for n, i := range selected {
+		step := steps[i]
+		ctx, cmdStatus := tools.WithCommandStatus(context.Background())
+		if step.Tool != "execute_command" {
+			cmdStatus = nil
+		}
+		start := time.Now()
+		out, err := tools.DefaultRegistry.ApplyPlanStep(ctx, step)
+		duration := time.Since(start)
+
+		if audit := tools.DefaultRegistry.AuditLog(); audit != nil {
+			entry := tools.DefaultRegistry.NewAuditEntry(step.Tool, step.Arguments, tools.Decision{Proceed: true, Outcome: tools.OutcomePlanApply}, out, err, cmdStatus, duration)
+			entry.Provider = params.Provider
+			entry.Model = params.ApiModel
+			audit.Record(entry)
+		}
+
+		if err != nil {
+			boldViolet.Printf("Step %d %s(%s) \u274c\n", i+1, step.Tool, formatToolArgs(step.Arguments))
+			if out != "" {
+				fmt.Println(out)
+			} else {
+				fmt.Println(err)
+			}
+			if rest := len(selected) - n - 1; rest > 0 {
+				fmt.Fprintf(os.Stderr, "Stopped: %d remaining %s not applied.\n", rest, pluralSteps(rest))
+			}
+			return
+		}
+		boldViolet.Printf("Step %d %s(%s) \u2705\n", i+1, step.Tool, formatToolArgs(step.Arguments))
+		if step.Tool == "execute_command" && out != "" {
+			fmt.Println(out)
+		}
+	}
+}
+
+func pluralSteps(n int) string {
+	if n == 1 {
+		return "step was"
+	}
+	return "steps were"
+}
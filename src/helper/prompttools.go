@@ -0,0 +1,234 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aandrew-me/tgpt/v2/src/providers"
+	"github.com/aandrew-me/tgpt/v2/src/structs"
+	"github.com/aandrew-me/tgpt/v2/src/tools"
+)
+
+// Providers without native function calling get the tools described in the
+// system prompt and call them by writing an envelope into their reply:
+//
+//	<tool_call>{"name": "read_file", "arguments": {"path": "main.go"}}</tool_call>
+//
+// The results are sent back as the next user message.
+const (
+	toolCallOpenTag  = "<tool_call>"
+	toolCallCloseTag = "</tool_call>"
+)
+
+var toolCallEnvelopeRe = regexp.MustCompile(`(?s)<tool_call>(.*?)</tool_call>`)
+
+// usesPromptTools reports whether tool calls for the request have to go
+// through the system prompt instead of the provider's API.
+func usesPromptTools(params structs.Params) bool {
+	return len(params.Tools) > 0 && !providers.SupportsTools(params.Provider)
+}
+
+// withPromptTools returns the input and params to send to a provider without
+// native function calling: the tool descriptions are added to the system
+// prompt, and for providers that ignore the system prompt and history,
+// everything is folded into the input.
+func withPromptTools(input string, params structs.Params) (string, structs.Params) {
+	params.SystemPrompt = strings.TrimSpace(params.SystemPrompt + "\n\n" + promptToolsInstructions(params.Tools))
+	params.Tools = nil
+
+	if providers.SupportsChatHistory(params.Provider) {
+		return input, params
+	}
+
+	var b strings.Builder
+	b.WriteString(params.SystemPrompt)
+	b.WriteString("\n\n")
+	for _, m := range params.PrevMessages {
+		if msg, ok := m.(structs.DefaultMessage); ok && msg.Content != "" {
+			fmt.Fprintf(&b, "%s: %s\n\n", msg.Role, msg.Content)
+		}
+	}
+	fmt.Fprintf(&b, "user: %s", input)
+	return b.String(), params
+}
+
+func promptToolsInstructions(specs []any) string {
+	var b strings.Builder
+	b.WriteString("You can call tools to help answer. To call a tool, write a block of exactly this form:\n")
+	b.WriteString(toolCallOpenTag + `{"name": "TOOL_NAME", "arguments": {"ARGUMENT": "VALUE"}}` + toolCallCloseTag + "\n")
+	b.WriteString("The content of the block must be a single JSON object and the arguments must match the tool's parameters. ")
+	b.WriteString("You may write several blocks to call several tools. After writing them, stop and wait: the results are sent back in <tool_result> blocks. ")
+	b.WriteString("Only call the tools listed below, and answer normally when no tool is needed.\n\nAvailable tools:\n")
+
+	for _, s := range specs {
+		spec, ok := s.(tools.ToolSpec)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "- %s: %s\n", spec.Function.Name, spec.Function.Description)
+		if params, err := json.Marshal(spec.Function.Parameters); err == nil && len(spec.Function.Parameters) > 0 {
+			fmt.Fprintf(&b, "  parameters: %s\n", params)
+		}
+	}
+	return b.String()
+}
+
+// parsePromptToolCalls extracts the tool call envelopes from a reply. Calls
+// that cannot be parsed are returned as errors to report back to the model.
+func parsePromptToolCalls(text string, depth int) ([]structs.ToolCall, []string) {
+	var calls []structs.ToolCall
+	var errs []string
+	for i, m := range toolCallEnvelopeRe.FindAllStringSubmatch(text, -1) {
+		var envelope struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		body := strings.TrimSpace(m[1])
+		if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+			errs = append(errs, fmt.Sprintf("Invalid tool call %s: %v", body, err))
+			continue
+		}
+		if envelope.Name == "" {
+			errs = append(errs, fmt.Sprintf("Invalid tool call %s: missing \"name\"", body))
+			continue
+		}
+
+		args := "{}"
+		trimmed := bytes.TrimSpace(envelope.Arguments)
+		switch {
+		case len(trimmed) == 0 || string(trimmed) == "null":
+		case trimmed[0] == '"':
+			// Some models send the arguments as a JSON-encoded string.
+			var s string
+			if json.Unmarshal(trimmed, &s) == nil {
+				args = s
+			}
+		default:
+			args = string(trimmed)
+		}
+
+		calls = append(calls, structs.ToolCall{
+			ID:   fmt.Sprintf("call_%d_%d", depth, i),
+			Type: "function",
+			Function: structs.ToolCallFunction{
+				Name:      envelope.Name,
+				Arguments: args,
+			},
+		})
+	}
+	return calls, errs
+}
+
+// handlePromptToolCalls runs the calls parsed from a reply and continues the
+// conversation with their results, like the native tool loop in
+// HandleEachPart but with plain messages the provider understands.
+func handlePromptToolCalls(input, fullText string, calls []structs.ToolCall, callErrors []string, params structs.Params, extraOptions structs.ExtraOptions) (string, []any) {
+	shown := stripPromptToolCalls(fullText)
+	if shown != "" && !strings.HasSuffix(shown, "\n") {
+		fmt.Println()
+	}
+
+	turnMessages := make([]any, 0)
+	if input != "" {
+		userMsg := structs.DefaultMessage{Role: "user", Content: input}
+		params.PrevMessages = append(params.PrevMessages, userMsg)
+		turnMessages = append(turnMessages, userMsg)
+	}
+	assistantMsg := structs.DefaultMessage{Role: "assistant", Content: fullText}
+	params.PrevMessages = append(params.PrevMessages, assistantMsg)
+	turnMessages = append(turnMessages, assistantMsg)
+
+	for _, e := range callErrors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	results := formatPromptToolResults(executeToolCalls(calls, params, extraOptions), callErrors)
+
+	followUpOptions := extraOptions
+	followUpOptions.IsToolFollowUp = true
+	followUpOptions.ToolDepth++
+
+	followUpText, followUpTurnMessages, _ := MakeRequestAndGetData(results, params, followUpOptions)
+	if len(followUpTurnMessages) > 0 {
+		return shown + followUpText, append(turnMessages, followUpTurnMessages...)
+	}
+	return shown + followUpText, append(turnMessages,
+		structs.DefaultMessage{Role: "user", Content: results},
+		structs.DefaultMessage{Role: "assistant", Content: followUpText},
+	)
+}
+
+// formatPromptToolResults builds the message that returns tool results to a
+// model using prompt-based tool calls.
+func formatPromptToolResults(results []structs.ToolMessage, errs []string) string {
+	var b strings.Builder
+	for _, r := range results {
+		fmt.Fprintf(&b, "<tool_result name=%q>\n%s\n</tool_result>\n", r.Name, r.Content)
+	}
+	for _, e := range errs {
+		fmt.Fprintf(&b, "<tool_result error=\"true\">\n%s\n</tool_result>\n", e)
+	}
+	b.WriteString("Continue with these results: call more tools if needed, otherwise answer the original request.")
+	return b.String()
+}
+
+// stripPromptToolCalls removes the tool call envelopes from a reply.
+func stripPromptToolCalls(text string) string {
+	return toolCallEnvelopeRe.ReplaceAllString(text, "")
+}
+
+// toolCallFilter hides tool call envelopes from streamed output. Text that
+// could be the start of a tag is held back until it is known not to be one.
+type toolCallFilter struct {
+	pending strings.Builder
+	inCall  bool
+}
+
+func (f *toolCallFilter) write(text string) string {
+	var out strings.Builder
+	for _, ch := range text {
+		f.feed(ch, &out)
+	}
+	return out.String()
+}
+
+func (f *toolCallFilter) feed(ch rune, out *strings.Builder) {
+	f.pending.WriteRune(ch)
+	p := f.pending.String()
+	tag := toolCallOpenTag
+	if f.inCall {
+		tag = toolCallCloseTag
+	}
+
+	switch {
+	case p == tag:
+		f.inCall = !f.inCall
+		f.pending.Reset()
+	case strings.HasPrefix(tag, p):
+		// Could still become the tag.
+	default:
+		// Not the tag: release the first character and look for a tag
+		// starting in the rest.
+		f.pending.Reset()
+		first, rest := []rune(p)[0], []rune(p)[1:]
+		if !f.inCall {
+			out.WriteRune(first)
+		}
+		for _, r := range rest {
+			f.feed(r, out)
+		}
+	}
+}
+
+// flush returns text held back at the end of the stream.
+func (f *toolCallFilter) flush() string {
+	p := f.pending.String()
+	f.pending.Reset()
+	if f.inCall {
+		return ""
+	}
+	return p
+}
@@ -0,0 +1,330 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/aandrew-me/tgpt/v2/src/bubbletea"
+	"github.com/aandrew-me/tgpt/v2/src/clipboard"
+	"github.com/aandrew-me/tgpt/v2/src/structs"
+	"github.com/aandrew-me/tgpt/v2/src/tools"
+)
+
+// commandConfirm asks yes/no questions about a script. Tests replace it.
+var commandConfirm = bubbletea.ConfirmMenu
+
+var (
+	stepNumberRe  = regexp.MustCompile(`^\d+[.)]\s+`)
+	heredocRe     = regexp.MustCompile(`<<-?\s*['"]?([A-Za-z_][A-Za-z0-9_]*)['"]?`)
+	commandSepRe  = regexp.MustCompile(`&&|\|\||[;|\n]`)
+	assignmentRe  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+	psAssignRe    = regexp.MustCompile(`^\$(?:env:)?[A-Za-z_][A-Za-z0-9_]*\s*=`)
+	funcDefRe     = regexp.MustCompile(`^(?:function\s+[A-Za-z_][A-Za-z0-9_-]*|[A-Za-z_][A-Za-z0-9_-]*\s*\(\s*\))`)
+	environmentRe = regexp.MustCompile(`(?:^|[\s/])activate(?:\s|$|\.)|^(?:nvm\s+use|pyenv\s+shell|workon)\b`)
+)
+
+// Choices offered for a generated script.
+const (
+	scriptRunAll = iota
+	scriptStepByStep
+	scriptSave
+	scriptCopy
+)
+
+// Choices offered for each step when running step by step.
+const (
+	stepRun = iota
+	stepSkip
+	stepStop
+)
+
+// ParseScriptSteps splits the output of -s into the commands to run:
+// code fences, blank lines, comments, "$ " prompts and "1." numbering are
+// dropped, and lines continued with a backslash or holding a heredoc are
+// kept together as one step.
+func ParseScriptSteps(text string) []string {
+	var steps []string
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "```") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = stepNumberRe.ReplaceAllString(line, "")
+		line = strings.TrimPrefix(line, "$ ")
+
+		step := line
+		for strings.HasSuffix(step, "\\") && i+1 < len(lines) {
+			i++
+			step += "\n" + lines[i]
+		}
+		if m := heredocRe.FindStringSubmatch(step); m != nil {
+			for i+1 < len(lines) {
+				i++
+				step += "\n" + lines[i]
+				if strings.TrimSpace(lines[i]) == m[1] {
+					break
+				}
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// RunGeneratedScript shows a multi-step result of -s as a numbered script and
+// asks whether to run all of it, run it step by step, save it to a file or
+// copy it. With -y it runs all steps unless one of them is high-risk.
+func RunGeneratedScript(steps []string, params structs.Params, extraOptions structs.ExtraOptions) error {
+	anyRisky, lostState := false, false
+	bold.Printf("\nScript: %d steps\n", len(steps))
+	for i, step := range steps {
+		codeText.Printf("%d. %s\n", i+1, step)
+		if risks := tools.AnalyzeCommand(step); len(risks) > 0 {
+			anyRisky = true
+			fmt.Println(indent(tools.FormatCommandRisks(risks), "   "))
+		}
+		// The last step has no later steps to lose its state for.
+		if change := shellStateChange(step); change != "" && i < len(steps)-1 {
+			lostState = true
+			faint.Printf("   Note: %s, which the next steps will not see\n", change)
+		}
+	}
+	if lostState {
+		faint.Println("Each step runs in its own shell, so only the working directory carries over. " +
+			"Save the script and source it to keep variables, functions and environments.")
+	}
+
+	if extraOptions.AutoExec && !anyRisky {
+		return runScript(steps, false, extraOptions.ShellPrompt)
+	}
+
+	defaultChoice := scriptRunAll
+	if anyRisky {
+		defaultChoice = scriptCopy
+	}
+	choice, _, err := commandMenu("\nRun the script?", []string{"Run all steps", "Run step by step", "Save to a file", "Copy to clipboard"}, defaultChoice)
+	if err != nil {
+		if errors.Is(err, bubbletea.ErrInterrupted) {
+			return err
+		}
+		choice = scriptCopy
+	}
+
+	switch choice {
+	case scriptRunAll:
+		if anyRisky {
+			ok, err := commandConfirm("\nThe script has high-risk steps. Run all of them?", false)
+			if err != nil || !ok {
+				return err
+			}
+		}
+		return runScript(steps, false, extraOptions.ShellPrompt)
+	case scriptStepByStep:
+		return runScript(steps, true, extraOptions.ShellPrompt)
+	case scriptSave:
+		RecordNotRun(extraOptions.ShellPrompt, steps...)
+		return saveScript(steps)
+	default:
+		RecordNotRun(extraOptions.ShellPrompt, steps...)
+		clipboard.CopyToClipboard(strings.Join(steps, "\n"))
+		return nil
+	}
+}
+
+// shellStateChange describes the shell state step sets that is lost when the
+// shell exits: variables, functions, sourced files, activated environments
+// and shell settings. It returns "" for steps that set none. The check is a
+// heuristic on the words that start each command of the step.
+func shellStateChange(step string) string {
+	for _, part := range commandSepRe.Split(step, -1) {
+		part = strings.TrimLeft(strings.TrimSpace(part), "({ ")
+		words := strings.Fields(part)
+		if len(words) == 0 {
+			continue
+		}
+		switch {
+		case environmentRe.MatchString(part):
+			return "it activates an environment"
+		case funcDefRe.MatchString(part):
+			return "it defines a function"
+		case psAssignRe.MatchString(part):
+			return "it sets a variable"
+		}
+		switch words[0] {
+		case "export", "declare", "typeset", "readonly", "local", "unset":
+			return "it sets a variable"
+		case "set": // shell options in sh, variables in fish and cmd
+			return "it sets a variable or shell option"
+		case "source", ".":
+			return "it sources a file"
+		case "alias", "unalias", "shopt", "setopt", "unsetopt", "ulimit", "umask":
+			return "it changes a shell setting"
+		}
+		// NAME=value on its own sets a variable; before a command it only
+		// applies to that command.
+		allAssignments := true
+		for _, w := range words {
+			if !assignmentRe.MatchString(w) {
+				allAssignments = false
+				break
+			}
+		}
+		if allAssignments {
+			return "it sets a variable"
+		}
+	}
+	return ""
+}
+
+// runScript runs steps in order, asking before each one when stepByStep is
+// set. It stops at the first step that fails unless the user chooses to go
+// on. Each step runs in its own shell; the working directory carries over.
+// Steps are recorded in the command history under prompt.
+func runScript(steps []string, stepByStep bool, prompt string) error {
+	dir, _ := os.Getwd()
+	ran := 0
+	for i, step := range steps {
+		if stepByStep {
+			risks := tools.AnalyzeCommand(step)
+			defaultChoice := stepRun
+			if len(risks) > 0 {
+				defaultChoice = stepSkip
+			}
+			choice, _, err := commandMenu(CommandPrompt(fmt.Sprintf("\nStep %d/%d: %s", i+1, len(steps), step), risks),
+				[]string{"Run", "Skip", "Stop"}, defaultChoice)
+			if err != nil {
+				if errors.Is(err, bubbletea.ErrInterrupted) {
+					return err
+				}
+				choice = stepStop
+			}
+			if choice == stepSkip {
+				RecordNotRun(prompt, step)
+				continue
+			}
+			if choice == stepStop {
+				break
+			}
+		} else {
+			boldBlue.Printf("\n[%d/%d] %s\n", i+1, len(steps), step)
+		}
+
+		start := dir
+		var code int
+		code, dir = runScriptStep(step, dir)
+		recordRun(prompt, step, start, code)
+		ran++
+		if code == 0 {
+			continue
+		}
+
+		if code < 0 {
+			fmt.Fprintf(os.Stderr, "Step %d did not finish.\n", i+1)
+		} else {
+			fmt.Fprintf(os.Stderr, "Step %d failed with exit status %d.\n", i+1, code)
+		}
+		if i == len(steps)-1 {
+			break
+		}
+		ok, err := commandConfirm(fmt.Sprintf("\nContinue with the remaining steps (%d left)?", len(steps)-i-1), false)
+		if errors.Is(err, bubbletea.ErrInterrupted) {
+			return err
+		}
+		if !ok {
+			bold.Printf("Stopped after %d of %d steps.\n", i+1, len(steps))
+			return nil
+		}
+	}
+	bold.Printf("\nRan %d of %d steps.\n", ran, len(steps))
+	return nil
+}
+
+// runScriptStep runs one step in dir with the terminal attached and returns
+// its exit status (-1 if it did not exit normally) and the directory it
+// ended in.
+func runScriptStep(step, dir string) (int, string) {
+	bubbletea.RestoreTerminal()
+
+	command := step
+	var pwdFile string
+	// A sandboxed step has its own /tmp, so it cannot report its directory.
+	sandboxed := tools.DefaultRegistry.Sandbox() != nil
+	if report := shellFor(ShellName).pwdReport; report != nil && runtime.GOOS != "windows" && !sandboxed {
+		if f, err := os.CreateTemp("", "tgpt-pwd-*"); err == nil {
+			f.Close()
+			pwdFile = f.Name()
+			defer os.Remove(pwdFile)
+			command = report(step, pwdFile)
+		}
+	}
+
+	cmd := exec.Command(ShellName, append(append([]string{}, ShellOptions...), command)...)
+	cmd.Dir = dir
+	sandboxCommand(cmd)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	AddToShellHistory(step)
+
+	code := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+			code = -1
+		}
+	}
+	if pwdFile != "" {
+		if data, err := os.ReadFile(pwdFile); err == nil && strings.TrimSpace(string(data)) != "" {
+			dir = strings.TrimSpace(string(data))
+		}
+	}
+	return code, dir
+}
+
+// saveScript writes the steps to a file named by the user, as an executable
+// script for the current shell.
+func saveScript(steps []string) error {
+	name, canceled, err := editCommand("Save to: ", "script"+scriptExtension())
+	if err != nil || canceled || strings.TrimSpace(name) == "" {
+		return err
+	}
+	name = strings.TrimSpace(name)
+	if _, err := os.Stat(name); err == nil {
+		ok, err := commandConfirm(fmt.Sprintf("\n%s already exists. Overwrite it?", name), false)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	if err := os.WriteFile(name, []byte(scriptContent(steps)), 0755); err != nil {
+		return fmt.Errorf("failed to save script: %w", err)
+	}
+	bold.Printf("Saved %d steps to %s\n", len(steps), name)
+	return nil
+}
+
+func scriptContent(steps []string) string {
+	shell := shellFor(ShellName)
+	body := strings.Join(steps, "\n") + "\n"
+	if shell.kind == "cmd" {
+		body = strings.ReplaceAll(body, "\n", "\r\n")
+	}
+	return shell.scriptHeader + body
+}
+
+func scriptExtension() string {
+	return shellFor(ShellName).scriptExt
+}
+
+func indent(text, prefix string) string {
+	return prefix + strings.ReplaceAll(text, "\n", "\n"+prefix)
+}
@@ -0,0 +1,199 @@
+package helper
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Key binding snippets printed by --shell-init. Each sends the current
+// command line to tgpt --suggest and, if a command comes back, puts it on the
+// command line in place of the text without running it. The text follows --
+// so a line starting with a dash is not read as a flag, and stdin comes from
+// /dev/null so tgpt does not wait for piped input.
+var shellInitScripts = map[string]string{
+	"bash": `# tgpt: Ctrl+G turns the command line into a generated shell command.
+# Add to ~/.bashrc: eval "$(tgpt --shell-init bash)"
+__tgpt_suggest() {
+  [ -n "$READLINE_LINE" ] || return
+  local suggestion
+  suggestion=$(tgpt --suggest -- "$READLINE_LINE" </dev/null) || return
+  [ -n "$suggestion" ] || return
+  READLINE_LINE=$suggestion
+  READLINE_POINT=${#READLINE_LINE}
+}
+bind -x '"\C-g": __tgpt_suggest'
+`,
+	"zsh": `# tgpt: Ctrl+G turns the command line into a generated shell command.
+# Add to ~/.zshrc: eval "$(tgpt --shell-init zsh)"
+__tgpt_suggest() {
+  [[ -n $BUFFER ]] || return
+  local suggestion
+  suggestion=$(tgpt --suggest -- "$BUFFER" </dev/null)
+  if [[ $? -eq 0 && -n $suggestion ]]; then
+    BUFFER=$suggestion
+    CURSOR=${#BUFFER}
+  fi
+  zle reset-prompt
+}
+zle -N __tgpt_suggest
+bindkey '^G' __tgpt_suggest
+`,
+	"fish": `# tgpt: Ctrl+G turns the command line into a generated shell command.
+# Add to ~/.config/fish/config.fish: tgpt --shell-init fish | source
+function __tgpt_suggest
+    set -l buffer (commandline)
+    if test -n "$buffer"
+        set -l suggestion (tgpt --suggest -- "$buffer" </dev/null | string collect)
+        and test -n "$suggestion"
+        and commandline -r -- $suggestion
+    end
+    commandline -f repaint
+end
+bind \cg __tgpt_suggest
+bind -M insert \cg __tgpt_suggest 2>/dev/null
+`,
+}
+
+// Hooks printed by --shell-init after the key binding. After each command
+// they write its exit status, directory and text to the state file read by
+// --last (see LastCommandPath), leaving out tgpt's own commands. With
+// TGPT_CAPTURE_OUTPUT set, the shell is restarted under script(1) so the
+// output can be recorded too: the hook notes where the command's output
+// starts and ends in the session log.
+var shellHookScripts = map[string]string{
+	"bash": `# tgpt: remember the last command for tgpt --last.
+__tgpt_last_file="${TGPT_LAST_FILE:-${XDG_STATE_HOME:-$HOME/.local/state}/tgpt/last_command}"
+mkdir -p -m 700 "${__tgpt_last_file%/*}" 2>/dev/null
+if [ -n "$TGPT_CAPTURE_OUTPUT" ] && [ -z "$TGPT_SCRIPT_LOG" ] && [[ $- == *i* ]] && command -v script >/dev/null; then
+  export TGPT_SCRIPT_LOG="${__tgpt_last_file%/*}/session-$$.log"
+  if [ "$(uname)" = Darwin ]; then script -qF "$TGPT_SCRIPT_LOG" bash; else script -qf -c bash "$TGPT_SCRIPT_LOG"; fi
+  __tgpt_status=$?
+  rm -f "$TGPT_SCRIPT_LOG"
+  exit $__tgpt_status
+fi
+__tgpt_record() {
+  local exit_status=$? entry cmd
+  entry=$(HISTTIMEFORMAT= builtin history 1)
+  if [ -n "$entry" ] && [ "$entry" != "$__tgpt_last_entry" ]; then
+    __tgpt_last_entry=$entry
+    [[ $entry =~ ^[[:space:]]*[0-9]+[*]?[[:space:]]+(.*)$ ]] && cmd=${BASH_REMATCH[1]}
+    case $cmd in
+    "" | tgpt | "tgpt "*) ;;
+    *)
+      {
+        printf 'exit: %s\ncwd: %s\ntime: %s\n' "$exit_status" "$PWD" "${EPOCHSECONDS:-$(date +%s)}"
+        if [ -n "$TGPT_SCRIPT_LOG" ] && [ -r "$__tgpt_last_file.start" ]; then
+          printf 'log: %s\nstart: %s\nend: %s\n' "$TGPT_SCRIPT_LOG" "$(<"$__tgpt_last_file.start")" "$(wc -c <"$TGPT_SCRIPT_LOG" | tr -d ' ')"
+        fi
+        printf 'command: %s\n' "$cmd"
+      } >|"$__tgpt_last_file"
+      ;;
+    esac
+  fi
+  return $exit_status
+}
+PROMPT_COMMAND="__tgpt_record${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+if [ -n "$TGPT_SCRIPT_LOG" ]; then
+  PS0="${PS0}"'$(wc -c <"$TGPT_SCRIPT_LOG" | tr -d " " >|"$__tgpt_last_file.start")'
+fi
+`,
+	"zsh": `# tgpt: remember the last command for tgpt --last.
+__tgpt_last_file="${TGPT_LAST_FILE:-${XDG_STATE_HOME:-$HOME/.local/state}/tgpt/last_command}"
+mkdir -p -m 700 "${__tgpt_last_file:h}" 2>/dev/null
+if [[ -n $TGPT_CAPTURE_OUTPUT && -z $TGPT_SCRIPT_LOG && -o interactive ]] && (( $+commands[script] )); then
+  export TGPT_SCRIPT_LOG="${__tgpt_last_file:h}/session-$$.log"
+  if [[ $(uname) == Darwin ]]; then script -qF "$TGPT_SCRIPT_LOG" zsh; else script -qf -c zsh "$TGPT_SCRIPT_LOG"; fi
+  __tgpt_status=$?
+  rm -f "$TGPT_SCRIPT_LOG"
+  exit $__tgpt_status
+fi
+zmodload zsh/datetime 2>/dev/null
+__tgpt_preexec() {
+  __tgpt_cmd=$1
+  [[ -n $TGPT_SCRIPT_LOG ]] && __tgpt_start=$(wc -c <"$TGPT_SCRIPT_LOG" | tr -d ' ')
+}
+__tgpt_precmd() {
+  local exit_status=$? cmd=$__tgpt_cmd
+  __tgpt_cmd=
+  [[ -z $cmd || $cmd == tgpt || $cmd == "tgpt "* ]] && return
+  {
+    print -r -- "exit: $exit_status"
+    print -r -- "cwd: $PWD"
+    print -r -- "time: ${EPOCHSECONDS:-$(date +%s)}"
+    if [[ -n $TGPT_SCRIPT_LOG ]]; then
+      print -r -- "log: $TGPT_SCRIPT_LOG"
+      print -r -- "start: $__tgpt_start"
+      print -r -- "end: $(wc -c <"$TGPT_SCRIPT_LOG" | tr -d ' ')"
+    fi
+    print -r -- "command: $cmd"
+  } >|"$__tgpt_last_file"
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook preexec __tgpt_preexec
+add-zsh-hook precmd __tgpt_precmd
+`,
+	"fish": `# tgpt: remember the last command for tgpt --last.
+if set -q TGPT_LAST_FILE
+    set -g __tgpt_last_file $TGPT_LAST_FILE
+else if set -q XDG_STATE_HOME
+    set -g __tgpt_last_file $XDG_STATE_HOME/tgpt/last_command
+else
+    set -g __tgpt_last_file $HOME/.local/state/tgpt/last_command
+end
+mkdir -p -m 700 (dirname $__tgpt_last_file) 2>/dev/null
+if set -q TGPT_CAPTURE_OUTPUT; and not set -q TGPT_SCRIPT_LOG; and status is-interactive; and command -q script
+    set -gx TGPT_SCRIPT_LOG (dirname $__tgpt_last_file)/session-$fish_pid.log
+    if test (uname) = Darwin
+        script -qF $TGPT_SCRIPT_LOG fish
+    else
+        script -qf -c fish $TGPT_SCRIPT_LOG
+    end
+    set -l script_status $status
+    rm -f $TGPT_SCRIPT_LOG
+    exit $script_status
+end
+function __tgpt_preexec --on-event fish_preexec
+    set -g __tgpt_cmd $argv[1]
+    if set -q TGPT_SCRIPT_LOG
+        set -g __tgpt_start (wc -c <$TGPT_SCRIPT_LOG | string trim)
+    end
+end
+function __tgpt_postexec --on-event fish_postexec
+    set -l exit_status $status
+    set -l cmd $argv[1]
+    if test -z "$cmd"; or string match -qr '^tgpt( |$)' -- $cmd
+        return
+    end
+    begin
+        echo "exit: $exit_status"
+        echo "cwd: $PWD"
+        echo "time: "(date +%s)
+        if set -q TGPT_SCRIPT_LOG
+            echo "log: $TGPT_SCRIPT_LOG"
+            echo "start: $__tgpt_start"
+            echo "end: "(wc -c <$TGPT_SCRIPT_LOG | string trim)
+        end
+        printf 'command: %s\n' $cmd
+    end >$__tgpt_last_file
+end
+`,
+}
+
+// ShellInitScript returns the key binding and the hook for --last for
+// shell, given by name or path.
+func ShellInitScript(shell string) (string, error) {
+	name := strings.TrimSuffix(shellBaseName(shell), ".exe")
+	script, ok := shellInitScripts[name]
+	if !ok {
+		return "", fmt.Errorf("unsupported shell %q for --shell-init, supported: bash, zsh, fish", shell)
+	}
+	return script + "\n" + shellHookScripts[name], nil
+}
+
+func shellBaseName(shell string) string {
+	shell = strings.TrimSpace(shell)
+	if i := strings.LastIndexAny(shell, `/\`); i >= 0 {
+		shell = shell[i+1:]
+	}
+	return strings.ToLower(shell)
+}
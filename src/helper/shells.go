@@ -0,0 +1,261 @@
+package helper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// shellAdapter holds what differs between shells: how to run a command line,
+// how to load the user's aliases and functions, which syntax to ask the
+// model for and where the shell keeps its history.
+type shellAdapter struct {
+	kind    string
+	options []string
+	// aliasArgs returns the arguments that run line with the aliases and
+	// functions from configFile available.
+	aliasArgs func(options []string, configFile, line string) []string
+	// configFile returns the file aliases are loaded from.
+	configFile func(home string) string
+	// hint is added to prompts so the model writes commands in the
+	// shell's syntax.
+	hint string
+	// historyFile returns the history file to append to, "" for none.
+	historyFile func(home string) string
+	// historyEntry formats command as a line of the history file.
+	historyEntry func(command string, now time.Time) string
+	// pwdReport returns step followed by code that writes the final
+	// working directory to file and keeps the step's exit status, or nil
+	// if the shell cannot do that.
+	pwdReport    func(step, file string) string
+	scriptExt    string
+	scriptHeader string
+}
+
+var shellAdapters = map[string]*shellAdapter{
+	"bash": {
+		kind:    "bash",
+		options: []string{"-c"},
+		aliasArgs: func(options []string, configFile, line string) []string {
+			// Aliases are expanded in non-interactive bash only with
+			// expand_aliases, and only on lines after the one defining them.
+			return withArgs(options, sourceLine("shopt -s expand_aliases; source", configFile)+line)
+		},
+		configFile:   func(home string) string { return filepath.Join(home, ".bashrc") },
+		historyFile:  func(home string) string { return envOr("HISTFILE", filepath.Join(home, ".bash_history")) },
+		historyEntry: func(command string, now time.Time) string { return command + "\n" },
+		pwdReport:    posixPwdReport,
+		scriptExt:    ".sh",
+		scriptHeader: "#!/usr/bin/env bash\nset -e\n\n",
+	},
+	"zsh": {
+		kind:    "zsh",
+		options: []string{"-c"},
+		aliasArgs: func(options []string, configFile, line string) []string {
+			return withArgs(options, sourceLine("source", configFile)+line)
+		},
+		configFile: func(home string) string {
+			return filepath.Join(envOr("ZDOTDIR", home), ".zshrc")
+		},
+		historyFile: func(home string) string {
+			return envOr("HISTFILE", filepath.Join(envOr("ZDOTDIR", home), ".zsh_history"))
+		},
+		historyEntry: func(command string, now time.Time) string {
+			// Extended history format; lines of a multi-line command end
+			// with a backslash.
+			return fmt.Sprintf(": %d:0;%s\n", now.Unix(), strings.ReplaceAll(command, "\n", "\\\n"))
+		},
+		pwdReport:    posixPwdReport,
+		scriptExt:    ".sh",
+		scriptHeader: "#!/usr/bin/env zsh\nset -e\n\n",
+	},
+	"fish": {
+		kind:    "fish",
+		options: []string{"-c"},
+		aliasArgs: func(options []string, configFile, line string) []string {
+			// fish reads config.fish and autoloads functions even for -c.
+			return withArgs(options, line)
+		},
+		configFile: func(home string) string {
+			return filepath.Join(envOr("XDG_CONFIG_HOME", filepath.Join(home, ".config")), "fish", "config.fish")
+		},
+		hint: "Use fish syntax: `set -x NAME value` instead of `export NAME=value`, `(command)` for command substitution and no heredocs. ",
+		historyFile: func(home string) string {
+			session := "fish"
+			if name, ok := os.LookupEnv("fish_history"); ok {
+				if name == "" {
+					return ""
+				}
+				session = name
+			}
+			dir := envOr("XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+			return filepath.Join(dir, "fish", session+"_history")
+		},
+		historyEntry: func(command string, now time.Time) string {
+			escaped := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(command)
+			return fmt.Sprintf("- cmd: %s\n  when: %d\n", escaped, now.Unix())
+		},
+		pwdReport: func(step, file string) string {
+			return step + "\nset __tgpt_status $status; pwd > " + posixQuote(file) + "; exit $__tgpt_status"
+		},
+		scriptExt:    ".fish",
+		scriptHeader: "#!/usr/bin/env fish\n",
+	},
+	"nu": {
+		kind:    "nu",
+		options: []string{"-c"},
+		aliasArgs: func(options []string, configFile, line string) []string {
+			// nu -c skips config.nu unless it is passed explicitly.
+			if _, err := os.Stat(configFile); err != nil {
+				return withArgs(options, line)
+			}
+			return append([]string{"--config", configFile}, withArgs(options, line)...)
+		},
+		configFile: func(home string) string { return filepath.Join(nuConfigDir(home), "config.nu") },
+		hint:       "Use Nushell syntax: chain commands with `;` (there is no `&&`), use `$env.NAME` for environment variables, and prefer Nushell's own commands such as `ls`, `open` and `where`. ",
+		historyFile: func(home string) string {
+			// Only the plain text history format can be appended to; it is
+			// left alone when Nushell keeps its history in SQLite.
+			path := filepath.Join(nuConfigDir(home), "history.txt")
+			if _, err := os.Stat(path); err != nil {
+				return ""
+			}
+			return path
+		},
+		historyEntry: func(command string, now time.Time) string {
+			return strings.ReplaceAll(command, "\n", `<\n>`) + "\n"
+		},
+		scriptExt:    ".nu",
+		scriptHeader: "#!/usr/bin/env nu\n",
+	},
+	"pwsh":       powershellAdapter("pwsh", "PowerShell"),
+	"powershell": powershellAdapter("powershell", "WindowsPowerShell"),
+	"cmd": {
+		kind:         "cmd",
+		options:      []string{"/C"},
+		aliasArgs:    func(options []string, configFile, line string) []string { return withArgs(options, line) },
+		configFile:   func(home string) string { return "" },
+		hint:         "Use cmd.exe syntax, with `&&` to chain commands and `%NAME%` for environment variables. ",
+		historyFile:  func(home string) string { return "" },
+		scriptExt:    ".bat",
+		scriptHeader: "@echo off\r\n",
+	},
+	"sh": {
+		kind:    "sh",
+		options: []string{"-c"},
+		aliasArgs: func(options []string, configFile, line string) []string {
+			return withArgs(options, sourceLine(".", configFile)+line)
+		},
+		configFile:   func(home string) string { return filepath.Join(home, ".profile") },
+		hint:         "Use POSIX sh syntax without bash extensions. ",
+		historyFile:  func(home string) string { return "" },
+		pwdReport:    posixPwdReport,
+		scriptExt:    ".sh",
+		scriptHeader: "#!/bin/sh\nset -e\n\n",
+	},
+}
+
+// powershellAdapter covers both pwsh and Windows PowerShell, which differ
+// only in where they keep their profile.
+func powershellAdapter(kind, profileDir string) *shellAdapter {
+	return &shellAdapter{
+		kind:    kind,
+		options: []string{"-NoProfile", "-Command"},
+		aliasArgs: func(options []string, configFile, line string) []string {
+			// Without -NoProfile the profile, and so its aliases and
+			// functions, is loaded.
+			return []string{"-Command", line}
+		},
+		configFile: func(home string) string {
+			if runtime.GOOS == "windows" {
+				return filepath.Join(home, "Documents", profileDir, "Microsoft.PowerShell_profile.ps1")
+			}
+			return filepath.Join(envOr("XDG_CONFIG_HOME", filepath.Join(home, ".config")), "powershell", "Microsoft.PowerShell_profile.ps1")
+		},
+		hint: "Use PowerShell syntax and cmdlets, with `;` to chain commands and `$env:NAME` for environment variables. ",
+		historyFile: func(home string) string {
+			if runtime.GOOS == "windows" {
+				return filepath.Join(os.Getenv("APPDATA"), "Microsoft", "Windows", "PowerShell", "PSReadLine", "ConsoleHost_history.txt")
+			}
+			return filepath.Join(envOr("XDG_DATA_HOME", filepath.Join(home, ".local", "share")), "powershell", "PSReadLine", "ConsoleHost_history.txt")
+		},
+		historyEntry: func(command string, now time.Time) string {
+			// PSReadLine ends each line of a multi-line command with a backtick.
+			return strings.ReplaceAll(command, "\n", "`\n") + "\n"
+		},
+		scriptExt:    ".ps1",
+		scriptHeader: "$ErrorActionPreference = 'Stop'\n",
+	}
+}
+
+// shellFor returns the adapter for a shell given by name or path, falling
+// back to sh for shells it does not know.
+func shellFor(name string) *shellAdapter {
+	base := strings.ToLower(strings.TrimSuffix(filepath.Base(name), ".exe"))
+	if adapter, ok := shellAdapters[base]; ok {
+		return adapter
+	}
+	return shellAdapters["sh"]
+}
+
+// currentShell returns the adapter for ShellName, or for $SHELL before
+// SetShellAndOSVars has run.
+func currentShell() *shellAdapter {
+	if ShellName != "" {
+		return shellFor(ShellName)
+	}
+	return shellFor(os.Getenv("SHELL"))
+}
+
+// ShellPromptHint tells the model which syntax the current shell expects,
+// or is empty for bash and zsh.
+func ShellPromptHint() string {
+	return currentShell().hint
+}
+
+func withArgs(options []string, line string) []string {
+	return append(append([]string{}, options...), line)
+}
+
+// sourceLine returns a line that loads configFile with the given command, or
+// nothing if the file does not exist.
+func sourceLine(command, configFile string) string {
+	if configFile == "" {
+		return ""
+	}
+	if _, err := os.Stat(configFile); err != nil {
+		return ""
+	}
+	return command + " " + posixQuote(configFile) + "\n"
+}
+
+func posixPwdReport(step, file string) string {
+	return step + "\n__tgpt_status=$?; pwd > " + posixQuote(file) + "; exit $__tgpt_status"
+}
+
+func posixQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func nuConfigDir(home string) string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "nushell")
+	}
+	if runtime.GOOS == "darwin" {
+		return filepath.Join(home, "Library", "Application Support", "nushell")
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "nushell")
+	}
+	return filepath.Join(home, ".config", "nushell")
+}
@@ -0,0 +1,241 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aandrew-me/tgpt/v2/src/bubbletea"
+	"github.com/aandrew-me/tgpt/v2/src/structs"
+	"github.com/aandrew-me/tgpt/v2/src/tools"
+)
+
+// maxParallelTools bounds how many read-only tool calls run at once.
+var maxParallelTools = 4
+
+type toolCallResult struct {
+	output  string
+	err     error
+	proceed bool
+	planned bool // queued in plan mode instead of run
+}
+
+// executeToolCalls runs the tool calls of one assistant turn and returns the
+// tool messages in the original order. Consecutive read-only calls run
+// concurrently; any other call runs on its own, after the calls before it
+// have finished, so confirmations stay sequential.
+func executeToolCalls(toolCalls []structs.ToolCall, params structs.Params, extraOptions structs.ExtraOptions) []structs.ToolMessage {
+	results := make([]toolCallResult, len(toolCalls))
+	statusOn := statusEnabled(extraOptions)
+
+	for i := 0; i < len(toolCalls); {
+		if !tools.DefaultRegistry.IsReadOnly(toolCalls[i].Function.Name) {
+			tc := toolCalls[i]
+			if extraOptions.Verbose {
+				boldBlue.Printf("\n[Tool Call] %s(%s)\n", tc.Function.Name, tc.Function.Arguments)
+			}
+			if tc.Function.Name == "execute_command" {
+				// Commands ask for confirmation or stream their output.
+				hideStatus()
+			} else {
+				showStatus(statusOn, "Running "+tc.Function.Name)
+			}
+			results[i] = runToolCall(tc, params, extraOptions)
+			hideStatus()
+			reportToolCall(tc, results[i], extraOptions)
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(toolCalls) && tools.DefaultRegistry.IsReadOnly(toolCalls[j].Function.Name) {
+			j++
+		}
+		runToolCallsParallel(toolCalls[i:j], results[i:j], params, extraOptions, statusOn)
+		i = j
+	}
+
+	messages := make([]structs.ToolMessage, len(toolCalls))
+	for i, tc := range toolCalls {
+		messages[i] = structs.ToolMessage{
+			Role:       "tool",
+			ToolCallID: tc.ID,
+			Name:       tc.Function.Name,
+			Content:    results[i].output,
+		}
+	}
+	return messages
+}
+
+// runToolCallsParallel runs read-only calls with a bounded worker pool while
+// the status line shows which of them are still running.
+func runToolCallsParallel(toolCalls []structs.ToolCall, results []toolCallResult, params structs.Params, extraOptions structs.ExtraOptions, statusOn bool) {
+	if extraOptions.Verbose {
+		for _, tc := range toolCalls {
+			boldBlue.Printf("\n[Tool Call] %s(%s)\n", tc.Function.Name, tc.Function.Arguments)
+		}
+	}
+
+	var mu sync.Mutex
+	done := make([]bool, len(toolCalls))
+	updateStatus := func() {
+		if len(toolCalls) == 1 {
+			showStatus(statusOn, "Running "+toolCalls[0].Function.Name)
+			return
+		}
+		finished := 0
+		var running []string
+		for i, tc := range toolCalls {
+			if done[i] {
+				finished++
+			} else {
+				running = append(running, tc.Function.Name)
+			}
+		}
+		showStatus(statusOn, fmt.Sprintf("Running %d tools (%d/%d done): %s", len(toolCalls), finished, len(toolCalls), strings.Join(running, ", ")))
+	}
+	updateStatus()
+
+	limit := maxParallelTools
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, tc := range toolCalls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, tc structs.ToolCall) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res := runToolCall(tc, params, extraOptions)
+
+			mu.Lock()
+			results[i] = res
+			done[i] = true
+			updateStatus()
+			mu.Unlock()
+		}(i, tc)
+	}
+	wg.Wait()
+	hideStatus()
+
+	for i, tc := range toolCalls {
+		reportToolCall(tc, results[i], extraOptions)
+	}
+}
+
+// runToolCall confirms and executes a single tool call, and records it in the
+// audit log if one is enabled.
+func runToolCall(tc structs.ToolCall, params structs.Params, extraOptions structs.ExtraOptions) toolCallResult {
+	preConfirmCtx := context.Background()
+	if extraOptions.AutoExec {
+		preConfirmCtx = context.WithValue(preConfirmCtx, tools.AutoExecKey, true)
+	}
+	if extraOptions.Verbose {
+		preConfirmCtx = context.WithValue(preConfirmCtx, tools.VerboseKey, true)
+	}
+
+	var res toolCallResult
+	var cmdStatus *tools.CommandStatus
+	decision, confirmErr := tools.DefaultRegistry.Confirm(preConfirmCtx, tc.Function.Name, tc.Function.Arguments)
+	proceed, cancelMsg := decision.Proceed, decision.Message
+	res.proceed = proceed
+	res.planned = decision.Outcome == tools.OutcomePlanned
+	var duration time.Duration
+	if confirmErr != nil {
+		hideStatus()
+		if errors.Is(confirmErr, bubbletea.ErrInterrupted) {
+			bubbletea.RestoreTerminal()
+			os.Exit(130)
+		}
+		if cancelMsg != "" {
+			res.output = cancelMsg
+		} else {
+			res.output = confirmErr.Error()
+		}
+		res.err = confirmErr
+	} else if !proceed {
+		res.output = cancelMsg
+		res.err = fmt.Errorf("%s", cancelMsg)
+	} else {
+		// The confirmation (if any) has already been obtained above,
+		// so the execution timeout starts only now and is not
+		// consumed by time spent waiting on user input.
+		execCtx := context.WithValue(context.Background(), tools.ConfirmedKey, true)
+		if tc.Function.Name == "execute_command" {
+			execCtx, cmdStatus = tools.WithCommandStatus(execCtx)
+		}
+		if extraOptions.AutoExec {
+			execCtx = context.WithValue(execCtx, tools.AutoExecKey, true)
+		}
+		var stream *commandStream
+		if tc.Function.Name == "execute_command" && statusEnabled(extraOptions) {
+			stream = &commandStream{w: os.Stdout}
+			execCtx = context.WithValue(execCtx, tools.CommandOutputKey, io.Writer(stream))
+		}
+
+		start := time.Now()
+		res.output, res.err = tools.DefaultRegistry.ExecuteWithTimeout(execCtx, tc.Function.Name, tc.Function.Arguments)
+		duration = time.Since(start)
+		stream.finish()
+	}
+
+	if audit := tools.DefaultRegistry.AuditLog(); audit != nil {
+		entry := tools.DefaultRegistry.NewAuditEntry(tc.Function.Name, tc.Function.Arguments, decision, res.output, res.err, cmdStatus, duration)
+		entry.Provider = params.Provider
+		entry.Model = params.ApiModel
+		audit.Record(entry)
+	}
+
+	if res.err != nil && proceed {
+		res.output = fmt.Sprintf("Error executing tool: %v", res.err)
+	}
+	return res
+}
+
+// commandStream shows the output of a running command dimmed.
+type commandStream struct {
+	w      io.Writer
+	wrote  bool
+	lastNL bool
+}
+
+func (s *commandStream) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	faint.Fprint(s.w, string(p))
+	s.wrote = true
+	s.lastNL = p[len(p)-1] == '\n'
+	return len(p), nil
+}
+
+// finish ends the output with a newline, so the tool report starts on a
+// line of its own.
+func (s *commandStream) finish() {
+	if s != nil && s.wrote && !s.lastNL {
+		fmt.Fprintln(s.w)
+	}
+}
+
+func reportToolCall(tc structs.ToolCall, res toolCallResult, extraOptions structs.ExtraOptions) {
+	if !extraOptions.Verbose && extraOptions.IsNormal && res.planned {
+		boldViolet.Printf("Queued %s(%s) in the plan\n", tc.Function.Name, formatToolArgs(tc.Function.Arguments))
+	} else if !extraOptions.Verbose && extraOptions.IsNormal {
+		mark := "\u2705"
+		if res.err != nil {
+			mark = "\u274c"
+		}
+		boldViolet.Printf("Used Tool %s(%s) %s\n", tc.Function.Name, formatToolArgs(tc.Function.Arguments), mark)
+	}
+
+	if extraOptions.Verbose {
+		bold.Printf("[Tool Output] %s\n", res.output)
+	}
+}
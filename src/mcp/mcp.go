@@ -3,6 +3,7 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -24,6 +25,7 @@ type ServerConfig struct {
 	URL     string            `json:"url,omitempty"`
 	Type    string            `json:"type,omitempty"`
 	Headers map[string]string `json:"headers,omitempty"`
+	OAuth   *OAuthConfig      `json:"oauth,omitempty"`
 }
 
 type headerTransport struct {
@@ -46,15 +48,24 @@ type Config struct {
 type Manager struct {
 	mu       sync.Mutex
 	clients  map[string]mcpclient.MCPClient
+	configs  map[string]ServerConfig
 	registry *tools.Registry
+
+	// reconnectMu serializes reconnects so concurrent failures of the same
+	// server restart it only once.
+	reconnectMu sync.Mutex
 }
 
+// reconnectBackoff is the wait before each reconnect attempt after the first.
+var reconnectBackoff = []time.Duration{500 * time.Millisecond, 2 * time.Second, 5 * time.Second}
+
 func NewManager(registry *tools.Registry) *Manager {
 	if registry == nil {
 		registry = tools.DefaultRegistry
 	}
 	return &Manager{
 		clients:  make(map[string]mcpclient.MCPClient),
+		configs:  make(map[string]ServerConfig),
 		registry: registry,
 	}
 }
@@ -96,6 +107,122 @@ func (m *Manager) InitServer(ctx context.Context, name string, sc ServerConfig)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	mcpClient, err := connect(ctx, name, sc)
+	if err != nil {
+		return err
+	}
+
+	m.clients[name] = mcpClient
+	m.configs[name] = sc
+
+	// List tools and register them
+	listToolsReq := mcp.ListToolsRequest{}
+	res, err := mcpClient.ListTools(ctx, listToolsReq)
+	if err != nil {
+		return fmt.Errorf("failed to list tools for %s: %w", name, err)
+	}
+
+	for _, tool := range res.Tools {
+		toolName := tool.Name
+
+		var paramsMap map[string]any
+		schemaBytes, _ := json.Marshal(tool.InputSchema)
+		_ = json.Unmarshal(schemaBytes, &paramsMap)
+
+		if paramsMap == nil {
+			paramsMap = make(map[string]any)
+		}
+		if _, ok := paramsMap["type"]; !ok {
+			paramsMap["type"] = "object"
+		}
+		if _, ok := paramsMap["properties"]; !ok {
+			paramsMap["properties"] = map[string]any{}
+		}
+
+		registeredName := toolName
+		if m.registry.Has(toolName) {
+			registeredName = fmt.Sprintf("%s_%s", name, toolName)
+			fmt.Fprintf(os.Stderr, "Warning: MCP tool %q from server %q conflicts with an existing tool; registering as %q\n", toolName, name, registeredName)
+		}
+
+		spec := tools.ToolSpec{
+			Type: "function",
+			Function: tools.FunctionSpec{
+				Name:        registeredName,
+				Description: tool.Description,
+				Parameters:  paramsMap,
+			},
+		}
+
+		// Closure copy
+		serverName := name
+		mcpToolName := toolName
+
+		// Per the MCP spec, a tool that is not read-only is destructive
+		// unless it says otherwise.
+		readOnly := tool.Annotations.ReadOnlyHint != nil && *tool.Annotations.ReadOnlyHint
+		meta := tools.ToolMeta{
+			Source:      name,
+			ReadOnly:    readOnly,
+			Destructive: !readOnly && (tool.Annotations.DestructiveHint == nil || *tool.Annotations.DestructiveHint),
+		}
+
+		m.registry.RegisterWithMeta(spec, meta, func(execCtx context.Context, args map[string]any) (string, error) {
+			return m.callTool(execCtx, serverName, mcpToolName, args)
+		})
+	}
+
+	return nil
+}
+
+// connect starts and initializes a client for sc. Remote servers that answer
+// with 401 go through the OAuth flow, either because "oauth" is configured or
+// because no Authorization header was given, and are then connected again.
+func connect(ctx context.Context, name string, sc ServerConfig) (*mcpclient.Client, error) {
+	var auth *oauthSession
+	for attempt := 0; ; attempt++ {
+		if sc.URL != "" && sc.OAuth != nil && auth == nil {
+			var err error
+			auth, err = newOAuthSession(name, sc)
+			if err != nil {
+				return nil, fmt.Errorf("failed to set up OAuth for %s: %w", name, err)
+			}
+		}
+
+		mcpClient, err := startClient(ctx, name, sc, auth)
+		if err == nil {
+			err = initializeClient(ctx, name, mcpClient)
+		}
+		if err == nil {
+			return mcpClient, nil
+		}
+		if sc.URL == "" || attempt >= 2 {
+			return nil, err
+		}
+
+		switch {
+		case auth != nil && mcpclient.IsOAuthAuthorizationRequiredError(err):
+			if authErr := auth.authorize(ctx, mcpclient.GetOAuthHandler(err)); authErr != nil {
+				return nil, fmt.Errorf("OAuth authorization for %s failed: %w", name, authErr)
+			}
+		case auth == nil && mcpclient.IsAuthorizationRequiredError(err) && !hasAuthorizationHeader(sc.Headers):
+			sc.OAuth = &OAuthConfig{}
+		default:
+			return nil, err
+		}
+	}
+}
+
+func hasAuthorizationHeader(headers map[string]string) bool {
+	for k := range headers {
+		if http.CanonicalHeaderKey(k) == "Authorization" {
+			return true
+		}
+	}
+	return false
+}
+
+func startClient(ctx context.Context, name string, sc ServerConfig, auth *oauthSession) (*mcpclient.Client, error) {
 	var mcpClient *mcpclient.Client
 	var err error
 
@@ -112,20 +239,33 @@ func (m *Manager) InitServer(ctx context.Context, name string, sc ServerConfig)
 			}
 		}
 
+		newSSE := func() (*mcpclient.Client, error) {
+			if auth != nil {
+				return mcpclient.NewOAuthSSEClient(sc.URL, auth.config, mcptransport.WithHTTPClient(httpClient))
+			}
+			return mcpclient.NewSSEMCPClient(sc.URL, mcpclient.WithHTTPClient(httpClient))
+		}
+		newStreamable := func() (*mcpclient.Client, error) {
+			if auth != nil {
+				return mcpclient.NewOAuthStreamableHttpClient(sc.URL, auth.config, mcptransport.WithHTTPBasicClient(httpClient))
+			}
+			return mcpclient.NewStreamableHttpClient(sc.URL, mcptransport.WithHTTPBasicClient(httpClient))
+		}
+
 		switch sc.Type {
 		case "sse":
-			mcpClient, err = mcpclient.NewSSEMCPClient(sc.URL, mcpclient.WithHTTPClient(httpClient))
+			mcpClient, err = newSSE()
 			if err == nil {
 				err = mcpClient.Start(ctx)
 			}
 		case "http", "streamable-http":
-			mcpClient, err = mcpclient.NewStreamableHttpClient(sc.URL, mcptransport.WithHTTPBasicClient(httpClient))
+			mcpClient, err = newStreamable()
 			if err == nil {
 				err = mcpClient.Start(ctx)
 			}
 		default:
 			// Try Streamable HTTP first (modern MCP spec used by servers like Exa)
-			mcpClient, err = mcpclient.NewStreamableHttpClient(sc.URL, mcptransport.WithHTTPBasicClient(httpClient))
+			mcpClient, err = newStreamable()
 			if err == nil {
 				err = mcpClient.Start(ctx)
 			}
@@ -134,7 +274,7 @@ func (m *Manager) InitServer(ctx context.Context, name string, sc ServerConfig)
 					mcpClient.Close()
 				}
 				// Fall back to SSE transport if Streamable HTTP fails
-				mcpClient, err = mcpclient.NewSSEMCPClient(sc.URL, mcpclient.WithHTTPClient(httpClient))
+				mcpClient, err = newSSE()
 				if err == nil {
 					err = mcpClient.Start(ctx)
 				}
@@ -144,21 +284,25 @@ func (m *Manager) InitServer(ctx context.Context, name string, sc ServerConfig)
 			if mcpClient != nil {
 				mcpClient.Close()
 			}
-			return fmt.Errorf("failed to start MCP client for %s: %w", name, err)
+			return nil, fmt.Errorf("failed to start MCP client for %s: %w", name, err)
 		}
 	} else if sc.Command != "" {
 		mcpClient, err = mcpclient.NewStdioMCPClient(sc.Command, sc.Env, sc.Args...)
 		if err != nil {
-			return fmt.Errorf("failed to create stdio MCP client for %s: %w", name, err)
+			return nil, fmt.Errorf("failed to create stdio MCP client for %s: %w", name, err)
 		}
 		if err := mcpClient.Start(ctx); err != nil {
 			mcpClient.Close()
-			return fmt.Errorf("failed to start MCP client for %s: %w", name, err)
+			return nil, fmt.Errorf("failed to start MCP client for %s: %w", name, err)
 		}
 	} else {
-		return fmt.Errorf("invalid server config for %s: missing command or url", name)
+		return nil, fmt.Errorf("invalid server config for %s: missing command or url", name)
 	}
 
+	return mcpClient, nil
+}
+
+func initializeClient(ctx context.Context, name string, mcpClient *mcpclient.Client) error {
 	initReq := mcp.InitializeRequest{}
 	initReq.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
 	initReq.Params.ClientInfo = mcp.Implementation{
@@ -166,92 +310,142 @@ func (m *Manager) InitServer(ctx context.Context, name string, sc ServerConfig)
 		Version: "1.0.0",
 	}
 
-	_, err = mcpClient.Initialize(ctx, initReq)
-	if err != nil {
+	if _, err := mcpClient.Initialize(ctx, initReq); err != nil {
 		mcpClient.Close()
 		return fmt.Errorf("failed to initialize MCP client for %s: %w", name, err)
 	}
+	return nil
+}
 
-	m.clients[name] = mcpClient
+// callTool runs a tool on the named server. If the call fails because the
+// connection is gone (a crashed stdio process, a dropped HTTP session), the
+// server is reconnected and the call is retried once; the returned output
+// then starts with a note about the reconnection.
+func (m *Manager) callTool(ctx context.Context, server, toolName string, args map[string]any) (string, error) {
+	c := m.client(server)
 
-	// List tools and register them
-	listToolsReq := mcp.ListToolsRequest{}
-	res, err := mcpClient.ListTools(ctx, listToolsReq)
-	if err != nil {
-		return fmt.Errorf("failed to list tools for %s: %w", name, err)
+	var callRes *mcp.CallToolResult
+	var err error
+	if c == nil {
+		err = mcptransport.NewError(mcptransport.ErrTransportClosed)
+	} else {
+		callRes, err = callWithTimeout(ctx, c, toolName, args)
 	}
 
-	for _, tool := range res.Tools {
-		toolName := tool.Name
-
-		var paramsMap map[string]any
-		schemaBytes, _ := json.Marshal(tool.InputSchema)
-		_ = json.Unmarshal(schemaBytes, &paramsMap)
-
-		if paramsMap == nil {
-			paramsMap = make(map[string]any)
+	note := ""
+	if err != nil && isConnectionError(err) && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "MCP server %q is unavailable (%v), reconnecting...\n", server, err)
+		newClient, reconnectErr := m.reconnect(ctx, server, c)
+		if reconnectErr != nil {
+			return "", fmt.Errorf("MCP tool execution failed: %w (reconnect failed: %v)", err, reconnectErr)
 		}
-		if _, ok := paramsMap["type"]; !ok {
-			paramsMap["type"] = "object"
-		}
-		if _, ok := paramsMap["properties"]; !ok {
-			paramsMap["properties"] = map[string]any{}
+		fmt.Fprintf(os.Stderr, "Reconnected to MCP server %q\n", server)
+		note = fmt.Sprintf("[MCP server %q was reconnected after a connection failure: %v]\n", server, err)
+		callRes, err = callWithTimeout(ctx, newClient, toolName, args)
+	}
+	if err != nil {
+		if note != "" {
+			return "", fmt.Errorf("MCP tool execution failed after reconnecting to %s: %w", server, err)
 		}
+		return "", fmt.Errorf("MCP tool execution failed: %w", err)
+	}
 
-		registeredName := toolName
-		if m.registry.Has(toolName) {
-			registeredName = fmt.Sprintf("%s_%s", name, toolName)
-			fmt.Fprintf(os.Stderr, "Warning: MCP tool %q from server %q conflicts with an existing tool; registering as %q\n", toolName, name, registeredName)
+	var out string
+	for _, item := range callRes.Content {
+		switch v := item.(type) {
+		case mcp.TextContent:
+			out += v.Text
+		case *mcp.TextContent:
+			out += v.Text
+		default:
+			b, _ := json.Marshal(item)
+			out += string(b)
 		}
+	}
 
-		spec := tools.ToolSpec{
-			Type: "function",
-			Function: tools.FunctionSpec{
-				Name:        registeredName,
-				Description: tool.Description,
-				Parameters:  paramsMap,
-			},
-		}
+	if callRes.IsError {
+		return note + out, fmt.Errorf("MCP tool error: %s", out)
+	}
 
-		// Closure copy
-		clientObj := mcpClient
-		mcpToolName := toolName
+	return note + out, nil
+}
 
-		m.registry.Register(spec, func(execCtx context.Context, args map[string]any) (string, error) {
-			callReq := mcp.CallToolRequest{}
-			callReq.Params.Name = mcpToolName
-			callReq.Params.Arguments = args
+func callWithTimeout(ctx context.Context, c mcpclient.MCPClient, toolName string, args map[string]any) (*mcp.CallToolResult, error) {
+	callReq := mcp.CallToolRequest{}
+	callReq.Params.Name = toolName
+	callReq.Params.Arguments = args
+
+	// Calls made through the tool loop already carry the configured
+	// timeout; only apply the default to calls without one.
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tools.DefaultToolTimeout)
+		defer cancel()
+	}
 
-			callCtx, cancel := context.WithTimeout(execCtx, 60*time.Second)
-			defer cancel()
+	return c.CallTool(ctx, callReq)
+}
 
-			callRes, err := clientObj.CallTool(callCtx, callReq)
-			if err != nil {
-				return "", fmt.Errorf("MCP tool execution failed: %w", err)
-			}
+// isConnectionError reports whether err came from the transport rather than
+// from the server, excluding timeouts and cancellation.
+func isConnectionError(err error) bool {
+	var transportErr *mcptransport.Error
+	if !errors.As(err, &transportErr) {
+		return false
+	}
+	return !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled)
+}
 
-			var out string
-			for _, item := range callRes.Content {
-				switch v := item.(type) {
-				case mcp.TextContent:
-					out += v.Text
-				case *mcp.TextContent:
-					out += v.Text
-				default:
-					b, _ := json.Marshal(item)
-					out += string(b)
-				}
-			}
+func (m *Manager) client(name string) mcpclient.MCPClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.clients[name]
+}
 
-			if callRes.IsError {
-				return out, fmt.Errorf("MCP tool error: %s", out)
-			}
+// reconnect replaces the dead client for name with a fresh connection,
+// restarting stdio servers, and retries with backoff. If another call has
+// already replaced it, the new client is returned as is.
+func (m *Manager) reconnect(ctx context.Context, name string, dead mcpclient.MCPClient) (mcpclient.MCPClient, error) {
+	m.reconnectMu.Lock()
+	defer m.reconnectMu.Unlock()
 
-			return out, nil
-		})
+	m.mu.Lock()
+	current := m.clients[name]
+	sc, ok := m.configs[name]
+	m.mu.Unlock()
+
+	if current != nil && current != dead {
+		return current, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("no configuration for MCP server %s", name)
+	}
+	if dead != nil {
+		dead.Close()
 	}
 
-	return nil
+	var lastErr error
+	for attempt := 0; attempt <= len(reconnectBackoff); attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(reconnectBackoff[attempt-1]):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		newClient, err := connect(ctx, name, sc)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		m.mu.Lock()
+		m.clients[name] = newClient
+		m.mu.Unlock()
+		return newClient, nil
+	}
+	return nil, lastErr
 }
 
 func (m *Manager) Close() {
@@ -261,4 +455,5 @@ func (m *Manager) Close() {
 		c.Close()
 	}
 	m.clients = make(map[string]mcpclient.MCPClient)
+	m.configs = make(map[string]ServerConfig)
 }
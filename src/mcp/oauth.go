@@ -0,0 +1,306 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	tgptclient "github.com/aandrew-me/tgpt/v2/src/client"
+	"github.com/aandrew-me/tgpt/v2/src/utils"
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	mcptransport "github.com/mark3labs/mcp-go/client/transport"
+)
+
+// OAuthConfig enables the MCP authorization flow (OAuth 2.1 with PKCE) for a
+// remote server. All fields are optional: without a client id tgpt registers
+// itself dynamically, and without a redirect port a free loopback port is used.
+type OAuthConfig struct {
+	ClientID              string   `json:"clientId,omitempty"`
+	ClientSecret          string   `json:"clientSecret,omitempty"`
+	Scopes                []string `json:"scopes,omitempty"`
+	RedirectPort          int      `json:"redirectPort,omitempty"`
+	AuthServerMetadataURL string   `json:"authServerMetadataUrl,omitempty"`
+}
+
+// openBrowser opens the authorization URL. It is a variable so tests can
+// complete the flow without a real browser.
+var openBrowser = utils.OpenURL
+
+// oauthCallbackTimeout bounds how long tgpt waits for the browser redirect.
+var oauthCallbackTimeout = 5 * time.Minute
+
+// storedAuth is the on-disk state for one server: the token along with the
+// client credentials and redirect URI it was issued for.
+type storedAuth struct {
+	ServerURL    string              `json:"serverUrl"`
+	ClientID     string              `json:"clientId,omitempty"`
+	ClientSecret string              `json:"clientSecret,omitempty"`
+	RedirectURI  string              `json:"redirectUri,omitempty"`
+	Token        *mcptransport.Token `json:"token,omitempty"`
+}
+
+// fileTokenStore implements transport.TokenStore on top of a JSON file in
+// ~/.config/tgpt/mcp_auth.
+type fileTokenStore struct {
+	mu        sync.Mutex
+	path      string
+	serverURL string
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func authDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "tgpt", "mcp_auth"), nil
+}
+
+func newFileTokenStore(name, serverURL string) (*fileTokenStore, error) {
+	dir, err := authDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate MCP auth directory: %w", err)
+	}
+	fileName := unsafeNameChars.ReplaceAllString(name, "_") + ".json"
+	return &fileTokenStore{path: filepath.Join(dir, fileName), serverURL: serverURL}, nil
+}
+
+// load returns the stored state. State saved for a different URL is ignored
+// so that a server entry pointed elsewhere starts a fresh authorization.
+func (s *fileTokenStore) load() storedAuth {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return storedAuth{ServerURL: s.serverURL}
+	}
+	var sa storedAuth
+	if err := json.Unmarshal(data, &sa); err != nil || sa.ServerURL != s.serverURL {
+		return storedAuth{ServerURL: s.serverURL}
+	}
+	return sa
+}
+
+func (s *fileTokenStore) save(sa storedAuth) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	sa.ServerURL = s.serverURL
+	data, err := json.MarshalIndent(sa, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+func (s *fileTokenStore) update(fn func(*storedAuth)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sa := s.load()
+	fn(&sa)
+	return s.save(sa)
+}
+
+func (s *fileTokenStore) GetToken(ctx context.Context) (*mcptransport.Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sa := s.load()
+	if sa.Token == nil {
+		return nil, mcptransport.ErrNoToken
+	}
+	return sa.Token, nil
+}
+
+func (s *fileTokenStore) SaveToken(ctx context.Context, token *mcptransport.Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.update(func(sa *storedAuth) {
+		sa.Token = token
+	})
+}
+
+// oauthSession holds what is needed to build OAuth-enabled transports and to
+// run the interactive authorization for one server.
+type oauthSession struct {
+	name   string
+	store  *fileTokenStore
+	config mcpclient.OAuthConfig
+}
+
+func newOAuthSession(name string, sc ServerConfig) (*oauthSession, error) {
+	store, err := newFileTokenStore(name, sc.URL)
+	if err != nil {
+		return nil, err
+	}
+	oc := sc.OAuth
+	if oc == nil {
+		oc = &OAuthConfig{}
+	}
+
+	stored := store.load()
+
+	clientID, clientSecret := oc.ClientID, oc.ClientSecret
+	if clientID == "" {
+		clientID, clientSecret = stored.ClientID, stored.ClientSecret
+	}
+
+	redirectURI := ""
+	switch {
+	case oc.RedirectPort > 0:
+		redirectURI = loopbackRedirectURI(oc.RedirectPort)
+	case stored.RedirectURI != "" && oc.ClientID == "":
+		// Dynamically registered clients are bound to the redirect URI they
+		// were registered with, so keep using it.
+		redirectURI = stored.RedirectURI
+	default:
+		port, err := freeLoopbackPort()
+		if err != nil {
+			return nil, fmt.Errorf("failed to pick a port for the OAuth redirect: %w", err)
+		}
+		redirectURI = loopbackRedirectURI(port)
+	}
+
+	return &oauthSession{
+		name:  name,
+		store: store,
+		config: mcpclient.OAuthConfig{
+			ClientID:              clientID,
+			ClientSecret:          clientSecret,
+			RedirectURI:           redirectURI,
+			Scopes:                oc.Scopes,
+			TokenStore:            store,
+			AuthServerMetadataURL: oc.AuthServerMetadataURL,
+			PKCEEnabled:           true,
+			HTTPClient:            tgptclient.NewStandardHTTPClient(60),
+		},
+	}, nil
+}
+
+func loopbackRedirectURI(port int) string {
+	return fmt.Sprintf("http://127.0.0.1:%d/callback", port)
+}
+
+func freeLoopbackPort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// authorize runs the browser-based authorization code flow with PKCE and
+// stores the resulting token. The handler comes from the transport that
+// reported the authorization requirement.
+func (s *oauthSession) authorize(ctx context.Context, handler *mcptransport.OAuthHandler) error {
+	redirect, err := url.Parse(s.config.RedirectURI)
+	if err != nil {
+		return fmt.Errorf("invalid redirect URI %q: %w", s.config.RedirectURI, err)
+	}
+
+	// Listen before registering so a taken port fails early.
+	listener, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return fmt.Errorf("failed to listen for the OAuth redirect on %s: %w", redirect.Host, err)
+	}
+	defer listener.Close()
+
+	if handler.GetClientID() == "" {
+		if err := handler.RegisterClient(ctx, "tgpt"); err != nil {
+			return fmt.Errorf("dynamic client registration failed: %w", err)
+		}
+		if err := s.store.update(func(sa *storedAuth) {
+			sa.ClientID = handler.GetClientID()
+			sa.ClientSecret = handler.GetClientSecret()
+			sa.RedirectURI = s.config.RedirectURI
+		}); err != nil {
+			return fmt.Errorf("failed to save client registration: %w", err)
+		}
+		s.config.ClientID = handler.GetClientID()
+		s.config.ClientSecret = handler.GetClientSecret()
+	}
+
+	verifier, err := mcpclient.GenerateCodeVerifier()
+	if err != nil {
+		return fmt.Errorf("failed to generate code verifier: %w", err)
+	}
+	challenge := mcpclient.GenerateCodeChallenge(verifier)
+	state, err := mcpclient.GenerateState()
+	if err != nil {
+		return fmt.Errorf("failed to generate state: %w", err)
+	}
+
+	authURL, err := handler.GetAuthorizationURL(ctx, state, challenge)
+	if err != nil {
+		return fmt.Errorf("failed to build authorization URL: %w", err)
+	}
+
+	type callbackResult struct {
+		code  string
+		state string
+		err   error
+	}
+	results := make(chan callbackResult, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(redirect.Path, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		res := callbackResult{code: q.Get("code"), state: q.Get("state")}
+		if e := q.Get("error"); e != "" {
+			res.err = fmt.Errorf("authorization server returned %s: %s", e, q.Get("error_description"))
+		} else if res.code == "" {
+			res.err = errors.New("authorization response is missing the code")
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if res.err != nil {
+			fmt.Fprintf(w, "<html><body><h3>Authorization failed</h3><p>%s</p></body></html>", html.EscapeString(res.err.Error()))
+		} else {
+			fmt.Fprint(w, "<html><body><h3>Authorization complete</h3><p>You can close this window and return to tgpt.</p></body></html>")
+		}
+		select {
+		case results <- res:
+		default:
+		}
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	fmt.Fprintf(os.Stderr, "MCP server %q requires authorization.\nOpen this URL in your browser if it does not open automatically:\n\n  %s\n\n", s.name, authURL)
+	if err := openBrowser(authURL); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open a browser: %v\n", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, oauthCallbackTimeout)
+	defer cancel()
+
+	var res callbackResult
+	select {
+	case res = <-results:
+	case <-waitCtx.Done():
+		return fmt.Errorf("timed out waiting for the authorization redirect")
+	}
+	if res.err != nil {
+		return res.err
+	}
+
+	if err := handler.ProcessAuthorizationResponse(ctx, res.code, res.state, verifier); err != nil {
+		return fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Authorized MCP server %q\n", s.name)
+	return nil
+}
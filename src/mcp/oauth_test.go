@@ -0,0 +1,317 @@
+package mcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aandrew-me/tgpt/v2/src/tools"
+	mcptransport "github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// fakeAuthServer is a minimal OAuth 2.1 authorization server guarding an MCP
+// streamable HTTP endpoint at /mcp.
+type fakeAuthServer struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	registrations int
+	authCodes     int
+	refreshes     int
+	challenges    map[string]string
+	accessTokens  map[string]bool
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	t.Helper()
+	f := &fakeAuthServer{
+		challenges:   make(map[string]string),
+		accessTokens: make(map[string]bool),
+	}
+
+	s := server.NewMCPServer("fake", "1.0.0")
+	s.AddTool(mcp.NewTool("whoami", mcp.WithDescription("Returns a fixed identity")),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("authorized-user"), nil
+		})
+	mcpHandler := server.NewStreamableHTTPServer(s)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/oauth-authorization-server", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                           f.URL,
+			"authorization_endpoint":           f.URL + "/authorize",
+			"token_endpoint":                   f.URL + "/token",
+			"registration_endpoint":            f.URL + "/register",
+			"response_types_supported":         []string{"code"},
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.registrations++
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"client_id": "registered-client"})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != "registered-client" || q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "bad authorization request", http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.authCodes++
+		code := "code-" + q.Get("state")
+		f.challenges[code] = q.Get("code_challenge")
+		f.mu.Unlock()
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		rq := redirect.Query()
+		rq.Set("code", code)
+		rq.Set("state", q.Get("state"))
+		redirect.RawQuery = rq.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			challenge, ok := f.challenges[r.Form.Get("code")]
+			sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+			if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			delete(f.challenges, r.Form.Get("code"))
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "refresh-1" {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			f.refreshes++
+		default:
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+		token := "access-" + r.Form.Get("grant_type")
+		f.accessTokens[token] = true
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  token,
+			"token_type":    "Bearer",
+			"refresh_token": "refresh-1",
+			"expires_in":    3600,
+		})
+	})
+	mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		f.mu.Lock()
+		valid := f.accessTokens[token]
+		f.mu.Unlock()
+		if !valid {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mcpHandler.ServeHTTP(w, r)
+	})
+
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// followInBrowser stands in for the user's browser: it follows the
+// authorization redirect to tgpt's loopback listener.
+func followInBrowser(t *testing.T) {
+	t.Helper()
+	prev := openBrowser
+	openBrowser = func(u string) error {
+		go func() {
+			resp, err := http.Get(u)
+			if err != nil {
+				t.Errorf("browser request failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+		return nil
+	}
+	t.Cleanup(func() { openBrowser = prev })
+}
+
+func callWhoami(t *testing.T, r *tools.Registry) string {
+	t.Helper()
+	if !r.Has("whoami") {
+		t.Fatal("expected whoami tool to be registered")
+	}
+	out, err := r.Execute(context.Background(), "whoami", "{}")
+	if err != nil {
+		t.Fatalf("unexpected tool error: %v", err)
+	}
+	return out
+}
+
+func TestInitServerOAuthFlow(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	followInBrowser(t)
+
+	f := newFakeAuthServer(t)
+	registry := tools.NewRegistry()
+	mgr := NewManager(registry)
+	defer mgr.Close()
+
+	// No "oauth" block: the 401 alone should start the authorization flow.
+	sc := ServerConfig{URL: f.URL + "/mcp", Type: "http"}
+	if err := mgr.InitServer(context.Background(), "fake", sc); err != nil {
+		t.Fatalf("InitServer failed: %v", err)
+	}
+	if out := callWhoami(t, registry); out != "authorized-user" {
+		t.Fatalf("unexpected tool output %q", out)
+	}
+	if f.registrations != 1 || f.authCodes != 1 {
+		t.Fatalf("expected one registration and one authorization, got %d and %d", f.registrations, f.authCodes)
+	}
+
+	data, err := os.ReadFile(filepath.Join(home, ".config", "tgpt", "mcp_auth", "fake.json"))
+	if err != nil {
+		t.Fatalf("expected stored credentials: %v", err)
+	}
+	var stored storedAuth
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatalf("invalid stored credentials: %v", err)
+	}
+	if stored.ClientID != "registered-client" || stored.Token == nil || stored.Token.AccessToken != "access-authorization_code" {
+		t.Fatalf("unexpected stored credentials: %#v", stored)
+	}
+
+	// A second connection reuses the stored token without a new authorization.
+	mgr2 := NewManager(tools.NewRegistry())
+	defer mgr2.Close()
+	if err := mgr2.InitServer(context.Background(), "fake", sc); err != nil {
+		t.Fatalf("second InitServer failed: %v", err)
+	}
+	if f.registrations != 1 || f.authCodes != 1 {
+		t.Fatalf("expected stored token to be reused, got %d registrations and %d authorizations", f.registrations, f.authCodes)
+	}
+}
+
+func TestOAuthCallbackEscapesError(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	pages := make(chan string, 1)
+	prev := openBrowser
+	openBrowser = func(u string) error {
+		authURL, err := url.Parse(u)
+		if err != nil {
+			return err
+		}
+		q := authURL.Query()
+		callback := q.Get("redirect_uri") + "?" + url.Values{
+			"state":             {q.Get("state")},
+			"error":             {"access_denied"},
+			"error_description": {"<script>alert(1)</script>"},
+		}.Encode()
+		go func() {
+			resp, err := http.Get(callback)
+			if err != nil {
+				t.Errorf("browser request failed: %v", err)
+				pages <- ""
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			pages <- string(body)
+		}()
+		return nil
+	}
+	t.Cleanup(func() { openBrowser = prev })
+
+	f := newFakeAuthServer(t)
+	mgr := NewManager(tools.NewRegistry())
+	defer mgr.Close()
+	if err := mgr.InitServer(context.Background(), "fake", ServerConfig{URL: f.URL + "/mcp", Type: "http"}); err == nil {
+		t.Fatal("expected the denied authorization to fail")
+	}
+	page := <-pages
+	if strings.Contains(page, "<script>") || !strings.Contains(page, "&lt;script&gt;") {
+		t.Errorf("expected the error to be escaped in the callback page, got %q", page)
+	}
+}
+
+func TestInitServerOAuthRefreshesExpiredToken(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	prev := openBrowser
+	openBrowser = func(u string) error {
+		t.Errorf("unexpected authorization request: %s", u)
+		return nil
+	}
+	t.Cleanup(func() { openBrowser = prev })
+
+	f := newFakeAuthServer(t)
+	sc := ServerConfig{URL: f.URL + "/mcp", Type: "http", OAuth: &OAuthConfig{}}
+
+	store, err := newFileTokenStore("fake", sc.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.save(storedAuth{
+		ClientID:    "registered-client",
+		RedirectURI: loopbackRedirectURI(1),
+		Token: &mcptransport.Token{
+			AccessToken:  "stale",
+			TokenType:    "Bearer",
+			RefreshToken: "refresh-1",
+			ExpiresAt:    time.Now().Add(-time.Hour),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := tools.NewRegistry()
+	mgr := NewManager(registry)
+	defer mgr.Close()
+	if err := mgr.InitServer(context.Background(), "fake", sc); err != nil {
+		t.Fatalf("InitServer failed: %v", err)
+	}
+	if out := callWhoami(t, registry); out != "authorized-user" {
+		t.Fatalf("unexpected tool output %q", out)
+	}
+	if f.refreshes != 1 || f.authCodes != 0 {
+		t.Fatalf("expected one refresh and no authorization, got %d and %d", f.refreshes, f.authCodes)
+	}
+
+	tok, err := store.GetToken(context.Background())
+	if err != nil || tok.AccessToken != "access-refresh_token" {
+		t.Fatalf("expected refreshed token to be stored, got %#v (%v)", tok, err)
+	}
+}
+
+func TestFileTokenStoreIgnoresOtherServer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	store, _ := newFileTokenStore("srv", "https://a.example.com/mcp")
+	if err := store.SaveToken(context.Background(), &mcptransport.Token{AccessToken: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := newFileTokenStore("srv", "https://b.example.com/mcp")
+	if _, err := other.GetToken(context.Background()); err != mcptransport.ErrNoToken {
+		t.Fatalf("expected ErrNoToken for a different server URL, got %v", err)
+	}
+}
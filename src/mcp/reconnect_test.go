@@ -0,0 +1,111 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aandrew-me/tgpt/v2/src/tools"
+	mcptransport "github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// TestHelperStdioServer is not a real test: it runs a stdio MCP server when
+// the test binary is started as a subprocess by the reconnect tests. Its
+// "flaky" tool crashes the process on the first call (tracked through a marker
+// file) and succeeds afterwards.
+func TestHelperStdioServer(t *testing.T) {
+	marker := os.Getenv("TGPT_MCP_HELPER_MARKER")
+	if marker == "" {
+		return
+	}
+
+	s := server.NewMCPServer("helper", "1.0.0")
+	s.AddTool(mcp.NewTool("flaky", mcp.WithDescription("Crashes once")),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			if _, err := os.Stat(marker); os.IsNotExist(err) {
+				os.WriteFile(marker, []byte("crashed"), 0644)
+				os.Exit(1)
+			}
+			return mcp.NewToolResultText(fmt.Sprintf("ok from pid %d", os.Getpid())), nil
+		})
+	server.ServeStdio(s)
+	os.Exit(0)
+}
+
+func helperServerConfig(t *testing.T) ServerConfig {
+	t.Helper()
+	return ServerConfig{
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^TestHelperStdioServer$"},
+		Env:     []string{"TGPT_MCP_HELPER_MARKER=" + filepath.Join(t.TempDir(), "crashed")},
+	}
+}
+
+func TestCallToolReconnectsCrashedStdioServer(t *testing.T) {
+	prev := reconnectBackoff
+	reconnectBackoff = []time.Duration{10 * time.Millisecond}
+	t.Cleanup(func() { reconnectBackoff = prev })
+
+	registry := tools.NewRegistry()
+	mgr := NewManager(registry)
+	defer mgr.Close()
+
+	if err := mgr.InitServer(context.Background(), "helper", helperServerConfig(t)); err != nil {
+		t.Fatalf("InitServer failed: %v", err)
+	}
+	first := mgr.client("helper")
+
+	out, err := registry.Execute(context.Background(), "flaky", "{}")
+	if err != nil {
+		t.Fatalf("expected the call to succeed after reconnecting, got %v", err)
+	}
+	if !strings.Contains(out, "reconnected") || !strings.Contains(out, "ok from pid") {
+		t.Fatalf("expected output to report the reconnection, got %q", out)
+	}
+	if mgr.client("helper") == first {
+		t.Fatal("expected the crashed client to be replaced")
+	}
+
+	// The restarted server keeps working without another reconnect.
+	out, err = registry.Execute(context.Background(), "flaky", "{}")
+	if err != nil || strings.Contains(out, "reconnected") {
+		t.Fatalf("unexpected second call result %q (%v)", out, err)
+	}
+}
+
+func TestCallToolAfterCloseFailsWithoutConfig(t *testing.T) {
+	registry := tools.NewRegistry()
+	mgr := NewManager(registry)
+	if err := mgr.InitServer(context.Background(), "helper", helperServerConfig(t)); err != nil {
+		t.Fatalf("InitServer failed: %v", err)
+	}
+	mgr.Close()
+
+	if _, err := registry.Execute(context.Background(), "flaky", "{}"); err == nil || !strings.Contains(err.Error(), "reconnect failed") {
+		t.Fatalf("expected a reconnect failure after Close, got %v", err)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{mcptransport.NewError(mcptransport.ErrTransportClosed), true},
+		{fmt.Errorf("wrapped: %w", mcptransport.NewError(errors.New("connection refused"))), true},
+		{mcptransport.NewError(context.DeadlineExceeded), false},
+		{errors.New("Method not found"), false},
+	}
+	for _, c := range cases {
+		if got := isConnectionError(c.err); got != c.want {
+			t.Errorf("isConnectionError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
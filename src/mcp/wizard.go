@@ -230,7 +230,15 @@ func AddServerInteractive(ctx context.Context, configPath string) error {
 			}
 		}
 
-		addAuth, _ := ConfirmMenu("▶ Add Authorization Bearer Token / API Key?", false)
+		useOAuth, _ := ConfirmMenu("▶ Sign in with OAuth (opens a browser)?", false)
+		if useOAuth {
+			sc.OAuth = &OAuthConfig{}
+		}
+
+		addAuth := false
+		if !useOAuth {
+			addAuth, _ = ConfirmMenu("▶ Add Authorization Bearer Token / API Key?", false)
+		}
 		if addAuth {
 			fmt.Print("  Enter Bearer Token / API Key: ")
 			token, _ := readInput(reader)
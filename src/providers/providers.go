@@ -52,6 +52,18 @@ func SupportsTools(provider string) bool {
 	}
 }
 
+// SupportsChatHistory reports whether the provider sends the system prompt and
+// previous messages along with the input. Providers that don't only see the
+// input text.
+func SupportsChatHistory(provider string) bool {
+	switch provider {
+	case "isou", "koboldai":
+		return false
+	default:
+		return true
+	}
+}
+
 func GetMainText(line string, provider string, input string) string {
 	switch provider {
 	case "aihorde":
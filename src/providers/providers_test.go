@@ -25,3 +25,16 @@ func TestSupportsTools(t *testing.T) {
 		t.Errorf("expected fx to be a valid provider")
 	}
 }
+
+func TestSupportsChatHistory(t *testing.T) {
+	for _, p := range []string{"isou", "koboldai"} {
+		if SupportsChatHistory(p) {
+			t.Errorf("expected provider %q NOT to support chat history", p)
+		}
+	}
+	for _, p := range []string{"fx", "aihorde", "minimax", "powerbrain", "deepseek-web", "openai", ""} {
+		if !SupportsChatHistory(p) {
+			t.Errorf("expected provider %q to support chat history", p)
+		}
+	}
+}
@@ -14,12 +14,14 @@ type Params struct {
 	SystemPrompt    string
 	RotateProviders string
 	Tools           []any
+	MaxToolSteps    int // rounds of tool calls per prompt, 0 for the default
 }
 
 type ExtraOptions struct {
 	IsGetSilent        bool
 	IsGetWhole         bool
 	IsGetCommand       bool
+	IsGetSuggestion    bool // IsGetSuggestion collects a generated command without printing anything
 	IsNormal           bool
 	IsGetCode          bool
 	IsInteractive      bool
@@ -31,6 +33,8 @@ type ExtraOptions struct {
 	SearchProvider     string // Search provider: "exa" (default) or "google"
 	IsToolFollowUp     bool   // IsToolFollowUp marks a request made to continue after tool execution
 	ToolDepth          int    // ToolDepth tracks recursion depth of tool execution loops
+	ShellPrompt        string // ShellPrompt is the request a generated command answers, kept in the command history
+	ShellDir           string // ShellDir is the directory to run a generated command in, the current one if empty
 }
 
 type ToolCallFunction struct {
@@ -0,0 +1,164 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// auditHashChars is how much of the output's SHA-256 is kept: enough to match
+// an entry against a known output without storing the output itself.
+const auditHashChars = 16
+
+// AuditEntry is one line of the audit log.
+type AuditEntry struct {
+	Time         time.Time       `json:"time"`
+	SessionID    string          `json:"session_id"`
+	Provider     string          `json:"provider,omitempty"`
+	Model        string          `json:"model,omitempty"`
+	Tool         string          `json:"tool"`
+	Source       string          `json:"source"`
+	Arguments    json.RawMessage `json:"arguments"`
+	Decision     string          `json:"decision"`
+	Rule         string          `json:"rule,omitempty"`
+	Status       string          `json:"status"` // "ok", "error", "denied" or "running" (left in the background)
+	ExitCode     *int            `json:"exit_code,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	DurationMS   int64           `json:"duration_ms"`
+	OutputBytes  int             `json:"output_bytes"`
+	OutputSHA256 string          `json:"output_sha256"`
+}
+
+// AuditLog appends a JSON line for every tool call to a file. Entries are
+// only ever appended; the file is never truncated or rewritten.
+type AuditLog struct {
+	mu        sync.Mutex
+	file      *os.File
+	sessionID string
+	warned    bool
+}
+
+// OpenAuditLog opens path for appending, creating it (and its directory) if
+// needed. Entries are recorded under sessionID (see NewSessionID).
+func OpenAuditLog(path, sessionID string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &AuditLog{file: f, sessionID: sessionID}, nil
+}
+
+// SessionID identifies the tgpt run the entries belong to.
+func (a *AuditLog) SessionID() string {
+	return a.sessionID
+}
+
+// Record appends e, filling in the time and session id. A failed write is
+// reported once on stderr rather than interrupting the conversation.
+func (a *AuditLog) Record(e AuditEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.SessionID = a.sessionID
+	line, err := json.Marshal(e)
+	if err == nil {
+		a.mu.Lock()
+		_, err = a.file.Write(append(line, '\n'))
+		a.mu.Unlock()
+	}
+	if err != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if !a.warned {
+			a.warned = true
+			fmt.Fprintf(os.Stderr, "Warning: failed to write audit log: %v\n", err)
+		}
+	}
+}
+
+// Close closes the log file. Entries are not buffered, so nothing is lost if
+// the process exits without calling it.
+func (a *AuditLog) Close() error {
+	return a.file.Close()
+}
+
+// SetAuditLog installs the log tool calls are recorded in.
+func (r *Registry) SetAuditLog(a *AuditLog) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.audit = a
+}
+
+// AuditLog returns the installed audit log, or nil if calls are not audited.
+func (r *Registry) AuditLog() *AuditLog {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.audit
+}
+
+// Source returns where a tool comes from: "builtin", "custom" or the name of
+// the MCP server that provides it.
+func (r *Registry) Source(name string) string {
+	if meta, ok := r.Meta(name); ok && meta.Source != "" {
+		return meta.Source
+	}
+	if IsBuiltinTool(name) {
+		return "builtin"
+	}
+	return ""
+}
+
+// NewAuditEntry describes a finished tool call. output and err are what the
+// call returned; for calls that were not allowed to run, d says why. cmd is
+// how execute_command's command ended (see WithCommandStatus), nil for other
+// tools.
+func (r *Registry) NewAuditEntry(name, argsJSON string, d Decision, output string, err error, cmd *CommandStatus, duration time.Duration) AuditEntry {
+	args := json.RawMessage(argsJSON)
+	if argsJSON == "" {
+		args = json.RawMessage("{}")
+	} else if !json.Valid(args) {
+		args, _ = json.Marshal(argsJSON)
+	}
+
+	sum := sha256.Sum256([]byte(output))
+	e := AuditEntry{
+		Tool:         name,
+		Source:       r.Source(name),
+		Arguments:    args,
+		Decision:     d.Outcome,
+		Status:       "ok",
+		DurationMS:   duration.Milliseconds(),
+		OutputBytes:  len(output),
+		OutputSHA256: hex.EncodeToString(sum[:])[:auditHashChars],
+	}
+	if d.Rule != nil {
+		e.Rule = d.Rule.String()
+	}
+
+	switch {
+	case !d.Proceed:
+		e.Status = "denied"
+	case err != nil:
+		e.Status = "error"
+		e.Error = err.Error()
+	}
+	if cmd != nil && d.Proceed {
+		e.ExitCode = cmd.ExitCode
+		if cmd.Err != nil {
+			e.Status = "error"
+			e.Error = cmd.Err.Error()
+		}
+		if cmd.Background {
+			e.Status = "running"
+		}
+	}
+	return e
+}
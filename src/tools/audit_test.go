@@ -0,0 +1,130 @@
+package tools
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readAuditLog(t *testing.T, path string) []AuditEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditLogAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "audit.jsonl")
+	r := NewRegistry()
+	r.RegisterBuiltinTools("execute_command")
+
+	first, err := OpenAuditLog(path, "20240131-154502-aaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := &PermissionRule{Tool: "execute_command", Pattern: "ls*", Action: "allow"}
+	zero, one := 0, 1
+	first.Record(r.NewAuditEntry("execute_command", `{"command": "ls"}`,
+		Decision{Proceed: true, Outcome: OutcomeRuleAllow, Rule: rule}, "a\nb\n", nil, &CommandStatus{ExitCode: &zero}, 1500*time.Millisecond))
+	first.Close()
+
+	second, err := OpenAuditLog(path, "20240131-160000-bbbb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Record(r.NewAuditEntry("execute_command", `{"command": "false"}`,
+		Decision{Proceed: true, Outcome: OutcomeAutoExec}, "Command failed with error: exit status 1\nOutput: ", nil,
+		&CommandStatus{ExitCode: &one, Err: errors.New("command failed: exit status 1")}, 0))
+	second.Record(r.NewAuditEntry("execute_command", `{"command": "rm -rf /"}`,
+		Decision{Proceed: false, Outcome: OutcomeRuleDeny, Rule: &PermissionRule{Tool: "execute_command", Pattern: "rm -rf*", Action: "deny"}}, "", nil, &CommandStatus{}, 0))
+	second.Close()
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected the audit log to be private, got %v (%v)", info.Mode(), err)
+	}
+
+	entries := readAuditLog(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries across both sessions, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Tool != "execute_command" || e.Source != "builtin" || string(e.Arguments) != `{"command":"ls"}` ||
+		e.Decision != OutcomeRuleAllow || e.Rule != `allow execute_command "ls*"` || e.Status != "ok" ||
+		e.ExitCode == nil || *e.ExitCode != 0 || e.DurationMS != 1500 || e.OutputBytes != 4 || len(e.OutputSHA256) != auditHashChars {
+		t.Errorf("unexpected first entry: %+v", e)
+	}
+	if e.SessionID != "20240131-154502-aaaa" || entries[1].SessionID != "20240131-160000-bbbb" || entries[2].SessionID != entries[1].SessionID {
+		t.Errorf("expected entries to carry their session id, got %q, %q, %q", e.SessionID, entries[1].SessionID, entries[2].SessionID)
+	}
+	if entries[1].Status != "error" || entries[1].ExitCode == nil || *entries[1].ExitCode != 1 {
+		t.Errorf("expected the failed command's exit code, got %+v", entries[1])
+	}
+	if entries[2].Status != "denied" || entries[2].Decision != OutcomeRuleDeny || entries[2].Rule != `deny execute_command "rm -rf*"` {
+		t.Errorf("unexpected denied entry: %+v", entries[2])
+	}
+}
+
+func TestAuditEntryForUnfinishedCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX shell commands")
+	}
+	r := newCommandRegistry(t, 200*time.Millisecond)
+	decision := Decision{Proceed: true, Outcome: OutcomeAutoExec}
+	run := func(command string) AuditEntry {
+		ctx, status := WithCommandStatus(commandContext(nil))
+		args := `{"command": "` + command + `"}`
+		out, err := r.ExecuteWithTimeout(ctx, "execute_command", args)
+		return r.NewAuditEntry("execute_command", args, decision, out, err, status, 0)
+	}
+
+	if e := run("exit 3"); e.Status != "error" || e.ExitCode == nil || *e.ExitCode != 3 {
+		t.Errorf("expected the exit status of a failed command, got %+v", e)
+	}
+	if e := run("sleep 5"); e.Status != "running" || e.ExitCode != nil {
+		t.Errorf("expected a command moved to the background not to be logged as finished, got %+v", e)
+	}
+
+	r.SetLimits(ToolLimits{Timeout: time.Minute})
+	go func() {
+		for !r.InterruptCommand() {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	if e := run("sleep 5"); e.Status != "error" || e.ExitCode != nil || !strings.Contains(e.Error, "interrupted") {
+		t.Errorf("expected an interrupted command to be logged as an error, got %+v", e)
+	}
+}
+
+func TestNewAuditEntrySourcesAndErrors(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterWithMeta(ToolSpec{Type: "function", Function: FunctionSpec{Name: "fs_read"}}, ToolMeta{Source: "filesystem"}, nil)
+
+	e := r.NewAuditEntry("fs_read", "not json", Decision{Proceed: true, Outcome: OutcomeNotRequired}, "", errors.New("boom"), nil, 0)
+	if e.Source != "filesystem" || e.Status != "error" || e.Error != "boom" || e.ExitCode != nil {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if string(e.Arguments) != `"not json"` {
+		t.Errorf("expected invalid arguments to be recorded as a string, got %s", e.Arguments)
+	}
+	if other := r.NewAuditEntry("fs_read", "", Decision{Proceed: true}, "x", nil, nil, 0); other.OutputSHA256 == e.OutputSHA256 {
+		t.Error("expected different outputs to hash differently")
+	}
+}
@@ -0,0 +1,423 @@
+package tools
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCheckpointSessions is how many sessions keep their snapshots; older ones
+// are removed when a new session first changes a file.
+const maxCheckpointSessions = 20
+
+// writtenAbsent is the Written value of a file the tools removed.
+const writtenAbsent = "absent"
+
+// CheckpointFile records the state of a file before a tool first changed it
+// during a session.
+type CheckpointFile struct {
+	Path     string      `json:"path"` // absolute
+	Existed  bool        `json:"existed"`
+	Mode     os.FileMode `json:"mode,omitempty"`
+	Snapshot string      `json:"snapshot,omitempty"` // file holding the old content, relative to the session directory
+	Written  string      `json:"written,omitempty"`  // SHA-256 of the content the tools left, "absent" if they removed the file
+	Tool     string      `json:"tool"`
+	Time     time.Time   `json:"time"`
+}
+
+// ChangedFilesError is returned when reverting would overwrite changes made
+// to files after the tools last wrote them. Nothing is reverted.
+type ChangedFilesError struct {
+	Paths []string
+}
+
+func (e *ChangedFilesError) Error() string {
+	return fmt.Sprintf("changed since the tools wrote them: %s", strings.Join(e.Paths, ", "))
+}
+
+// CheckpointManifest lists the files snapshotted during a session.
+type CheckpointManifest struct {
+	Session string           `json:"session"`
+	Started time.Time        `json:"started"`
+	Workdir string           `json:"workdir"`
+	Files   []CheckpointFile `json:"files"`
+}
+
+// Checkpoints snapshots files before the file tools (write_file, edit_file and
+// apply_patch) first change them in a session, so that the changes can be
+// listed and reverted later. Each session is a directory holding a
+// manifest.json and the old file contents.
+type Checkpoints struct {
+	mu       sync.Mutex
+	root     string
+	manifest CheckpointManifest
+	seen     map[string]bool
+}
+
+// NewSessionID returns an id that sorts by start time, e.g.
+// "20240131-154502-9f3a".
+func NewSessionID() string {
+	suffix := make([]byte, 2)
+	_, _ = rand.Read(suffix)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// DefaultCheckpointDir returns tgpt/checkpoints under $XDG_STATE_HOME
+// (~/.local/state by default).
+func DefaultCheckpointDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "tgpt", "checkpoints")
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".local", "state", "tgpt", "checkpoints")
+}
+
+// NewCheckpoints starts recording session under root. Nothing is written
+// until the first file is snapshotted.
+func NewCheckpoints(root, session string) *Checkpoints {
+	wd, _ := os.Getwd()
+	return &Checkpoints{
+		root:     root,
+		manifest: CheckpointManifest{Session: session, Started: time.Now(), Workdir: wd},
+		seen:     make(map[string]bool),
+	}
+}
+
+// Session returns the id of the session being recorded.
+func (c *Checkpoints) Session() string {
+	return c.manifest.Session
+}
+
+// Len returns the number of files snapshotted so far.
+func (c *Checkpoints) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.manifest.Files)
+}
+
+func (c *Checkpoints) dir() string {
+	return filepath.Join(c.root, c.manifest.Session)
+}
+
+// Snapshot saves path as it is now, unless it was already saved in this
+// session. A file that does not exist yet is recorded so that reverting
+// removes it.
+func (c *Checkpoints) Snapshot(path, tool string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen[abs] {
+		return nil
+	}
+
+	if len(c.manifest.Files) == 0 {
+		if err := os.MkdirAll(c.dir(), 0700); err != nil {
+			return err
+		}
+		pruneCheckpoints(c.root, c.manifest.Session)
+	}
+
+	entry := CheckpointFile{Path: abs, Tool: tool, Time: time.Now()}
+	info, err := os.Stat(abs)
+	switch {
+	case err == nil && info.IsDir():
+		return fmt.Errorf("%s is a directory", abs)
+	case err == nil:
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			return err
+		}
+		entry.Existed = true
+		entry.Mode = info.Mode().Perm()
+		entry.Snapshot = strconv.Itoa(len(c.manifest.Files) + 1)
+		if err := os.WriteFile(filepath.Join(c.dir(), entry.Snapshot), data, 0600); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	c.manifest.Files = append(c.manifest.Files, entry)
+	if err := writeManifest(c.dir(), c.manifest); err != nil {
+		c.manifest.Files = c.manifest.Files[:len(c.manifest.Files)-1]
+		return err
+	}
+	c.seen[abs] = true
+	return nil
+}
+
+// Wrote records the content a tool left path with, so that reverting can
+// tell whether the file was changed afterwards.
+func (c *Checkpoints) Wrote(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.manifest.Files {
+		f := &c.manifest.Files[i]
+		if f.Path != abs {
+			continue
+		}
+		if f.Written, err = contentHash(abs); err != nil {
+			return err
+		}
+		return writeManifest(c.dir(), c.manifest)
+	}
+	return nil
+}
+
+// contentHash returns the SHA-256 of the content of path, or writtenAbsent
+// if it does not exist.
+func contentHash(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return writtenAbsent, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Revert restores every file of the current session and starts the session
+// over, so later changes are snapshotted again. Unless force is set, files
+// changed since the tools wrote them fail the revert with a
+// *ChangedFilesError.
+func (c *Checkpoints) Revert(force bool) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.manifest.Files) == 0 {
+		return nil, nil
+	}
+	done, err := revertManifest(c.dir(), c.manifest, force)
+	if err != nil {
+		return done, err
+	}
+	c.manifest.Files = nil
+	c.seen = make(map[string]bool)
+	return done, os.RemoveAll(c.dir())
+}
+
+func writeManifest(dir string, m CheckpointManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, "manifest.json"), data, 0600)
+}
+
+// LoadCheckpoint reads the manifest of session from root. "last" selects the
+// most recent session.
+func LoadCheckpoint(root, session string) (*CheckpointManifest, error) {
+	if session == "" || session == "last" {
+		sessions, err := ListCheckpoints(root)
+		if err != nil {
+			return nil, err
+		}
+		if len(sessions) == 0 {
+			return nil, fmt.Errorf("no checkpoints found in %s", root)
+		}
+		return &sessions[0], nil
+	}
+	if strings.ContainsAny(session, `/\`) || session == "." || session == ".." {
+		return nil, fmt.Errorf("invalid session id %q", session)
+	}
+	data, err := os.ReadFile(filepath.Join(root, session, "manifest.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no checkpoint for session %q", session)
+		}
+		return nil, err
+	}
+	var m CheckpointManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint manifest for session %q: %w", session, err)
+	}
+	return &m, nil
+}
+
+// ListCheckpoints returns the recorded sessions, newest first.
+func ListCheckpoints(root string) ([]CheckpointManifest, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var sessions []CheckpointManifest
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if m, err := LoadCheckpoint(root, e.Name()); err == nil {
+			sessions = append(sessions, *m)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Started.After(sessions[j].Started)
+	})
+	return sessions, nil
+}
+
+// RevertCheckpoint restores the files of session and removes its checkpoint.
+// It returns a line per file describing what was done. As with Revert,
+// changes made after the tools wrote a file are only overwritten with force.
+func RevertCheckpoint(root, session string, force bool) ([]string, error) {
+	m, err := LoadCheckpoint(root, session)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(root, m.Session)
+	done, err := revertManifest(dir, *m, force)
+	if err != nil {
+		return done, err
+	}
+	return done, os.RemoveAll(dir)
+}
+
+func revertManifest(dir string, m CheckpointManifest, force bool) ([]string, error) {
+	if !force {
+		var changed []string
+		for _, f := range m.Files {
+			if f.Written == "" {
+				continue // the tool failed before writing, or an older manifest
+			}
+			if hash, err := contentHash(f.Path); err != nil || hash != f.Written {
+				changed = append(changed, f.Path)
+			}
+		}
+		if len(changed) > 0 {
+			return nil, &ChangedFilesError{Paths: changed}
+		}
+	}
+
+	var done []string
+	for _, f := range m.Files {
+		if !f.Existed {
+			if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+				return done, fmt.Errorf("failed to remove %s: %w", f.Path, err)
+			}
+			done = append(done, "removed "+f.Path)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, f.Snapshot))
+		if err != nil {
+			return done, fmt.Errorf("failed to read snapshot of %s: %w", f.Path, err)
+		}
+		if err := writeFileAtomic(f.Path, data, f.Mode); err != nil {
+			return done, fmt.Errorf("failed to restore %s: %w", f.Path, err)
+		}
+		done = append(done, "restored "+f.Path)
+	}
+	return done, nil
+}
+
+// CheckpointDiff returns a diff from the snapshot of f to its current
+// content.
+func CheckpointDiff(root string, m CheckpointManifest, f CheckpointFile) string {
+	old := ""
+	if f.Existed {
+		data, err := os.ReadFile(filepath.Join(root, m.Session, f.Snapshot))
+		if err != nil {
+			return fmt.Sprintf("(snapshot missing: %v)", err)
+		}
+		old = string(data)
+	}
+	current := ""
+	if data, err := os.ReadFile(f.Path); err == nil {
+		current = string(data)
+	} else if !os.IsNotExist(err) {
+		return fmt.Sprintf("(cannot read current file: %v)", err)
+	}
+	if old == current && f.Existed {
+		return bold.Sprintf("%s (unchanged)", displayPath(m.Workdir, f.Path))
+	}
+	return renderFileDiff(displayPath(m.Workdir, f.Path), old, current)
+}
+
+func displayPath(base, path string) string {
+	if rel, err := filepath.Rel(base, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// pruneCheckpoints removes the oldest sessions beyond maxCheckpointSessions,
+// never the current one.
+func pruneCheckpoints(root, current string) {
+	sessions, err := ListCheckpoints(root)
+	if err != nil {
+		return
+	}
+	kept := 1 // the current session, which has no manifest yet
+	for _, m := range sessions {
+		if m.Session == current {
+			continue
+		}
+		if kept < maxCheckpointSessions {
+			kept++
+			continue
+		}
+		os.RemoveAll(filepath.Join(root, m.Session))
+	}
+}
+
+// SetCheckpoints installs the snapshots the file tools record into.
+func (r *Registry) SetCheckpoints(c *Checkpoints) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkpoints = c
+}
+
+// Checkpoints returns the installed snapshots, or nil.
+func (r *Registry) Checkpoints() *Checkpoints {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.checkpoints
+}
+
+// snapshot records path before tool changes it, if checkpoints are enabled.
+func (r *Registry) snapshot(path, tool string) error {
+	c := r.Checkpoints()
+	if c == nil {
+		return nil
+	}
+	if err := c.Snapshot(path, tool); err != nil {
+		return fmt.Errorf("failed to snapshot %s before changing it: %w", path, err)
+	}
+	return nil
+}
+
+// wrote records what the tools left in paths after changing them, if
+// checkpoints are enabled. The change has been made by then, so a failure
+// is only reported.
+func (r *Registry) wrote(paths ...string) {
+	c := r.Checkpoints()
+	if c == nil {
+		return
+	}
+	for _, path := range paths {
+		if err := c.Wrote(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to record the change to %s in the checkpoint: %v\n", path, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpointsRevertFileTools(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "write_file", "edit_file", "apply_patch")
+	root := t.TempDir()
+	r.SetCheckpoints(NewCheckpoints(root, "s1"))
+	writeTestFile(t, dir, "notes.txt", "one\ntwo\n")
+	ctx := context.WithValue(context.Background(), AutoExecKey, true)
+
+	calls := []struct{ tool, args string }{
+		{"edit_file", `{"path": "notes.txt", "old_content": "two", "new_content": "2"}`},
+		{"edit_file", `{"path": "notes.txt", "old_content": "one", "new_content": "1"}`},
+		{"write_file", `{"path": "sub/new.txt", "content": "hello\n"}`},
+	}
+	for _, c := range calls {
+		if _, err := r.Execute(ctx, c.tool, c.args); err != nil {
+			t.Fatalf("%s failed: %v", c.tool, err)
+		}
+	}
+	if _, err := runPatch(t, r, "--- a/notes.txt\n+++ b/notes.txt\n@@ -1,2 +1,3 @@\n 1\n 2\n+3\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Checkpoints().Len(); got != 2 {
+		t.Fatalf("expected each file to be snapshotted once, got %d snapshots", got)
+	}
+
+	m, err := LoadCheckpoint(root, "last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Session != "s1" || len(m.Files) != 2 || !m.Files[0].Existed || m.Files[1].Existed {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	if diff := CheckpointDiff(root, *m, m.Files[0]); !strings.Contains(diff, "-two") || !strings.Contains(diff, "+3") {
+		t.Errorf("expected a diff from the snapshot to the current file, got %q", diff)
+	}
+
+	done, err := r.Checkpoints().Revert(false)
+	if err != nil || len(done) != 2 {
+		t.Fatalf("expected 2 files to be reverted, got %v (%v)", done, err)
+	}
+	if got := readTestFile(t, dir, "notes.txt"); got != "one\ntwo\n" {
+		t.Errorf("expected notes.txt to be restored, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub", "new.txt")); !os.IsNotExist(err) {
+		t.Error("expected the created file to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(root, "s1")); !os.IsNotExist(err) {
+		t.Error("expected the reverted checkpoint to be removed")
+	}
+	if r.Checkpoints().Len() != 0 {
+		t.Error("expected the session to start over after reverting")
+	}
+}
+
+func TestRevertCheckpointFromLaterRun(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "write_file", "edit_file", "apply_patch")
+	root := t.TempDir()
+	r.SetCheckpoints(NewCheckpoints(root, "s1"))
+	writeTestFile(t, dir, "a.txt", "before\n")
+	ctx := context.WithValue(context.Background(), AutoExecKey, true)
+	if _, err := r.Execute(ctx, "write_file", `{"path": "a.txt", "content": "after\n"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RevertCheckpoint(root, "missing", false); err == nil {
+		t.Error("expected an unknown session to fail")
+	}
+	if _, err := RevertCheckpoint(root, "../s1", false); err == nil {
+		t.Error("expected a session id with a path to be rejected")
+	}
+	if _, err := RevertCheckpoint(root, "s1", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, dir, "a.txt"); got != "before\n" {
+		t.Errorf("expected a.txt to be restored, got %q", got)
+	}
+	if sessions, _ := ListCheckpoints(root); len(sessions) != 0 {
+		t.Errorf("expected no sessions left, got %+v", sessions)
+	}
+}
+
+func TestRevertKeepsLaterChanges(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "write_file", "edit_file", "apply_patch")
+	root := t.TempDir()
+	r.SetCheckpoints(NewCheckpoints(root, "s1"))
+	writeTestFile(t, dir, "a.txt", "before\n")
+	ctx := context.WithValue(context.Background(), AutoExecKey, true)
+	if _, err := r.Execute(ctx, "write_file", `{"path": "a.txt", "content": "tool\n"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Execute(ctx, "write_file", `{"path": "b.txt", "content": "tool\n"}`); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "a.txt", "user\n")
+
+	_, err := RevertCheckpoint(root, "s1", false)
+	var changed *ChangedFilesError
+	if !errors.As(err, &changed) || len(changed.Paths) != 1 || filepath.Base(changed.Paths[0]) != "a.txt" {
+		t.Fatalf("expected the revert to stop at the changed a.txt, got %v", err)
+	}
+	if got := readTestFile(t, dir, "a.txt"); got != "user\n" {
+		t.Errorf("expected the later change to be kept, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.txt")); err != nil {
+		t.Error("expected nothing to be reverted when a file has changed")
+	}
+
+	if _, err := RevertCheckpoint(root, "s1", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, dir, "a.txt"); got != "before\n" {
+		t.Errorf("expected a forced revert to restore a.txt, got %q", got)
+	}
+}
+
+func TestDefaultCheckpointDir(t *testing.T) {
+	state := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", state)
+	if got := DefaultCheckpointDir(); got != filepath.Join(state, "tgpt", "checkpoints") {
+		t.Errorf("expected checkpoints under $XDG_STATE_HOME, got %s", got)
+	}
+}
+
+func TestPruneCheckpoints(t *testing.T) {
+	root := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < maxCheckpointSessions+2; i++ {
+		m := CheckpointManifest{Session: "old" + string(rune('a'+i)), Started: start.Add(time.Duration(i) * time.Minute)}
+		if err := os.MkdirAll(filepath.Join(root, m.Session), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeManifest(filepath.Join(root, m.Session), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruneCheckpoints(root, "current")
+	sessions, err := ListCheckpoints(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != maxCheckpointSessions-1 {
+		t.Fatalf("expected %d sessions to be kept besides the current one, got %d", maxCheckpointSessions-1, len(sessions))
+	}
+	if sessions[len(sessions)-1].Session != "oldd" {
+		t.Errorf("expected the oldest sessions to be removed, oldest left is %s", sessions[len(sessions)-1].Session)
+	}
+}
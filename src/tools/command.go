@@ -0,0 +1,450 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// commandCaptureBytes is how much of the beginning and of the end of a
+	// command's output is kept. The middle of longer output is dropped; the
+	// tool output budget shortens what reaches the model further.
+	commandCaptureBytes = 64 * 1024
+	// commandStopGrace is how long a stopped command may take to exit
+	// before it is killed.
+	commandStopGrace = 3 * time.Second
+	// commandPipeDelay bounds the wait for output after a command exits, for
+	// commands that leave a process holding the output pipe open.
+	commandPipeDelay = time.Second
+)
+
+// CommandOutputKey holds an io.Writer that execute_command streams output to
+// while the command runs in the foreground.
+const CommandOutputKey contextKey = "command_output"
+
+// commandTimeoutKey holds how long execute_command waits for a command
+// before moving it to the background. Set by ExecuteWithTimeout.
+const commandTimeoutKey contextKey = "command_timeout"
+
+// commandStatusKey holds the *CommandStatus execute_command fills in.
+const commandStatusKey contextKey = "command_status"
+
+// CommandStatus is how a command run by execute_command ended, for callers
+// that need more than the text returned to the model.
+type CommandStatus struct {
+	ExitCode   *int  // set if the command exited normally in the foreground
+	Background bool  // the command is still running in the background
+	Err        error // why the command did not finish successfully, nil if it did or was started in the background on request
+}
+
+// WithCommandStatus returns a context in which execute_command records how
+// its command ended in the returned status.
+func WithCommandStatus(ctx context.Context) (context.Context, *CommandStatus) {
+	status := &CommandStatus{}
+	return context.WithValue(ctx, commandStatusKey, status), status
+}
+
+// commandOutput captures the combined output of a command, keeping its
+// beginning and end, and copies it to a stream while one is set.
+type commandOutput struct {
+	mu     sync.Mutex
+	head   []byte
+	tail   []byte
+	total  int64
+	stream io.Writer
+}
+
+func (o *commandOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stream != nil {
+		o.stream.Write(p)
+	}
+	n := len(p)
+	o.total += int64(n)
+	if room := commandCaptureBytes - len(o.head); room > 0 {
+		k := min(room, len(p))
+		o.head = append(o.head, p[:k]...)
+		p = p[k:]
+	}
+	o.tail = append(o.tail, p...)
+	if len(o.tail) > 2*commandCaptureBytes {
+		o.tail = append([]byte(nil), o.tail[len(o.tail)-commandCaptureBytes:]...)
+	}
+	return n, nil
+}
+
+// read returns the output written after offset, noting any part of it that
+// was not kept, and the offset to read from next time.
+func (o *commandOutput) read(offset int64) (string, int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var b strings.Builder
+	if offset < int64(len(o.head)) {
+		b.Write(o.head[offset:])
+		offset = int64(len(o.head))
+	}
+	tailStart := o.total - int64(len(o.tail))
+	if offset < tailStart {
+		fmt.Fprintf(&b, "\n... [%d bytes of output omitted] ...\n", tailStart-offset)
+		offset = tailStart
+	}
+	b.Write(o.tail[offset-tailStart:])
+	return b.String(), o.total
+}
+
+// detach stops copying output to the stream, writing note to it first.
+func (o *commandOutput) detach(note string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stream != nil && note != "" {
+		fmt.Fprint(o.stream, note)
+	}
+	o.stream = nil
+}
+
+// commandJob is a command started by execute_command.
+type commandJob struct {
+	id          int
+	command     string
+	started     time.Time
+	cmd         *exec.Cmd
+	out         *commandOutput
+	done        chan struct{} // closed once the command has exited
+	err         error         // set before done is closed
+	ended       time.Time
+	interrupted atomic.Bool // stopped with Ctrl+C
+	reported    int64       // output already returned by command_status
+}
+
+func shellCommand(cmdStr string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd.exe", "/C", cmdStr)
+	}
+	return exec.Command("sh", "-c", cmdStr)
+}
+
+// startCommand starts cmdStr, inside sandbox if it is not nil, in which case
+// the output starts with the restrictions in force.
+func startCommand(cmdStr string, stream io.Writer, sandbox *Sandbox) (*commandJob, error) {
+	cmd := shellCommand(cmdStr)
+	out := &commandOutput{stream: stream}
+	if sandbox != nil {
+		if err := sandbox.Wrap(cmd); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "[%s]\n", sandbox.Describe())
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = commandPipeDelay
+	prepareCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	job := &commandJob{command: cmdStr, started: time.Now(), cmd: cmd, out: out, done: make(chan struct{})}
+	go func() {
+		err := cmd.Wait()
+		if errors.Is(err, exec.ErrWaitDelay) {
+			err = nil
+		}
+		job.err = err
+		job.ended = time.Now()
+		close(job.done)
+	}()
+	return job, nil
+}
+
+// stop terminates the command, killing it if it does not exit in time, and
+// waits for it to end.
+func (j *commandJob) stop() {
+	select {
+	case <-j.done:
+		return
+	default:
+	}
+	terminateCommand(j.cmd)
+	select {
+	case <-j.done:
+	case <-time.After(commandStopGrace):
+		killCommand(j.cmd)
+		<-j.done
+	}
+}
+
+// record fills in status for a command that has ended.
+func (j *commandJob) record(status *CommandStatus) {
+	var exitErr *exec.ExitError
+	switch {
+	case j.err == nil:
+		code := 0
+		status.ExitCode = &code
+	case errors.As(j.err, &exitErr) && exitErr.ExitCode() >= 0:
+		code := exitErr.ExitCode()
+		status.ExitCode = &code
+	}
+	if j.interrupted.Load() {
+		status.Err = errors.New("command interrupted by user")
+	} else if j.err != nil {
+		status.Err = fmt.Errorf("command failed: %w", j.err)
+	}
+}
+
+// result is what execute_command returns for a command that has ended.
+func (j *commandJob) result() string {
+	out, _ := j.out.read(0)
+	switch {
+	case j.interrupted.Load():
+		return fmt.Sprintf("Command interrupted by user.\nOutput: %s", out)
+	case j.err != nil:
+		return fmt.Sprintf("Command failed with error: %v\nOutput: %s", j.err, out)
+	}
+	return out
+}
+
+// status describes the job and the output it produced since the last call.
+func (j *commandJob) status() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Job %d: %s\n", j.id, j.command)
+	select {
+	case <-j.done:
+		elapsed := j.ended.Sub(j.started).Round(time.Second)
+		var exitErr *exec.ExitError
+		switch {
+		case j.err == nil:
+			fmt.Fprintf(&b, "Status: exited with status 0 after %s\n", elapsed)
+		case errors.As(j.err, &exitErr) && exitErr.ExitCode() >= 0:
+			fmt.Fprintf(&b, "Status: exited with status %d after %s\n", exitErr.ExitCode(), elapsed)
+		default:
+			fmt.Fprintf(&b, "Status: failed after %s: %v\n", elapsed, j.err)
+		}
+	default:
+		fmt.Fprintf(&b, "Status: running for %s\n", time.Since(j.started).Round(time.Second))
+	}
+
+	out, next := j.out.read(j.reported)
+	j.reported = next
+	if out == "" {
+		b.WriteString("No new output since the last check.")
+	} else {
+		b.WriteString("New output:\n" + out)
+	}
+	return b.String()
+}
+
+// commandJobs tracks the command running in the foreground and those moved
+// to the background.
+type commandJobs struct {
+	mu         sync.Mutex
+	next       int
+	background map[int]*commandJob
+	foreground *commandJob
+}
+
+func (c *commandJobs) add(job *commandJob) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.background == nil {
+		c.background = make(map[int]*commandJob)
+	}
+	c.next++
+	job.id = c.next
+	c.background[job.id] = job
+	return job.id
+}
+
+func (c *commandJobs) get(id int) (*commandJob, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if job, ok := c.background[id]; ok {
+		return job, nil
+	}
+	if len(c.background) == 0 {
+		return nil, fmt.Errorf("no background command with id %d: no commands are running in the background", id)
+	}
+	var ids []string
+	for id := range c.background {
+		ids = append(ids, fmt.Sprint(id))
+	}
+	sort.Strings(ids)
+	return nil, fmt.Errorf("no background command with id %d (known ids: %s)", id, strings.Join(ids, ", "))
+}
+
+func (c *commandJobs) setForeground(job *commandJob) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.foreground = job
+}
+
+// runCommand runs cmdStr for execute_command. A foreground command streams
+// its output to the writer in ctx and moves to the background when it
+// outlives its timeout or the user presses Ctrl+Z.
+func (r *Registry) runCommand(ctx context.Context, cmdStr string, background bool) string {
+	stream, _ := ctx.Value(CommandOutputKey).(io.Writer)
+	if background {
+		stream = nil
+	}
+	status, ok := ctx.Value(commandStatusKey).(*CommandStatus)
+	if !ok {
+		status = &CommandStatus{}
+	}
+	job, err := startCommand(cmdStr, stream, r.Sandbox())
+	if err != nil {
+		status.Err = fmt.Errorf("command failed to start: %w", err)
+		return fmt.Sprintf("Command failed with error: %v\nOutput: ", err)
+	}
+	if background {
+		status.Background = true
+		id := r.commands.add(job)
+		return fmt.Sprintf("Started in the background as job %d. Use command_status with id %d to see its output and whether it has finished.", id, id)
+	}
+
+	r.commands.setForeground(job)
+	defer r.commands.setForeground(nil)
+
+	detach := make(chan os.Signal, 1)
+	if len(backgroundSignals) > 0 {
+		signal.Notify(detach, backgroundSignals...)
+		defer signal.Stop(detach)
+	}
+	timeout, _ := ctx.Value(commandTimeoutKey).(time.Duration)
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		job.stop()
+	case <-detach:
+		status.Background = true
+		status.Err = errors.New("command moved to the background before it finished")
+		return r.moveToBackground(job, "The user moved the command to the background")
+	case <-expired:
+		status.Background = true
+		status.Err = fmt.Errorf("command still running after %s", timeout)
+		return r.moveToBackground(job, fmt.Sprintf("The command was still running after %s, so it was moved to the background", timeout))
+	}
+	job.record(status)
+	return job.result()
+}
+
+func (r *Registry) moveToBackground(job *commandJob, reason string) string {
+	id := r.commands.add(job)
+	job.out.detach(fmt.Sprintf("\n[still running in the background as job %d]\n", id))
+	out, next := job.out.read(0)
+	job.reported = next
+	return fmt.Sprintf("%s as job %d. Output so far:\n%s\n\nUse command_status with id %d to see new output and whether it has finished.", reason, id, out, id)
+}
+
+// InterruptCommand stops the command execute_command is running in the
+// foreground, if any, and reports whether there was one, so that Ctrl+C
+// stops the command rather than tgpt.
+func (r *Registry) InterruptCommand() bool {
+	r.commands.mu.Lock()
+	job := r.commands.foreground
+	r.commands.mu.Unlock()
+	if job == nil {
+		return false
+	}
+	job.interrupted.Store(true)
+	go job.stop()
+	return true
+}
+
+// StopCommands stops the commands still running in the background, for
+// when tgpt exits.
+func (r *Registry) StopCommands() {
+	r.commands.mu.Lock()
+	var jobs []*commandJob
+	for _, job := range r.commands.background {
+		jobs = append(jobs, job)
+	}
+	r.commands.mu.Unlock()
+
+	for _, job := range jobs {
+		select {
+		case <-job.done:
+		default:
+			terminateCommand(job.cmd)
+		}
+	}
+	deadline := time.After(commandStopGrace)
+	expired := false
+	for _, job := range jobs {
+		if !expired {
+			select {
+			case <-job.done:
+				continue
+			case <-deadline:
+				expired = true
+			}
+		}
+		killCommand(job.cmd)
+	}
+}
+
+func (r *Registry) registerCommandStatusTool() {
+	r.RegisterWithMeta(ToolSpec{
+		Type: "function",
+		Function: FunctionSpec{
+			Name:        "command_status",
+			Description: "Check on a command running in the background: returns whether it is still running or its exit status, and the output it produced since the last check",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"id": map[string]any{
+						"type":        "integer",
+						"description": "The job id execute_command returned",
+					},
+					"wait": map[string]any{
+						"type":        "integer",
+						"description": "Seconds to wait for the command to finish before reporting (default: 0)",
+					},
+					"stop": map[string]any{
+						"type":        "boolean",
+						"description": "Stop the command",
+					},
+				},
+				"required": []string{"id"},
+			},
+		},
+	}, ToolMeta{Source: "builtin"}, func(ctx context.Context, args map[string]any) (string, error) {
+		id, ok := args["id"].(float64)
+		if !ok {
+			return "", fmt.Errorf("id parameter is required")
+		}
+		job, err := r.commands.get(int(id))
+		if err != nil {
+			return "", err
+		}
+
+		if stop, _ := args["stop"].(bool); stop {
+			job.stop()
+		} else if wait, _ := args["wait"].(float64); wait > 0 {
+			timer := time.NewTimer(time.Duration(wait * float64(time.Second)))
+			defer timer.Stop()
+			select {
+			case <-job.done:
+			case <-timer.C:
+			case <-ctx.Done():
+			}
+		}
+		return job.status(), nil
+	})
+}
@@ -0,0 +1,156 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe to write from the command's output
+// goroutine while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newCommandRegistry(t *testing.T, timeout time.Duration) *Registry {
+	t.Helper()
+	r := NewRegistry()
+	r.RegisterBuiltinTools("execute_command")
+	r.SetLimits(ToolLimits{Timeout: timeout})
+	t.Cleanup(r.StopCommands)
+	return r
+}
+
+func commandContext(stream *syncBuffer) context.Context {
+	ctx := context.WithValue(context.Background(), AutoExecKey, true)
+	if stream != nil {
+		ctx = context.WithValue(ctx, CommandOutputKey, stream)
+	}
+	return ctx
+}
+
+func TestExecuteCommandStreamsOutput(t *testing.T) {
+	r := newCommandRegistry(t, time.Minute)
+	stream := &syncBuffer{}
+
+	out, err := r.ExecuteWithTimeout(commandContext(stream), "execute_command", `{"command": "echo one; echo two >&2"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "one\ntwo\n" || stream.String() != out {
+		t.Errorf("expected the output to be returned and streamed, got %q and streamed %q", out, stream.String())
+	}
+
+	out, _ = r.ExecuteWithTimeout(commandContext(nil), "execute_command", `{"command": "echo oops; exit 3"}`)
+	if !strings.HasPrefix(out, "Command failed with error: exit status 3") || !strings.Contains(out, "oops") {
+		t.Errorf("unexpected output for a failed command: %q", out)
+	}
+}
+
+func TestExecuteCommandMovesToBackgroundAfterTimeout(t *testing.T) {
+	r := newCommandRegistry(t, 200*time.Millisecond)
+	stream := &syncBuffer{}
+	ctx := commandContext(stream)
+
+	out, err := r.ExecuteWithTimeout(ctx, "execute_command", `{"command": "echo started; sleep 1; echo finished"}`)
+	if err != nil {
+		t.Fatalf("expected no timeout error, got %v", err)
+	}
+	if !strings.Contains(out, "moved to the background as job 1") || !strings.Contains(out, "started") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	if !strings.Contains(stream.String(), "[still running in the background as job 1]") {
+		t.Errorf("expected the user to be told, streamed %q", stream.String())
+	}
+
+	status, err := r.Execute(ctx, "command_status", `{"id": 1, "wait": 5}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(status, "exited with status 0") || !strings.HasSuffix(status, "New output:\nfinished\n") {
+		t.Errorf("expected the final status with only new output, got %q", status)
+	}
+	if strings.Contains(stream.String(), "finished") {
+		t.Error("expected background output not to be streamed")
+	}
+
+	status, _ = r.Execute(ctx, "command_status", `{"id": 1}`)
+	if !strings.Contains(status, "No new output") {
+		t.Errorf("expected no new output on a second check, got %q", status)
+	}
+	if _, err := r.Execute(ctx, "command_status", `{"id": 7}`); err == nil || !strings.Contains(err.Error(), "known ids: 1") {
+		t.Errorf("expected an unknown id to fail, got %v", err)
+	}
+}
+
+func TestExecuteCommandInBackgroundAndStop(t *testing.T) {
+	r := newCommandRegistry(t, time.Minute)
+	ctx := commandContext(nil)
+
+	start := time.Now()
+	out, _ := r.ExecuteWithTimeout(ctx, "execute_command", `{"command": "sleep 30", "background": true}`)
+	if !strings.HasPrefix(out, "Started in the background as job 1") || time.Since(start) > 5*time.Second {
+		t.Fatalf("expected the command to start in the background, got %q", out)
+	}
+
+	status, _ := r.Execute(ctx, "command_status", `{"id": 1}`)
+	if !strings.Contains(status, "Status: running") {
+		t.Errorf("expected the command to be running, got %q", status)
+	}
+	status, _ = r.Execute(ctx, "command_status", `{"id": 1, "stop": true}`)
+	if strings.Contains(status, "Status: running") {
+		t.Errorf("expected the command to be stopped, got %q", status)
+	}
+}
+
+func TestInterruptCommand(t *testing.T) {
+	r := newCommandRegistry(t, time.Minute)
+	if r.InterruptCommand() {
+		t.Fatal("expected nothing to interrupt")
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		for !r.InterruptCommand() {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	out, _ := r.ExecuteWithTimeout(commandContext(nil), "execute_command", `{"command": "echo waiting; sleep 30"}`)
+	if !strings.HasPrefix(out, "Command interrupted by user.") || !strings.Contains(out, "waiting") {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestCommandOutputKeepsHeadAndTail(t *testing.T) {
+	o := &commandOutput{}
+	o.Write([]byte("start\n"))
+	o.Write(bytes.Repeat([]byte("x"), 3*commandCaptureBytes))
+	o.Write([]byte("\nend\n"))
+
+	out, next := o.read(0)
+	if !strings.HasPrefix(out, "start\n") || !strings.HasSuffix(out, "\nend\n") || !strings.Contains(out, "bytes of output omitted") {
+		t.Errorf("expected the head and tail with a note, got %d bytes", len(out))
+	}
+	if next != int64(6+3*commandCaptureBytes+5) {
+		t.Errorf("unexpected offset %d", next)
+	}
+	if out, _ := o.read(next - 4); out != "end\n" {
+		t.Errorf("expected only the new output, got %q", out)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package tools
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// backgroundSignals move the foreground command to the background (Ctrl+Z).
+var backgroundSignals = []os.Signal{syscall.SIGTSTP}
+
+// prepareCommand runs the command in its own process group, so that Ctrl+C
+// and Ctrl+Z reach tgpt instead of the command, and stopping the command
+// also stops the processes it started.
+func prepareCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func terminateCommand(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+func killCommand(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package tools
+
+import (
+	"os"
+	"os/exec"
+)
+
+// backgroundSignals is empty: Windows has no Ctrl+Z signal, so commands only
+// move to the background when they outlive their timeout.
+var backgroundSignals []os.Signal
+
+func prepareCommand(cmd *exec.Cmd) {}
+
+func terminateCommand(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+func killCommand(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
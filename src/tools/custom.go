@@ -0,0 +1,258 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aandrew-me/tgpt/v2/src/bubbletea"
+)
+
+// CustomToolConfig declares a tool backed by a shell command. Arguments are
+// substituted into the command template as {{name}} (shell-quoted) and are
+// also passed to the process, either as TGPT_ARG_<NAME> environment variables
+// or as a JSON object on stdin.
+type CustomToolConfig struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+	Command     string         `json:"command"`
+	Confirm     bool           `json:"confirm,omitempty"`
+	ReadOnly    bool           `json:"readOnly,omitempty"`
+	Input       string         `json:"input,omitempty"`     // "env" (default) or "stdin"
+	Timeout     int            `json:"timeout,omitempty"`   // seconds, defaults to the --tool-timeout default
+	MaxOutput   int            `json:"maxOutput,omitempty"` // characters, defaults to 10000
+}
+
+type CustomToolsConfig struct {
+	Tools []CustomToolConfig `json:"tools"`
+}
+
+const defaultCustomToolMaxOutput = 10000
+
+var (
+	customToolNameRe    = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+	customPlaceholderRe = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_-]+)\s*\}\}`)
+	envNameUnsafeRe     = regexp.MustCompile(`[^A-Z0-9_]`)
+)
+
+// LoadCustomTools reads custom tool declarations. With an empty path it reads
+// ~/.config/tgpt/tools.json, and returns nil without error when that does not
+// exist. A tools.json in the current directory is not picked up on its own:
+// it may come with a cloned repository, and its commands would run as tools.
+func LoadCustomTools(path string) (*CustomToolsConfig, error) {
+	if path == "" {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			p := filepath.Join(homeDir, ".config", "tgpt", "tools.json")
+			if _, err := os.Stat(p); err == nil {
+				path = p
+			}
+		}
+	}
+
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tools config: %w", err)
+	}
+
+	var cfg CustomToolsConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse tools config JSON: %w", err)
+	}
+
+	for i, t := range cfg.Tools {
+		if !customToolNameRe.MatchString(t.Name) {
+			return nil, fmt.Errorf("tools config entry %d: invalid tool name %q", i, t.Name)
+		}
+		if strings.TrimSpace(t.Command) == "" {
+			return nil, fmt.Errorf("tool %q: command is required", t.Name)
+		}
+		if t.Input != "" && t.Input != "env" && t.Input != "stdin" {
+			return nil, fmt.Errorf("tool %q: input must be \"env\" or \"stdin\", got %q", t.Name, t.Input)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// RegisterCustomTools registers the tools declared in cfg. A custom tool whose
+// name is already taken is skipped with a warning.
+func (r *Registry) RegisterCustomTools(cfg *CustomToolsConfig) {
+	if cfg == nil {
+		return
+	}
+	for _, tc := range cfg.Tools {
+		if r.Has(tc.Name) {
+			fmt.Fprintf(os.Stderr, "Warning: custom tool %q conflicts with an existing tool and was skipped\n", tc.Name)
+			continue
+		}
+
+		params := tc.Parameters
+		if params == nil {
+			params = map[string]any{}
+		}
+		if _, ok := params["type"]; !ok {
+			params["type"] = "object"
+		}
+		if _, ok := params["properties"]; !ok {
+			params["properties"] = map[string]any{}
+		}
+
+		description := tc.Description
+		if description == "" {
+			description = "Run the " + tc.Name + " command"
+		}
+
+		meta := ToolMeta{Source: "custom", Confirm: tc.Confirm, ReadOnly: tc.ReadOnly}
+		if tc.Timeout > 0 {
+			meta.Timeout = time.Duration(tc.Timeout) * time.Second
+		}
+
+		toolCfg := tc
+		r.RegisterWithMeta(ToolSpec{
+			Type: "function",
+			Function: FunctionSpec{
+				Name:        tc.Name,
+				Description: description,
+				Parameters:  params,
+			},
+		}, meta, func(ctx context.Context, args map[string]any) (string, error) {
+			return runCustomTool(ctx, toolCfg, args)
+		})
+	}
+}
+
+func runCustomTool(ctx context.Context, tc CustomToolConfig, args map[string]any) (string, error) {
+	cmdStr := RenderCommandTemplate(tc.Command, args)
+
+	if tc.Confirm {
+		autoExec, _ := ctx.Value(AutoExecKey).(bool)
+		confirmed, _ := ctx.Value(ConfirmedKey).(bool)
+		if !autoExec && !confirmed {
+			c, err := confirmAction(fmt.Sprintf("\nRun tool `%s`: `%s` ?", tc.Name, cmdStr))
+			if err != nil {
+				if errors.Is(err, bubbletea.ErrCanceled) {
+					return "Tool call cancelled by user.", nil
+				}
+				return "", err
+			}
+			if !c {
+				return "Tool call cancelled by user.", nil
+			}
+		}
+	}
+
+	// The caller normally applies the timeout (see Registry.Timeout); one is
+	// still set here for calls made without a deadline.
+	runCtx, cancel := ctx, context.CancelFunc(func() {})
+	if _, ok := ctx.Deadline(); !ok {
+		timeout := time.Duration(tc.Timeout) * time.Second
+		if timeout <= 0 {
+			timeout = DefaultToolTimeout
+		}
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(runCtx, "cmd.exe", "/C", cmdStr)
+	} else {
+		cmd = exec.CommandContext(runCtx, "sh", "-c", cmdStr)
+	}
+
+	// Don't let background children holding the pipes outlive the timeout.
+	cmd.WaitDelay = 2 * time.Second
+
+	argsJSON, _ := json.Marshal(args)
+	cmd.Env = append(os.Environ(), "TGPT_TOOL_NAME="+tc.Name, "TGPT_TOOL_ARGS="+string(argsJSON))
+	if tc.Input == "stdin" {
+		cmd.Stdin = bytes.NewReader(argsJSON)
+	} else {
+		cmd.Env = append(cmd.Env, argEnv(args)...)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	maxOutput := tc.MaxOutput
+	if maxOutput <= 0 {
+		maxOutput = defaultCustomToolMaxOutput
+	}
+	out := truncateRunes(stdout.String(), maxOutput)
+
+	if runCtx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("tool %q timed out", tc.Name)
+	}
+	if err != nil {
+		return fmt.Sprintf("Command failed with error: %v\nOutput: %s\nStderr: %s", err, out, truncateRunes(stderr.String(), maxOutput)), nil
+	}
+	return out, nil
+}
+
+// RenderCommandTemplate replaces {{name}} placeholders with the shell-quoted
+// value of the matching argument. Missing arguments become empty strings.
+func RenderCommandTemplate(template string, args map[string]any) string {
+	return customPlaceholderRe.ReplaceAllStringFunc(template, func(m string) string {
+		key := customPlaceholderRe.FindStringSubmatch(m)[1]
+		return shellQuote(argString(args[key]))
+	})
+}
+
+func argEnv(args map[string]any) []string {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		name := envNameUnsafeRe.ReplaceAllString(strings.ToUpper(k), "_")
+		env = append(env, "TGPT_ARG_"+name+"="+argString(args[k]))
+	}
+	return env
+}
+
+func argString(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	default:
+		b, _ := json.Marshal(val)
+		return string(b)
+	}
+}
+
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) > max {
+		return string(runes[:max]) + "\n... [content truncated]"
+	}
+	return s
+}
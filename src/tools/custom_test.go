@@ -0,0 +1,140 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func writeToolsConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tools.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write tools config: %v", err)
+	}
+	return path
+}
+
+func TestLoadCustomToolsValidation(t *testing.T) {
+	cfg, err := LoadCustomTools(filepath.Join(t.TempDir(), "missing.json"))
+	if err == nil || cfg != nil {
+		t.Fatalf("expected error for missing explicit config, got %#v, %v", cfg, err)
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("tools.json", []byte(`{"tools": [{"name": "repo_tool", "command": "true"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := LoadCustomTools(""); err != nil || cfg != nil {
+		t.Fatalf("expected tools.json in the current directory not to be loaded, got %#v, %v", cfg, err)
+	}
+
+	path := writeToolsConfig(t, `{"tools": [{"name": "bad name", "command": "true"}]}`)
+	if _, err := LoadCustomTools(path); err == nil {
+		t.Fatal("expected error for invalid tool name")
+	}
+
+	path = writeToolsConfig(t, `{"tools": [{"name": "ok", "command": "true", "input": "file"}]}`)
+	if _, err := LoadCustomTools(path); err == nil {
+		t.Fatal("expected error for invalid input mode")
+	}
+}
+
+func TestRenderCommandTemplateQuotes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX quoting")
+	}
+	got := RenderCommandTemplate("grep {{pattern}} {{ path }} {{missing}}", map[string]any{
+		"pattern": "it's; rm -rf /",
+		"path":    "a b.txt",
+	})
+	want := `grep 'it'\''s; rm -rf /' 'a b.txt' ''`
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestCustomToolEnvAndStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	path := writeToolsConfig(t, `{"tools": [
+		{"name": "greet", "description": "Greets", "command": "echo hello $TGPT_ARG_WHO {{who}}",
+		 "parameters": {"type": "object", "properties": {"who": {"type": "string"}}}},
+		{"name": "echo_json", "command": "cat", "input": "stdin", "confirm": true}
+	]}`)
+	cfg, err := LoadCustomTools(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := NewRegistry()
+	r.RegisterCustomTools(cfg)
+
+	res, err := r.Execute(context.Background(), "greet", `{"who": "world"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.TrimSpace(res) != "hello world world" {
+		t.Fatalf("unexpected output %q", res)
+	}
+
+	ctx := context.WithValue(context.Background(), AutoExecKey, true)
+	res, err = r.Execute(ctx, "echo_json", `{"n": 3}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.TrimSpace(res) != `{"n":3}` {
+		t.Fatalf("expected args on stdin, got %q", res)
+	}
+
+	if meta, ok := r.Meta("echo_json"); !ok || meta.Source != "custom" || !meta.Confirm {
+		t.Fatalf("unexpected meta %#v", meta)
+	}
+	proceed, _, err := r.PreConfirm(ctx, "echo_json", `{"n": 3}`)
+	if !proceed || err != nil {
+		t.Fatalf("expected auto-exec to skip confirmation, got %v, %v", proceed, err)
+	}
+}
+
+func TestCustomToolTimeoutAndOutputCap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	path := writeToolsConfig(t, `{"tools": [
+		{"name": "slow", "command": "sleep 5", "timeout": 1},
+		{"name": "noisy", "command": "printf 'abcdefghij'", "maxOutput": 4}
+	]}`)
+	cfg, err := LoadCustomTools(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := NewRegistry()
+	r.RegisterCustomTools(cfg)
+
+	if _, err := r.Execute(context.Background(), "slow", `{}`); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+
+	res, err := r.Execute(context.Background(), "noisy", `{}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(res, "abcd\n") || !strings.Contains(res, "truncated") {
+		t.Fatalf("expected truncated output, got %q", res)
+	}
+}
+
+func TestCustomToolDoesNotOverrideBuiltin(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterBuiltinTools("read_file")
+	r.RegisterCustomTools(&CustomToolsConfig{Tools: []CustomToolConfig{{Name: "read_file", Command: "echo hijacked"}}})
+	if meta, ok := r.Meta("read_file"); ok {
+		t.Fatalf("expected builtin read_file to be kept, got meta %#v", meta)
+	}
+}
@@ -0,0 +1,453 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultGitLogCount = 20
+	maxGitLogCount     = 200
+	defaultBlameLines  = 200
+	maxGitOutputLines  = 1000
+)
+
+// runGit runs git with args in the workspace root (or the current directory)
+// and returns its stdout. Arguments are passed directly, without a shell.
+// The fsmonitor hook is disabled because it is a command the repository's
+// config can point anywhere.
+func (r *Registry) runGit(ctx context.Context, args ...string) (string, error) {
+	base := []string{"-c", "color.ui=never", "-c", "core.quotepath=off", "-c", "core.fsmonitor=false"}
+	cmd := exec.CommandContext(ctx, "git", append(base, args...)...)
+	if w := r.Workspace(); w != nil {
+		cmd.Dir = w.Root
+	}
+	cmd.Env = append(os.Environ(), "GIT_PAGER=cat", "GIT_TERMINAL_PROMPT=0")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s failed: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// gitPath checks path against the workspace and returns it relative to the
+// directory git runs in, which it may not leave.
+func (r *Registry) gitPath(path string) (string, error) {
+	resolved, err := r.resolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	base := ""
+	if w := r.Workspace(); w != nil {
+		base = w.Root
+	} else if base, err = os.Getwd(); err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(base, resolved)
+	}
+	rel, err := filepath.Rel(base, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside %s, where the git tools run", path, base)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// gitExcludes returns pathspecs that leave the files matching the workspace
+// deny list out of a git command. names is what git printed for the files
+// the command would show, NUL-separated and relative to the top of the
+// repository; with status set it is the output of git status --porcelain -z.
+func (r *Registry) gitExcludes(ctx context.Context, names string, status bool) ([]string, error) {
+	w := r.Workspace()
+	if w == nil {
+		return nil, nil
+	}
+	top, err := r.runGit(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top = strings.TrimSpace(top)
+
+	var excludes []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(names, "\x00") {
+		if status {
+			// Each entry is "XY path".
+			if len(name) < 4 {
+				continue
+			}
+			name = name[3:]
+		}
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		// Paths outside the workspace are matched relative to the
+		// repository instead.
+		rel, ok := w.relative(filepath.Join(top, filepath.FromSlash(name)))
+		if !ok {
+			rel = name
+		}
+		if _, denied := w.deniedBy(rel); denied {
+			excludes = append(excludes, ":(top,exclude,literal)"+name)
+		}
+	}
+	return excludes, nil
+}
+
+// gitRevision validates a revision argument, so that it cannot be read by
+// git as an option.
+func gitRevision(args map[string]any, key string) (string, error) {
+	rev, _ := args[key].(string)
+	rev = strings.TrimSpace(rev)
+	if strings.HasPrefix(rev, "-") {
+		return "", fmt.Errorf("%s must be a revision, not an option: %q", key, rev)
+	}
+	return rev, nil
+}
+
+func intArg(args map[string]any, key string) (int, bool) {
+	switch v := args[key].(type) {
+	case float64:
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// truncateLines keeps the first max lines of s.
+func truncateLines(s string, max int) string {
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) <= max {
+		return s
+	}
+	return strings.Join(lines[:max], "") + fmt.Sprintf("\n... [%d more lines truncated]", len(lines)-max)
+}
+
+func (r *Registry) registerGitTools(shouldRegister func(string) bool) {
+	if shouldRegister("git_status") {
+		r.Register(ToolSpec{
+			Type: "function",
+			Function: FunctionSpec{
+				Name:        "git_status",
+				Description: "Show the current branch, its upstream state and changed, staged and untracked files of the git repository",
+				Parameters: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"path": map[string]any{
+							"type":        "string",
+							"description": "Limit the status to this file or directory (optional)",
+						},
+					},
+				},
+			},
+		}, func(ctx context.Context, args map[string]any) (string, error) {
+			var paths []string
+			if p, _ := args["path"].(string); p != "" {
+				p, err := r.gitPath(p)
+				if err != nil {
+					return "", err
+				}
+				paths = append(paths, p)
+			}
+			names, err := r.runGit(ctx, append([]string{"status", "--porcelain", "-z", "--no-renames", "--untracked-files=all", "--"}, paths...)...)
+			if err != nil {
+				return "", err
+			}
+			excludes, err := r.gitExcludes(ctx, names, true)
+			if err != nil {
+				return "", err
+			}
+			gitArgs := append(append([]string{"status", "--short", "--branch", "--"}, paths...), excludes...)
+			out, err := r.runGit(ctx, gitArgs...)
+			if err != nil {
+				return "", err
+			}
+			return truncateLines(out, maxGitOutputLines), nil
+		})
+	}
+
+	if shouldRegister("git_diff") {
+		r.Register(ToolSpec{
+			Type: "function",
+			Function: FunctionSpec{
+				Name:        "git_diff",
+				Description: "Show changes in the git repository: unstaged changes by default, staged changes, or changes between revisions",
+				Parameters: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"staged": map[string]any{
+							"type":        "boolean",
+							"description": "Show staged changes instead of unstaged ones (defaults to false)",
+						},
+						"revision": map[string]any{
+							"type":        "string",
+							"description": "Compare against a revision or range, e.g. 'HEAD~3' or 'main...feature' (optional)",
+						},
+						"path": map[string]any{
+							"type":        "string",
+							"description": "Limit the diff to this file or directory (optional)",
+						},
+						"stat": map[string]any{
+							"type":        "boolean",
+							"description": "Only show a summary of changed files (defaults to false)",
+						},
+						"context_lines": map[string]any{
+							"type":        "integer",
+							"description": "Lines of context around each change (defaults to 3)",
+						},
+					},
+				},
+			},
+		}, func(ctx context.Context, args map[string]any) (string, error) {
+			rev, err := gitRevision(args, "revision")
+			if err != nil {
+				return "", err
+			}
+			var selection []string
+			if staged, _ := args["staged"].(bool); staged {
+				selection = append(selection, "--cached")
+			}
+			if rev != "" {
+				selection = append(selection, rev)
+			}
+			selection = append(selection, "--")
+			if p, _ := args["path"].(string); p != "" {
+				p, err := r.gitPath(p)
+				if err != nil {
+					return "", err
+				}
+				selection = append(selection, p)
+			}
+			names, err := r.runGit(ctx, append([]string{"diff", "--name-only", "--no-renames", "-z"}, selection...)...)
+			if err != nil {
+				return "", err
+			}
+			excludes, err := r.gitExcludes(ctx, names, false)
+			if err != nil {
+				return "", err
+			}
+
+			gitArgs := []string{"diff", "--no-ext-diff", "--no-textconv"}
+			if stat, _ := args["stat"].(bool); stat {
+				gitArgs = append(gitArgs, "--stat")
+			}
+			if n, ok := intArg(args, "context_lines"); ok && n >= 0 {
+				gitArgs = append(gitArgs, fmt.Sprintf("-U%d", n))
+			}
+			gitArgs = append(append(gitArgs, selection...), excludes...)
+			out, err := r.runGit(ctx, gitArgs...)
+			if err != nil {
+				return "", err
+			}
+			if strings.TrimSpace(out) == "" {
+				return "No changes.", nil
+			}
+			return truncateLines(out, maxGitOutputLines), nil
+		})
+	}
+
+	if shouldRegister("git_log") {
+		r.Register(ToolSpec{
+			Type: "function",
+			Function: FunctionSpec{
+				Name:        "git_log",
+				Description: "List commits of the git repository, newest first, as '<hash> <date> <author> <subject>'",
+				Parameters: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"max_count": map[string]any{
+							"type":        "integer",
+							"description": fmt.Sprintf("Maximum number of commits (defaults to %d, at most %d)", defaultGitLogCount, maxGitLogCount),
+						},
+						"revision": map[string]any{
+							"type":        "string",
+							"description": "Revision or range to list, e.g. 'main' or 'v1.0..HEAD' (defaults to HEAD)",
+						},
+						"path": map[string]any{
+							"type":        "string",
+							"description": "Only commits touching this file or directory (optional)",
+						},
+						"author": map[string]any{
+							"type":        "string",
+							"description": "Only commits by authors matching this pattern (optional)",
+						},
+						"since": map[string]any{
+							"type":        "string",
+							"description": "Only commits after this date, e.g. '2 weeks ago' or '2024-01-01' (optional)",
+						},
+						"grep": map[string]any{
+							"type":        "string",
+							"description": "Only commits whose message matches this pattern (optional)",
+						},
+					},
+				},
+			},
+		}, func(ctx context.Context, args map[string]any) (string, error) {
+			rev, err := gitRevision(args, "revision")
+			if err != nil {
+				return "", err
+			}
+			count := defaultGitLogCount
+			if n, ok := intArg(args, "max_count"); ok && n > 0 {
+				count = min(n, maxGitLogCount)
+			}
+			gitArgs := []string{"log", "--date=short", "--pretty=format:%h %ad %an %s", "-n", strconv.Itoa(count)}
+			for _, opt := range []string{"author", "since", "grep"} {
+				if v, _ := args[opt].(string); v != "" {
+					gitArgs = append(gitArgs, "--"+opt+"="+v)
+				}
+			}
+			if rev != "" {
+				gitArgs = append(gitArgs, rev)
+			}
+			gitArgs = append(gitArgs, "--")
+			if p, _ := args["path"].(string); p != "" {
+				p, err := r.gitPath(p)
+				if err != nil {
+					return "", err
+				}
+				gitArgs = append(gitArgs, p)
+			}
+			out, err := r.runGit(ctx, gitArgs...)
+			if err != nil {
+				return "", err
+			}
+			if strings.TrimSpace(out) == "" {
+				return "No commits found.", nil
+			}
+			return out, nil
+		})
+	}
+
+	if shouldRegister("git_blame") {
+		r.Register(ToolSpec{
+			Type: "function",
+			Function: FunctionSpec{
+				Name:        "git_blame",
+				Description: "Show which commit and author last changed each line of a file",
+				Parameters: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"path": map[string]any{
+							"type":        "string",
+							"description": "File to blame",
+						},
+						"start_line": map[string]any{
+							"type":        "integer",
+							"description": "First line to show (defaults to 1)",
+						},
+						"end_line": map[string]any{
+							"type":        "integer",
+							"description": fmt.Sprintf("Last line to show (defaults to %d lines after start_line)", defaultBlameLines-1),
+						},
+						"revision": map[string]any{
+							"type":        "string",
+							"description": "Blame the file as of this revision (defaults to the working tree)",
+						},
+					},
+					"required": []string{"path"},
+				},
+			},
+		}, func(ctx context.Context, args map[string]any) (string, error) {
+			p, _ := args["path"].(string)
+			if p == "" {
+				return "", fmt.Errorf("path parameter is required")
+			}
+			p, err := r.gitPath(p)
+			if err != nil {
+				return "", err
+			}
+			rev, err := gitRevision(args, "revision")
+			if err != nil {
+				return "", err
+			}
+			start := 1
+			if n, ok := intArg(args, "start_line"); ok && n > 0 {
+				start = n
+			}
+			end := start + defaultBlameLines - 1
+			if n, ok := intArg(args, "end_line"); ok && n >= start {
+				end = min(n, start+maxGitOutputLines-1)
+			}
+
+			gitArgs := []string{"blame", "--date=short", "-L", fmt.Sprintf("%d,%d", start, end)}
+			if rev != "" {
+				gitArgs = append(gitArgs, rev)
+			}
+			gitArgs = append(gitArgs, "--", p)
+			// git stops at the end of the file if end is past it.
+			return r.runGit(ctx, gitArgs...)
+		})
+	}
+
+	if shouldRegister("git_show") {
+		r.Register(ToolSpec{
+			Type: "function",
+			Function: FunctionSpec{
+				Name:        "git_show",
+				Description: "Show a commit (message, changed files and diff), or the content of a file at a revision when path is given",
+				Parameters: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"revision": map[string]any{
+							"type":        "string",
+							"description": "Commit, branch or tag, e.g. 'HEAD~1' or 'v2.0'",
+						},
+						"path": map[string]any{
+							"type":        "string",
+							"description": "Show this file as it was at the revision instead of the commit (optional)",
+						},
+					},
+					"required": []string{"revision"},
+				},
+			},
+		}, func(ctx context.Context, args map[string]any) (string, error) {
+			rev, err := gitRevision(args, "revision")
+			if err != nil {
+				return "", err
+			}
+			if rev == "" {
+				return "", fmt.Errorf("revision parameter is required")
+			}
+
+			var gitArgs []string
+			if p, _ := args["path"].(string); p != "" {
+				p, err := r.gitPath(p)
+				if err != nil {
+					return "", err
+				}
+				gitArgs = []string{"show", "--no-textconv", rev + ":./" + p}
+			} else {
+				names, err := r.runGit(ctx, "show", "--name-only", "--no-renames", "--format=", "-z", rev, "--")
+				if err != nil {
+					return "", err
+				}
+				excludes, err := r.gitExcludes(ctx, names, false)
+				if err != nil {
+					return "", err
+				}
+				gitArgs = append([]string{"show", "--stat", "--patch", "--no-ext-diff", "--no-textconv", "--date=short", rev, "--"}, excludes...)
+			}
+			out, err := r.runGit(ctx, gitArgs...)
+			if err != nil {
+				return "", err
+			}
+			return truncateLines(out, maxGitOutputLines), nil
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newGitRegistry(t *testing.T) (*Registry, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) { runTestGit(t, dir, args...) }
+	git("init", "-q", "-b", "main")
+	writeTestFile(t, dir, "notes.txt", "first line\nsecond line\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "Add notes")
+	writeTestFile(t, dir, "notes.txt", "first line\nsecond line, revised\n")
+	git("commit", "-q", "-am", "Revise notes")
+	writeTestFile(t, dir, "notes.txt", "first line\nsecond line, revised\nthird line\n")
+
+	policy, err := NewWorkspacePolicy(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	r.SetWorkspace(policy)
+	r.RegisterBuiltinTools("git_status", "git_diff", "git_log", "git_blame", "git_show")
+	return r, dir
+}
+
+func runTestGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(cmd.Environ(), "GIT_AUTHOR_NAME=Ada", "GIT_AUTHOR_EMAIL=ada@example.com",
+		"GIT_COMMITTER_NAME=Ada", "GIT_COMMITTER_EMAIL=ada@example.com", "GIT_CONFIG_GLOBAL=/dev/null")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func TestGitTools(t *testing.T) {
+	r, _ := newGitRegistry(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		tool, args string
+		want       []string
+	}{
+		{"git_status", `{}`, []string{"## main", " M notes.txt"}},
+		{"git_diff", `{}`, []string{"+third line"}},
+		{"git_diff", `{"staged": true}`, []string{"No changes."}},
+		{"git_diff", `{"revision": "HEAD~1", "path": "notes.txt", "stat": true}`, []string{"notes.txt | 3"}},
+		{"git_log", `{"max_count": 1}`, []string{"Ada Revise notes"}},
+		{"git_log", `{"grep": "Add"}`, []string{"Ada Add notes"}},
+		{"git_blame", `{"path": "notes.txt", "start_line": 2, "end_line": 2}`, []string{"second line, revised"}},
+		{"git_show", `{"revision": "HEAD~1", "path": "notes.txt"}`, []string{"first line\nsecond line\n"}},
+		{"git_show", `{"revision": "HEAD"}`, []string{"Revise notes", "notes.txt | 2", "+second line, revised"}},
+	}
+	for _, tt := range tests {
+		out, err := r.Execute(ctx, tt.tool, tt.args)
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %v", tt.tool, tt.args, err)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s %s: expected %q in output:\n%s", tt.tool, tt.args, want, out)
+			}
+		}
+	}
+}
+
+func TestGitLogMaxCount(t *testing.T) {
+	r, _ := newGitRegistry(t)
+	out, err := r.Execute(context.Background(), "git_log", `{"max_count": 1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "Add notes") {
+		t.Errorf("expected only the latest commit, got:\n%s", out)
+	}
+}
+
+func TestGitToolsRejectOptionsAndEscapes(t *testing.T) {
+	r, _ := newGitRegistry(t)
+	ctx := context.Background()
+
+	if _, err := r.Execute(ctx, "git_diff", `{"revision": "--output=/tmp/pwned"}`); err == nil || !strings.Contains(err.Error(), "not an option") {
+		t.Errorf("expected option-like revision to be rejected, got %v", err)
+	}
+	if _, err := r.Execute(ctx, "git_show", `{"revision": "HEAD", "path": "../outside.txt"}`); err == nil || !strings.Contains(err.Error(), "outside the workspace") {
+		t.Errorf("expected path outside the workspace to be rejected, got %v", err)
+	}
+}
+
+func TestGitToolsIgnoreRepoCommands(t *testing.T) {
+	r, dir := newGitRegistry(t)
+	ctx := context.Background()
+	marker := filepath.Join(t.TempDir(), "ran")
+	runTestGit(t, dir, "config", "core.fsmonitor", "touch "+marker+"; true")
+	runTestGit(t, dir, "config", "diff.notes.textconv", "touch "+marker+"; cat")
+	writeTestFile(t, dir, ".git/info/attributes", "*.txt diff=notes\n")
+
+	for _, call := range [][2]string{
+		{"git_status", `{}`},
+		{"git_diff", `{}`},
+		{"git_show", `{"revision": "HEAD"}`},
+		{"git_show", `{"revision": "HEAD", "path": "notes.txt"}`},
+	} {
+		if _, err := r.Execute(ctx, call[0], call[1]); err != nil {
+			t.Fatalf("%s %s: %v", call[0], call[1], err)
+		}
+		if _, err := os.Stat(marker); err == nil {
+			t.Fatalf("%s %s ran a command from the repository config", call[0], call[1])
+		}
+	}
+}
+
+func TestGitToolsAreReadOnlyWithoutConfirmation(t *testing.T) {
+	r, _ := newGitRegistry(t)
+	for _, name := range []string{"git_status", "git_diff", "git_log", "git_blame", "git_show"} {
+		if !r.IsReadOnly(name) {
+			t.Errorf("expected %s to be read-only", name)
+		}
+		d, err := r.Confirm(context.Background(), name, `{}`)
+		if err != nil || !d.Proceed || d.Outcome != OutcomeNotRequired {
+			t.Errorf("expected %s to need no confirmation, got %+v (%v)", name, d, err)
+		}
+	}
+	if _, ok := ParseToolList("git_log,git_show"); !ok {
+		t.Error("expected git tools to be accepted by ParseToolList")
+	}
+}
+
+func TestGitToolsHideDeniedFiles(t *testing.T) {
+	r, dir := newGitRegistry(t)
+	ctx := context.Background()
+	writeTestFile(t, dir, ".env", "API_KEY=staged-secret\n")
+	writeTestFile(t, dir, ".env.example", "API_KEY=\n")
+	runTestGit(t, dir, "add", ".env", ".env.example", "notes.txt")
+
+	for _, tc := range []struct{ tool, args string }{
+		{"git_status", `{}`},
+		{"git_diff", `{"staged": true}`},
+		{"git_diff", `{"staged": true, "stat": true}`},
+	} {
+		out, err := r.Execute(ctx, tc.tool, tc.args)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tc.tool, tc.args, err)
+		}
+		if strings.Contains(out, "staged-secret") || strings.Contains(out, ".env\n") || strings.Contains(out, ".env ") {
+			t.Errorf("%s %s: expected .env to be left out:\n%s", tc.tool, tc.args, out)
+		}
+		if !strings.Contains(out, "notes.txt") || !strings.Contains(out, ".env.example") {
+			t.Errorf("%s %s: expected the other files to be shown:\n%s", tc.tool, tc.args, out)
+		}
+	}
+
+	runTestGit(t, dir, "commit", "-q", "-m", "Add config")
+	out, err := r.Execute(ctx, "git_show", `{"revision": "HEAD"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "staged-secret") || !strings.Contains(out, "+third line") {
+		t.Errorf("expected git_show to leave out .env:\n%s", out)
+	}
+	if _, err := r.Execute(ctx, "git_show", `{"revision": "HEAD", "path": ".env"}`); err == nil {
+		t.Error("expected git_show to refuse a denied path")
+	}
+}
+
+func TestGitPathStaysInsideRoot(t *testing.T) {
+	t.Chdir(t.TempDir())
+	r := NewRegistry()
+	if _, err := r.gitPath("../../etc/passwd"); err == nil {
+		t.Error("expected a path outside the current directory to be rejected")
+	}
+	if p, err := r.gitPath("sub/../file.txt"); err != nil || p != "file.txt" {
+		t.Errorf("expected a clean relative path, got %q (%v)", p, err)
+	}
+}
@@ -0,0 +1,376 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aandrew-me/tgpt/v2/src/bubbletea"
+	"github.com/aandrew-me/tgpt/v2/src/client"
+	http "github.com/bogdanfinn/fhttp"
+)
+
+const (
+	maxHTTPResponseBytes = 5 << 20
+	httpBodyPreviewChars = 500
+)
+
+// httpSafeMethods are sent without asking the user first.
+var httpSafeMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+}
+
+var httpMethodRe = regexp.MustCompile(`^[A-Z]+$`)
+
+// httpCall is a validated http_request call.
+type httpCall struct {
+	method         string
+	url            *url.URL
+	headers        map[string]string
+	body           []byte
+	format         string // "auto", "json", "text" or "markdown"
+	includeHeaders bool
+}
+
+// SetHTTPAllowList sets the hosts http_request may reach. Patterns are globs
+// matched against the host name, or against host:port when the pattern has a
+// port: "api.example.com", "*.corp.example.com" or "localhost:8080". "*"
+// allows every host. With no patterns, http_request refuses all requests.
+func (r *Registry) SetHTTPAllowList(patterns []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.httpAllow = patterns
+}
+
+// checkHTTPHost fails unless the allow-list permits u's host.
+func (r *Registry) checkHTTPHost(u *url.URL) error {
+	r.mu.RLock()
+	allow := r.httpAllow
+	r.mu.RUnlock()
+	if len(allow) == 0 {
+		return fmt.Errorf("http_request has no allowed domains: set TGPT_HTTP_ALLOW to a comma-separated list such as api.example.com,*.corp.example.com")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	hostPort := host
+	if port := u.Port(); port != "" {
+		hostPort += ":" + port
+	}
+	for _, pattern := range allow {
+		pattern = strings.ToLower(pattern)
+		subject := host
+		if strings.Contains(pattern, ":") {
+			subject = hostPort
+		}
+		if globMatch(pattern, subject) {
+			return nil
+		}
+	}
+	return fmt.Errorf("domain %q is not in the http_request allow-list (TGPT_HTTP_ALLOW)", host)
+}
+
+// parseHTTPCall validates the arguments of an http_request call and checks
+// the URL against the allow-list.
+func (r *Registry) parseHTTPCall(args map[string]any) (*httpCall, error) {
+	call := &httpCall{method: "GET", format: "auto", headers: make(map[string]string)}
+
+	if m, _ := args["method"].(string); m != "" {
+		call.method = strings.ToUpper(strings.TrimSpace(m))
+		if !httpMethodRe.MatchString(call.method) {
+			return nil, fmt.Errorf("invalid HTTP method %q", m)
+		}
+	}
+
+	rawURL, _ := args["url"].(string)
+	if rawURL == "" {
+		return nil, fmt.Errorf("url parameter is required")
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q: only http and https are allowed", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid url %q: missing host", rawURL)
+	}
+	if err := r.checkHTTPHost(u); err != nil {
+		return nil, err
+	}
+
+	if query, ok := args["query"].(map[string]any); ok && len(query) > 0 {
+		q := u.Query()
+		for k, v := range query {
+			if list, ok := v.([]any); ok {
+				for _, item := range list {
+					q.Add(k, httpQueryValue(item))
+				}
+				continue
+			}
+			q.Add(k, httpQueryValue(v))
+		}
+		u.RawQuery = q.Encode()
+	}
+	call.url = u
+
+	if headers, ok := args["headers"].(map[string]any); ok {
+		for k, v := range headers {
+			call.headers[http.CanonicalHeaderKey(k)] = httpQueryValue(v)
+		}
+	}
+
+	body, hasBody := args["body"].(string)
+	jsonBody, hasJSON := args["json"]
+	if hasJSON && jsonBody != nil {
+		if hasBody && body != "" {
+			return nil, fmt.Errorf("use either body or json, not both")
+		}
+		data, err := json.Marshal(jsonBody)
+		if err != nil {
+			return nil, fmt.Errorf("invalid json body: %w", err)
+		}
+		call.body = data
+		if _, ok := call.headers["Content-Type"]; !ok {
+			call.headers["Content-Type"] = "application/json"
+		}
+	} else if body != "" {
+		call.body = []byte(body)
+	}
+
+	if f, _ := args["response_format"].(string); f != "" {
+		switch f {
+		case "auto", "json", "text", "markdown":
+			call.format = f
+		default:
+			return nil, fmt.Errorf("invalid response_format %q: use auto, json, text or markdown", f)
+		}
+	}
+	call.includeHeaders, _ = args["include_headers"].(bool)
+	return call, nil
+}
+
+func httpQueryValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// httpConfirmPrompt shows the request line and the start of the body.
+func httpConfirmPrompt(call *httpCall) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n%s %s\n", call.method, call.url)
+	if len(call.body) > 0 {
+		preview := []rune(string(call.body))
+		if len(preview) > httpBodyPreviewChars {
+			preview = append(preview[:httpBodyPreviewChars], []rune("\n... [body truncated]")...)
+		}
+		b.WriteString(string(preview))
+		b.WriteString("\n")
+	}
+	b.WriteString("Send this HTTP request?")
+	return b.String()
+}
+
+// formatHTTPResponse renders the status line, selected headers and body.
+func formatHTTPResponse(call *httpCall, res *http.Response, data []byte, truncated bool) string {
+	var b strings.Builder
+	status := res.Status
+	if status == "" {
+		status = strconv.Itoa(res.StatusCode)
+	}
+	fmt.Fprintf(&b, "HTTP %s\n", status)
+
+	if call.includeHeaders {
+		keys := make([]string, 0, len(res.Header))
+		for k := range res.Header {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s: %s\n", k, strings.Join(res.Header[k], ", "))
+		}
+	} else {
+		for _, k := range []string{"Content-Type", "Location"} {
+			if v := res.Header.Get(k); v != "" {
+				fmt.Fprintf(&b, "%s: %s\n", k, v)
+			}
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString(formatHTTPBody(call, res.Header.Get("Content-Type"), data))
+	if truncated {
+		fmt.Fprintf(&b, "\n... [response truncated at %d bytes]", maxHTTPResponseBytes)
+	}
+	return b.String()
+}
+
+func formatHTTPBody(call *httpCall, contentType string, data []byte) string {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return "(empty body)"
+	}
+	if !utf8.Valid(data) {
+		return fmt.Sprintf("(binary body, %d bytes)", len(data))
+	}
+
+	format := call.format
+	if format == "auto" {
+		ct := strings.ToLower(contentType)
+		switch {
+		case strings.Contains(ct, "json"):
+			format = "json"
+		case strings.Contains(ct, "html"):
+			format = "markdown"
+		default:
+			format = "text"
+		}
+	}
+
+	switch format {
+	case "json":
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, data, "", "  "); err != nil {
+			return "(response is not valid JSON)\n" + string(data)
+		}
+		return pretty.String()
+	case "markdown":
+		markdown, err := htmlToMarkdown(string(data), call.url.String())
+		if err != nil {
+			return string(data)
+		}
+		return markdown
+	default:
+		return string(data)
+	}
+}
+
+func (r *Registry) registerHTTPTool(shouldRegister func(string) bool) {
+	if !shouldRegister("http_request") {
+		return
+	}
+	r.Register(ToolSpec{
+		Type: "function",
+		Function: FunctionSpec{
+			Name:        "http_request",
+			Description: "Send an HTTP request, e.g. to a JSON API, and return the status, main headers and response body. Only domains allowed by the user can be reached, and methods other than GET, HEAD and OPTIONS need the user's confirmation. Redirects are not followed.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"method": map[string]any{
+						"type":        "string",
+						"description": "HTTP method such as GET, POST, PUT, PATCH or DELETE (defaults to GET)",
+					},
+					"url": map[string]any{
+						"type":        "string",
+						"description": "The URL to request",
+					},
+					"headers": map[string]any{
+						"type":                 "object",
+						"description":          "Request headers, e.g. {\"Accept\": \"application/json\"} (optional)",
+						"additionalProperties": map[string]any{"type": "string"},
+					},
+					"query": map[string]any{
+						"type":        "object",
+						"description": "Query parameters added to the URL; array values repeat the parameter (optional)",
+					},
+					"json": map[string]any{
+						"description": "Request body to send as JSON, with Content-Type application/json (optional)",
+					},
+					"body": map[string]any{
+						"type":        "string",
+						"description": "Raw request body, used instead of json; set Content-Type in headers (optional)",
+					},
+					"response_format": map[string]any{
+						"type":        "string",
+						"enum":        []string{"auto", "json", "text", "markdown"},
+						"description": "How to return the body: pretty-printed JSON, raw text, or HTML converted to Markdown (defaults to auto, chosen from the Content-Type)",
+					},
+					"include_headers": map[string]any{
+						"type":        "boolean",
+						"description": "Return all response headers instead of only Content-Type and Location (defaults to false)",
+					},
+				},
+				"required": []string{"url"},
+			},
+		},
+	}, func(ctx context.Context, args map[string]any) (string, error) {
+		call, err := r.parseHTTPCall(args)
+		if err != nil {
+			return "", err
+		}
+
+		autoExec, _ := ctx.Value(AutoExecKey).(bool)
+		confirmed, _ := ctx.Value(ConfirmedKey).(bool)
+		if !httpSafeMethods[call.method] && !autoExec && !confirmed {
+			c, err := confirmAction(httpConfirmPrompt(call))
+			if err != nil {
+				if errors.Is(err, bubbletea.ErrCanceled) {
+					return "HTTP request cancelled by user.", nil
+				}
+				return "", err
+			}
+			if !c {
+				return "HTTP request cancelled by user.", nil
+			}
+		}
+
+		httpClient, err := client.NewClient()
+		if err != nil {
+			return "", fmt.Errorf("failed to create HTTP client: %w", err)
+		}
+
+		var body io.Reader
+		if len(call.body) > 0 {
+			body = bytes.NewReader(call.body)
+		}
+		req, err := http.NewRequestWithContext(ctx, call.method, call.url.String(), body)
+		if err != nil {
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+		for k, v := range call.headers {
+			req.Header.Set(k, v)
+		}
+		if req.Header.Get("User-Agent") == "" {
+			req.Header.Set("User-Agent", "tgpt")
+		}
+
+		res, err := httpClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("request failed: %w", err)
+		}
+		defer res.Body.Close()
+
+		data, err := io.ReadAll(io.LimitReader(res.Body, maxHTTPResponseBytes+1))
+		if err != nil {
+			return "", fmt.Errorf("failed to read response body: %w", err)
+		}
+		truncated := len(data) > maxHTTPResponseBytes
+		if truncated {
+			data = data[:maxHTTPResponseBytes]
+		}
+		// Error statuses are returned as output: API error bodies usually
+		// explain what was wrong with the request.
+		return formatHTTPResponse(call, res, data, truncated), nil
+	})
+}
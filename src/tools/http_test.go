@@ -0,0 +1,144 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newHTTPRegistry(t *testing.T, allow ...string) (*Registry, *httptest.Server) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/echo":
+			body, _ := io.ReadAll(req.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Request-Id", "42")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"method":       req.Method,
+				"query":        req.URL.Query(),
+				"token":        req.Header.Get("Authorization"),
+				"content_type": req.Header.Get("Content-Type"),
+				"body":         string(body),
+			})
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = io.WriteString(w, "<html><body><nav>menu</nav><h1>Title</h1><p>Hello</p></body></html>")
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"error":"no such item"}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	r := NewRegistry()
+	r.SetHTTPAllowList(allow)
+	r.RegisterBuiltinTools("http_request")
+	return r, server
+}
+
+func TestHTTPRequestJSONAPI(t *testing.T) {
+	r, server := newHTTPRegistry(t, "127.0.0.1")
+	args, _ := json.Marshal(map[string]any{
+		"method":  "post",
+		"url":     server.URL + "/echo?a=1",
+		"headers": map[string]string{"authorization": "Bearer secret"},
+		"query":   map[string]any{"page": 2, "tag": []string{"x", "y"}},
+		"json":    map[string]any{"name": "widget"},
+	})
+	ctx := context.WithValue(context.Background(), AutoExecKey, true)
+	out, err := r.Execute(ctx, "http_request", string(args))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"HTTP 200 OK\nContent-Type: application/json\n\n{",
+		`"method": "POST"`,
+		`"token": "Bearer secret"`,
+		`"content_type": "application/json"`,
+		`"body": "{\"name\":\"widget\"}"`,
+		`"a": [`, `"page": [`, `"y"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "X-Request-Id") {
+		t.Errorf("expected only the main headers by default:\n%s", out)
+	}
+}
+
+func TestHTTPRequestResponseFormats(t *testing.T) {
+	r, server := newHTTPRegistry(t, "127.0.0.1:*")
+	ctx := context.Background()
+
+	out, err := r.Execute(ctx, "http_request", `{"url": "`+server.URL+`/page"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "# Title") || strings.Contains(out, "menu") {
+		t.Errorf("expected HTML converted to Markdown:\n%s", out)
+	}
+
+	out, err = r.Execute(ctx, "http_request", `{"url": "`+server.URL+`/missing", "response_format": "text", "include_headers": true}`)
+	if err != nil {
+		t.Fatalf("expected error statuses to be returned as output, got %v", err)
+	}
+	if !strings.HasPrefix(out, "HTTP 404 Not Found\n") || !strings.Contains(out, "\nDate: ") || !strings.HasSuffix(out, `{"error":"no such item"}`) {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestHTTPRequestAllowList(t *testing.T) {
+	ctx := context.Background()
+
+	r, server := newHTTPRegistry(t)
+	if _, err := r.Execute(ctx, "http_request", `{"url": "`+server.URL+`/echo"}`); err == nil || !strings.Contains(err.Error(), "TGPT_HTTP_ALLOW") {
+		t.Errorf("expected requests to be refused without an allow-list, got %v", err)
+	}
+
+	r.SetHTTPAllowList([]string{"*.example.com", "localhost:1"})
+	for _, u := range []string{server.URL + "/echo", "https://example.com.evil.net/", "http://localhost:2/"} {
+		if _, err := r.Execute(ctx, "http_request", `{"url": "`+u+`"}`); err == nil || !strings.Contains(err.Error(), "not in the http_request allow-list") {
+			t.Errorf("expected %s to be refused, got %v", u, err)
+		}
+	}
+	if _, err := r.Execute(ctx, "http_request", `{"url": "file:///etc/passwd"}`); err == nil || !strings.Contains(err.Error(), "unsupported URL scheme") {
+		t.Errorf("expected file URL to be refused, got %v", err)
+	}
+}
+
+func TestHTTPRequestConfirmation(t *testing.T) {
+	r, _ := newHTTPRegistry(t, "api.example.com")
+
+	prompt, _ := r.confirmPrompt("http_request", map[string]any{"url": "https://api.example.com/items"}, "")
+	if prompt != "" {
+		t.Errorf("expected GET to need no confirmation, got %q", prompt)
+	}
+
+	args := map[string]any{"method": "DELETE", "url": "https://api.example.com/items/7", "body": "reason=old"}
+	prompt, cancel := r.confirmPrompt("http_request", args, "")
+	if prompt != "\nDELETE https://api.example.com/items/7\nreason=old\nSend this HTTP request?" {
+		t.Errorf("unexpected prompt %q", prompt)
+	}
+	if cancel != "HTTP request cancelled by user." {
+		t.Errorf("unexpected cancel message %q", cancel)
+	}
+
+	subject := r.permissionSubject("http_request", args, "")
+	if subject != "DELETE https://api.example.com/items/7" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	if got := SuggestPattern("http_request", subject); got != "DELETE https://api.example.com/*" {
+		t.Errorf("unexpected suggested pattern %q", got)
+	}
+
+	if prompt, _ := r.confirmPrompt("http_request", map[string]any{"method": "POST", "url": "https://other.example.org/"}, ""); prompt != "" {
+		t.Errorf("expected no prompt for a request the allow-list refuses, got %q", prompt)
+	}
+}
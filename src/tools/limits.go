@@ -0,0 +1,147 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultToolTimeout bounds a single tool call unless overridden.
+	DefaultToolTimeout = 60 * time.Second
+	// DefaultOutputTokens is the default tool output budget per call.
+	DefaultOutputTokens = 3000
+
+	// charsPerToken is the rough estimate used to turn the token budget
+	// into a character count without a tokenizer.
+	charsPerToken = 4
+)
+
+// ToolLimits bounds how long tool calls may run and how much output they may
+// return to the model.
+type ToolLimits struct {
+	Timeout      time.Duration            // default timeout per call
+	Timeouts     map[string]time.Duration // per-tool overrides
+	OutputTokens int                      // output budget per call, 0 for the default, negative for none
+}
+
+// ParseToolTimeouts parses a --tool-timeout value: a comma-separated list of
+// durations, each either bare ("120", "2m") to set the default or prefixed
+// with a tool name ("execute_command=10m"). Bare numbers are seconds.
+func ParseToolTimeouts(s string) (time.Duration, map[string]time.Duration, error) {
+	var def time.Duration
+	overrides := make(map[string]time.Duration)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, hasName := strings.Cut(part, "=")
+		if !hasName {
+			value = name
+		}
+		d, err := parseTimeout(strings.TrimSpace(value))
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid tool timeout %q: %w", part, err)
+		}
+		if hasName {
+			overrides[strings.TrimSpace(name)] = d
+		} else {
+			def = d
+		}
+	}
+	return def, overrides, nil
+}
+
+func parseTimeout(s string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(s); err == nil {
+		if secs <= 0 {
+			return 0, fmt.Errorf("must be positive")
+		}
+		return time.Duration(secs) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
+
+// SetLimits replaces the timeouts and output budget used for tool calls.
+func (r *Registry) SetLimits(l ToolLimits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits = l
+}
+
+// Timeout returns how long a call to name may run: a per-tool override, then
+// the timeout the tool was declared with, then the default.
+func (r *Registry) Timeout(name string) time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if d, ok := r.limits.Timeouts[name]; ok && d > 0 {
+		return d
+	}
+	if meta, ok := r.meta[name]; ok && meta.Timeout > 0 {
+		return meta.Timeout
+	}
+	if r.limits.Timeout > 0 {
+		return r.limits.Timeout
+	}
+	return DefaultToolTimeout
+}
+
+// ExecuteWithTimeout runs a call like Execute under the timeout returned by
+// Timeout. A call that runs out of time reports the limit in its error.
+func (r *Registry) ExecuteWithTimeout(ctx context.Context, name string, argsJSON string) (string, error) {
+	timeout := r.Timeout(name)
+	if name == "execute_command" {
+		// Commands that outlive the timeout are moved to the background
+		// rather than killed.
+		return r.Execute(context.WithValue(ctx, commandTimeoutKey, timeout), name, argsJSON)
+	}
+	execCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	out, err := r.Execute(execCtx, name, argsJSON)
+	if execCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return out, fmt.Errorf("tool %q timed out after %s (raise it with --tool-timeout %s=<duration>)", name, timeout, name)
+	}
+	return out, err
+}
+
+// outputBudget returns the per-call output limit in characters, or 0 if
+// output is not limited.
+func (r *Registry) outputBudget() int {
+	r.mu.RLock()
+	tokens := r.limits.OutputTokens
+	r.mu.RUnlock()
+	if tokens < 0 {
+		return 0
+	}
+	if tokens == 0 {
+		tokens = DefaultOutputTokens
+	}
+	return tokens * charsPerToken
+}
+
+// limitOutput shortens output that exceeds the budget, keeping its beginning
+// and end, which for most tools hold the headers and the final status or
+// error, and noting how much was left out.
+func limitOutput(out string, budget int) string {
+	runes := []rune(out)
+	if budget <= 0 || len(runes) <= budget {
+		return out
+	}
+	head := budget * 3 / 4
+	tail := budget - head
+	omitted := runes[head : len(runes)-tail]
+	lines := strings.Count(string(omitted), "\n")
+	return fmt.Sprintf("%s\n\n... [%d characters (%d lines) omitted: output exceeded the tool output budget of %d tokens] ...\n\n%s",
+		string(runes[:head]), len(omitted), lines, budget/charsPerToken, string(runes[len(runes)-tail:]))
+}
@@ -0,0 +1,116 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseToolTimeouts(t *testing.T) {
+	def, overrides, err := ParseToolTimeouts("90, execute_command=10m,web_fetch=30s")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if def != 90*time.Second {
+		t.Errorf("expected default 90s, got %s", def)
+	}
+	if overrides["execute_command"] != 10*time.Minute || overrides["web_fetch"] != 30*time.Second {
+		t.Errorf("unexpected overrides: %v", overrides)
+	}
+
+	for _, bad := range []string{"soon", "execute_command=0", "-5"} {
+		if _, _, err := ParseToolTimeouts(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRegistryTimeoutPrecedence(t *testing.T) {
+	r := NewRegistry()
+	spec := func(name string) ToolSpec { return ToolSpec{Type: "function", Function: FunctionSpec{Name: name}} }
+	noop := func(ctx context.Context, args map[string]any) (string, error) { return "", nil }
+	r.Register(spec("plain"), noop)
+	r.RegisterWithMeta(spec("declared"), ToolMeta{Source: "custom", Timeout: 5 * time.Minute}, noop)
+
+	if got := r.Timeout("plain"); got != DefaultToolTimeout {
+		t.Errorf("expected default timeout, got %s", got)
+	}
+	if got := r.Timeout("declared"); got != 5*time.Minute {
+		t.Errorf("expected declared timeout, got %s", got)
+	}
+
+	r.SetLimits(ToolLimits{Timeout: 2 * time.Minute, Timeouts: map[string]time.Duration{"declared": time.Hour}})
+	if got := r.Timeout("plain"); got != 2*time.Minute {
+		t.Errorf("expected configured default, got %s", got)
+	}
+	if got := r.Timeout("declared"); got != time.Hour {
+		t.Errorf("expected override to win over declared timeout, got %s", got)
+	}
+}
+
+func TestExecuteWithTimeoutReportsLimit(t *testing.T) {
+	r := NewRegistry()
+	r.Register(ToolSpec{Type: "function", Function: FunctionSpec{Name: "slow"}}, func(ctx context.Context, args map[string]any) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	r.SetLimits(ToolLimits{Timeouts: map[string]time.Duration{"slow": 50 * time.Millisecond}})
+
+	_, err := r.ExecuteWithTimeout(context.Background(), "slow", "{}")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 50ms") || !strings.Contains(err.Error(), "--tool-timeout slow=") {
+		t.Fatalf("expected a timeout error naming the limit, got %v", err)
+	}
+}
+
+func TestExecuteAppliesOutputBudget(t *testing.T) {
+	r := NewRegistry()
+	long := strings.Repeat("line of output\n", 100)
+	r.Register(ToolSpec{Type: "function", Function: FunctionSpec{Name: "chatty"}}, func(ctx context.Context, args map[string]any) (string, error) {
+		return "HEAD\n" + long + "TAIL", nil
+	})
+
+	out, _ := r.Execute(context.Background(), "chatty", "{}")
+	if out != "HEAD\n"+long+"TAIL" {
+		t.Fatalf("expected output within the default budget to be unchanged")
+	}
+
+	r.SetLimits(ToolLimits{OutputTokens: 50})
+	out, _ = r.Execute(context.Background(), "chatty", "{}")
+	if !strings.HasPrefix(out, "HEAD\n") || !strings.HasSuffix(out, "TAIL") {
+		t.Errorf("expected beginning and end to be kept, got %q", out)
+	}
+	if !strings.Contains(out, "omitted: output exceeded the tool output budget of 50 tokens") {
+		t.Errorf("expected an omission note, got %q", out)
+	}
+	if len([]rune(out)) > 50*charsPerToken+200 {
+		t.Errorf("expected output to be cut to about the budget, got %d characters", len([]rune(out)))
+	}
+
+	r.SetLimits(ToolLimits{OutputTokens: -1})
+	if out, _ = r.Execute(context.Background(), "chatty", "{}"); out != "HEAD\n"+long+"TAIL" {
+		t.Errorf("expected no limit with a negative budget")
+	}
+}
+
+func TestReadFileFollowsOutputBudget(t *testing.T) {
+	r, root := newWorkspaceRegistry(t, nil, nil)
+	content := strings.Repeat("0123456789abcdef\n", 2000)
+	if err := os.WriteFile(filepath.Join(root, "big.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := argsJSON(t, map[string]any{"path": "big.txt"})
+
+	r.SetLimits(ToolLimits{OutputTokens: 20000})
+	if out, _ := r.Execute(context.Background(), "read_file", args); out != content {
+		t.Errorf("expected a larger budget to return the whole file, got %d characters", len([]rune(out)))
+	}
+
+	r.SetLimits(ToolLimits{OutputTokens: 100})
+	out, _ := r.Execute(context.Background(), "read_file", args)
+	if strings.Count(out, "omitted") != 1 || strings.Contains(out, "content truncated") {
+		t.Errorf("expected a single truncation note from the budget, got %q", out)
+	}
+}
@@ -0,0 +1,898 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aandrew-me/tgpt/v2/src/bubbletea"
+	"github.com/fatih/color"
+)
+
+// apply_patch accepts two formats. Unified diffs as produced by `diff -u` or
+// `git diff` (including git's rename, new and deleted file headers), and the
+// multi-file envelope:
+//
+//	*** Begin Patch
+//	*** Add File: path
+//	+line
+//	*** Update File: path
+//	*** Move to: new/path
+//	@@ optional anchor, e.g. a function signature
+//	 context
+//	-removed
+//	+added
+//	*** Delete File: path
+//	*** End Patch
+//
+// Hunks are located fuzzily: line numbers are only a hint, whitespace
+// differences are tolerated and up to two lines of context may be ignored.
+
+type patchOp int
+
+const (
+	patchUpdate patchOp = iota
+	patchAdd
+	patchDelete
+)
+
+type patchLine struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+type patchHunk struct {
+	header   string // "@@ ... @@" line as written, for reporting
+	anchor   string // envelope "@@ anchor" text to search for first
+	oldStart int    // 1-based line number hint, 0 if unknown
+	lines    []patchLine
+	newNoEOL bool // the new side ends without a trailing newline
+	oldNoEOL bool // the old side ends without a trailing newline
+}
+
+type filePatch struct {
+	op      patchOp
+	path    string // file the patch applies to
+	newPath string // rename target, empty unless the file is moved
+	hunks   []patchHunk
+}
+
+// parsePatch parses a unified diff or a patch envelope. File names are
+// looked up in the workspace.
+func (r *Registry) parsePatch(patch string) ([]filePatch, error) {
+	patch = strings.ReplaceAll(patch, "\r\n", "\n")
+	var files []filePatch
+	var err error
+	if strings.Contains(patch, "*** Begin Patch") {
+		files, err = parseEnvelopePatch(patch)
+	} else {
+		files, err = parseUnifiedDiff(patch, r.pathExists)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file changes found in patch; expected a unified diff or a *** Begin Patch envelope")
+	}
+	return files, nil
+}
+
+func parseEnvelopePatch(patch string) ([]filePatch, error) {
+	lines := strings.Split(patch, "\n")
+	var files []filePatch
+	var cur *filePatch
+	var hunk *patchHunk
+
+	flushHunk := func() {
+		if cur != nil && hunk != nil && len(hunk.lines) > 0 {
+			cur.hunks = append(cur.hunks, *hunk)
+		}
+		hunk = nil
+	}
+	flushFile := func() {
+		flushHunk()
+		if cur != nil {
+			files = append(files, *cur)
+		}
+		cur = nil
+	}
+
+	inPatch := false
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "*** Begin Patch"):
+			inPatch = true
+		case !inPatch:
+			// Text before the envelope is ignored.
+		case strings.HasPrefix(line, "*** End Patch"):
+			flushFile()
+			inPatch = false
+		case strings.HasPrefix(line, "*** Add File: "):
+			flushFile()
+			cur = &filePatch{op: patchAdd, path: strings.TrimSpace(strings.TrimPrefix(line, "*** Add File: "))}
+			hunk = &patchHunk{}
+		case strings.HasPrefix(line, "*** Delete File: "):
+			flushFile()
+			cur = &filePatch{op: patchDelete, path: strings.TrimSpace(strings.TrimPrefix(line, "*** Delete File: "))}
+		case strings.HasPrefix(line, "*** Update File: "):
+			flushFile()
+			cur = &filePatch{op: patchUpdate, path: strings.TrimSpace(strings.TrimPrefix(line, "*** Update File: "))}
+		case strings.HasPrefix(line, "*** Move to: "):
+			if cur == nil || cur.op != patchUpdate {
+				return nil, fmt.Errorf("line %d: *** Move to must follow *** Update File", i+1)
+			}
+			cur.newPath = strings.TrimSpace(strings.TrimPrefix(line, "*** Move to: "))
+		case strings.HasPrefix(line, "*** End of File"):
+			// Only marks that the hunk ends at the end of the file.
+		case cur == nil:
+			if strings.TrimSpace(line) != "" {
+				return nil, fmt.Errorf("line %d: expected a *** Add/Update/Delete File header, got %q", i+1, line)
+			}
+		case cur.op == patchDelete:
+			if strings.TrimSpace(line) != "" {
+				return nil, fmt.Errorf("line %d: unexpected content after *** Delete File", i+1)
+			}
+		case strings.HasPrefix(line, "@@"):
+			if cur.op == patchAdd {
+				return nil, fmt.Errorf("line %d: hunks are not allowed in *** Add File, prefix each line with '+'", i+1)
+			}
+			flushHunk()
+			anchor := strings.TrimSpace(strings.TrimPrefix(line, "@@"))
+			anchor = strings.TrimSpace(strings.TrimSuffix(anchor, "@@"))
+			hunk = &patchHunk{header: line, anchor: anchor}
+		default:
+			if hunk == nil {
+				hunk = &patchHunk{}
+			}
+			if line == "" {
+				hunk.lines = append(hunk.lines, patchLine{kind: ' '})
+				continue
+			}
+			kind := line[0]
+			if kind != ' ' && kind != '-' && kind != '+' {
+				return nil, fmt.Errorf("line %d: patch lines must start with ' ', '-' or '+', got %q", i+1, line)
+			}
+			if cur.op == patchAdd && kind != '+' {
+				return nil, fmt.Errorf("line %d: every line of *** Add File must start with '+'", i+1)
+			}
+			hunk.lines = append(hunk.lines, patchLine{kind: kind, text: line[1:]})
+		}
+	}
+	if inPatch {
+		flushFile()
+	}
+
+	for i := range files {
+		for j := range files[i].hunks {
+			trimTrailingBlankContext(&files[i].hunks[j])
+		}
+		if files[i].path == "" {
+			return nil, fmt.Errorf("file header without a path")
+		}
+		if files[i].op == patchUpdate && len(files[i].hunks) == 0 && files[i].newPath == "" {
+			return nil, fmt.Errorf("*** Update File: %s has no changes", files[i].path)
+		}
+	}
+	return files, nil
+}
+
+func parseUnifiedDiff(patch string, exists func(path string) bool) ([]filePatch, error) {
+	lines := strings.Split(patch, "\n")
+	var files []filePatch
+	var cur *filePatch
+
+	// Headers from a "diff --git" block that apply to the next file.
+	var gitOld, gitNew, renameFrom, renameTo string
+	var gitNewFile, gitDeleted bool
+	resetGit := func() {
+		gitOld, gitNew, renameFrom, renameTo = "", "", "", ""
+		gitNewFile, gitDeleted = false, false
+	}
+	flushGitOnly := func() {
+		// A git block without hunks, such as a pure rename or deleting an
+		// empty file, has no ---/+++ lines.
+		if gitOld == "" && gitNew == "" {
+			return
+		}
+		switch {
+		case renameFrom != "" && renameTo != "":
+			files = append(files, filePatch{op: patchUpdate, path: renameFrom, newPath: renameTo})
+		case gitDeleted:
+			files = append(files, filePatch{op: patchDelete, path: gitOld})
+		case gitNewFile:
+			files = append(files, filePatch{op: patchAdd, path: gitNew})
+		}
+		resetGit()
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flushGitOnly()
+			cur = nil
+			fields := strings.Fields(strings.TrimPrefix(line, "diff --git "))
+			if len(fields) == 2 {
+				gitOld, gitNew = stripDiffPrefix(fields[0]), stripDiffPrefix(fields[1])
+			}
+		case strings.HasPrefix(line, "rename from "):
+			renameFrom = strings.TrimPrefix(line, "rename from ")
+		case strings.HasPrefix(line, "rename to "):
+			renameTo = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "new file mode"):
+			gitNewFile = true
+		case strings.HasPrefix(line, "deleted file mode"):
+			gitDeleted = true
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			oldPath := diffHeaderPath(strings.TrimPrefix(line, "--- "))
+			newPath := diffHeaderPath(strings.TrimPrefix(lines[i+1], "+++ "))
+			i++
+
+			fp := filePatch{op: patchUpdate, path: oldPath}
+			switch {
+			case oldPath == "/dev/null" || gitNewFile:
+				fp.op = patchAdd
+				fp.path = newPath
+			case newPath == "/dev/null" || gitDeleted:
+				fp.op = patchDelete
+			case renameFrom != "" && renameTo != "":
+				fp.path, fp.newPath = renameFrom, renameTo
+			case newPath != oldPath && !strings.HasPrefix(line, "--- a/"):
+				// Plain diff -u between two names: patch the new name
+				// if only it exists, as patch(1) does.
+				if !exists(oldPath) {
+					fp.path = newPath
+				}
+			}
+			if fp.path == "" || fp.path == "/dev/null" {
+				return nil, fmt.Errorf("line %d: missing file name in diff header", i)
+			}
+			resetGit()
+			files = append(files, fp)
+			cur = &files[len(files)-1]
+		case strings.HasPrefix(line, "@@"):
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: hunk without a ---/+++ file header", i+1)
+			}
+			hunk := patchHunk{header: line, oldStart: parseHunkStart(line)}
+			j := i + 1
+		hunkLines:
+			for ; j < len(lines); j++ {
+				l := lines[j]
+				if strings.HasPrefix(l, "@@") || strings.HasPrefix(l, "diff --git ") ||
+					(strings.HasPrefix(l, "--- ") && j+1 < len(lines) && strings.HasPrefix(lines[j+1], "+++ ")) {
+					break
+				}
+				if l == "" {
+					hunk.lines = append(hunk.lines, patchLine{kind: ' '})
+					continue
+				}
+				switch l[0] {
+				case ' ', '-', '+':
+					hunk.lines = append(hunk.lines, patchLine{kind: l[0], text: l[1:]})
+				case '\\':
+					// "\ No newline at end of file" refers to the line above.
+					if n := len(hunk.lines); n > 0 {
+						switch hunk.lines[n-1].kind {
+						case '+':
+							hunk.newNoEOL = true
+						case '-':
+							hunk.oldNoEOL = true
+						default:
+							hunk.newNoEOL, hunk.oldNoEOL = true, true
+						}
+					}
+				default:
+					// Anything else ends the hunk (e.g. trailing prose).
+					break hunkLines
+				}
+			}
+			trimTrailingBlankContext(&hunk)
+			cur.hunks = append(cur.hunks, hunk)
+			i = j - 1
+		}
+	}
+	flushGitOnly()
+	return files, nil
+}
+
+// trimTrailingBlankContext drops blank context lines at the end of a hunk,
+// which usually come from the blank line separating it from what follows.
+func trimTrailingBlankContext(h *patchHunk) {
+	for n := len(h.lines); n > 0 && h.lines[n-1].kind == ' ' && h.lines[n-1].text == ""; n-- {
+		h.lines = h.lines[:n-1]
+	}
+}
+
+func stripDiffPrefix(p string) string {
+	if strings.HasPrefix(p, "a/") || strings.HasPrefix(p, "b/") {
+		return p[2:]
+	}
+	return p
+}
+
+// diffHeaderPath extracts the path from a ---/+++ line, dropping a trailing
+// timestamp and git's a/ and b/ prefixes.
+func diffHeaderPath(s string) string {
+	if i := strings.Index(s, "\t"); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return s
+	}
+	return stripDiffPrefix(s)
+}
+
+func parseHunkStart(header string) int {
+	// @@ -12,7 +12,8 @@
+	fields := strings.Fields(header)
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "-") {
+		return 0
+	}
+	start, _, _ := strings.Cut(fields[1][1:], ",")
+	n, err := strconv.Atoi(start)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// hunkResult describes where a hunk was applied, or why it could not be.
+type hunkResult struct {
+	line   int // 1-based line in the original file
+	offset int // distance from the line number in the hunk header
+	fuzz   int // context lines ignored at each end
+	loose  bool
+	err    error
+}
+
+func (h hunkResult) String() string {
+	if h.err != nil {
+		return "FAILED: " + h.err.Error()
+	}
+	s := fmt.Sprintf("applied at line %d", h.line)
+	var notes []string
+	if h.offset != 0 {
+		notes = append(notes, fmt.Sprintf("offset %+d lines", h.offset))
+	}
+	if h.loose {
+		notes = append(notes, "ignoring whitespace")
+	}
+	if h.fuzz > 0 {
+		notes = append(notes, fmt.Sprintf("ignoring %d context line(s) at each end", h.fuzz))
+	}
+	if len(notes) > 0 {
+		s += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return s
+}
+
+// applyHunks applies hunks in order to the lines of a file.
+func applyHunks(orig []string, hunks []patchHunk) ([]string, []hunkResult) {
+	out := make([]string, 0, len(orig))
+	results := make([]hunkResult, len(hunks))
+	cursor := 0
+
+	for i, h := range hunks {
+		pos, lines, res := locateHunk(orig, h, cursor)
+		if res.err != nil {
+			results[i] = res
+			continue
+		}
+		results[i] = res
+
+		out = append(out, orig[cursor:pos]...)
+		j := pos
+		for _, l := range lines {
+			switch l.kind {
+			case ' ':
+				// Keep the file's own version of context lines.
+				out = append(out, orig[j])
+				j++
+			case '-':
+				j++
+			case '+':
+				out = append(out, l.text)
+			}
+		}
+		cursor = j
+	}
+	out = append(out, orig[cursor:]...)
+	return out, results
+}
+
+// locateHunk finds where the hunk's old lines are in orig at or after
+// cursor. It tries exact matches first, then matches ignoring whitespace,
+// then ignores up to two context lines at each end of the hunk.
+func locateHunk(orig []string, h patchHunk, cursor int) (int, []patchLine, hunkResult) {
+	hint := cursor
+	if h.oldStart > 0 {
+		hint = h.oldStart - 1
+	}
+	if h.anchor != "" {
+		anchor := strings.TrimSpace(h.anchor)
+		found := false
+		for i := cursor; i < len(orig); i++ {
+			if strings.Contains(strings.TrimSpace(orig[i]), anchor) {
+				cursor, hint, found = i+1, i+1, true
+				break
+			}
+		}
+		if !found {
+			return 0, nil, hunkResult{err: fmt.Errorf("anchor %q not found", h.anchor)}
+		}
+	}
+
+	for fuzz := 0; fuzz <= 2; fuzz++ {
+		lines, lead, ok := trimContext(h.lines, fuzz)
+		if !ok {
+			break
+		}
+		var old []string
+		for _, l := range lines {
+			if l.kind != '+' {
+				old = append(old, l.text)
+			}
+		}
+
+		if len(old) == 0 {
+			// Pure insertion. In a unified diff "@@ -N,0" inserts after
+			// line N; an envelope hunk goes after its anchor, or at the end
+			// of the file without one.
+			pos := len(orig)
+			if strings.HasPrefix(h.header, "@@ -") {
+				pos = h.oldStart
+			} else if h.anchor != "" {
+				pos = cursor
+			}
+			pos = min(max(pos, cursor), len(orig))
+			return pos, lines, hunkResult{line: pos + 1}
+		}
+
+		for _, loose := range []bool{false, true} {
+			if pos := findLines(orig, old, cursor, hint, loose); pos >= 0 {
+				res := hunkResult{line: pos + 1, fuzz: fuzz, loose: loose}
+				if h.oldStart > 0 {
+					res.offset = pos - lead - (h.oldStart - 1)
+				}
+				return pos, lines, res
+			}
+		}
+	}
+	return 0, nil, hunkResult{err: fmt.Errorf("could not find the lines to change (the file may differ from what the patch expects)")}
+}
+
+// trimContext drops n context lines from each end of a hunk. It reports how
+// many were dropped at the start and whether the hunk still has context left
+// to match on.
+func trimContext(lines []patchLine, n int) ([]patchLine, int, bool) {
+	if n == 0 {
+		return lines, 0, true
+	}
+	start, end := 0, len(lines)
+	for k := 0; k < n && start < end && lines[start].kind == ' '; k++ {
+		start++
+	}
+	for k := 0; k < n && end > start && lines[end-1].kind == ' '; k++ {
+		end--
+	}
+	if start == 0 && end == len(lines) {
+		return nil, 0, false
+	}
+	trimmed := lines[start:end]
+	for _, l := range trimmed {
+		if l.kind != '+' {
+			return trimmed, start, true
+		}
+	}
+	return nil, 0, false
+}
+
+// findLines returns the position of old in orig closest to hint, searching
+// from cursor onwards, or -1.
+func findLines(orig, old []string, cursor, hint int, loose bool) int {
+	best := -1
+	for pos := cursor; pos+len(old) <= len(orig); pos++ {
+		if !linesMatch(orig[pos:pos+len(old)], old, loose) {
+			continue
+		}
+		if best < 0 || abs(pos-hint) < abs(best-hint) {
+			best = pos
+		}
+	}
+	return best
+}
+
+func linesMatch(a, b []string, loose bool) bool {
+	for i := range b {
+		x, y := strings.TrimRight(a[i], "\r"), b[i]
+		if loose {
+			x, y = strings.Join(strings.Fields(x), " "), strings.Join(strings.Fields(y), " ")
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// splitFileLines splits file content into lines and reports whether it ends
+// with a newline.
+func splitFileLines(content string) ([]string, bool) {
+	if content == "" {
+		return nil, true
+	}
+	eol := strings.HasSuffix(content, "\n")
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n"), eol
+}
+
+func joinFileLines(lines []string, eol bool) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	s := strings.Join(lines, "\n")
+	if eol {
+		s += "\n"
+	}
+	return s
+}
+
+// plannedChange is the outcome of one file patch, computed before anything is
+// written to disk.
+type plannedChange struct {
+	fp       filePatch
+	path     string // resolved source path
+	newPath  string // resolved destination, equal to path unless renamed
+	content  []byte
+	mode     os.FileMode
+	original []byte // previous content of path, nil if it did not exist
+	results  []hunkResult
+	err      error
+}
+
+// planPatch plans every file patch. Each is planned against the file as it
+// is on disk, so a file may only be touched by one of them.
+func (r *Registry) planPatch(files []filePatch) ([]plannedChange, bool) {
+	changes := make([]plannedChange, len(files))
+	touched := make(map[string]bool)
+	ok := true
+	for i, fp := range files {
+		c := &changes[i]
+		c.fp = fp
+		c.mode = 0644
+		c.err = r.planFile(c)
+		if c.err == nil {
+			paths := map[string]string{c.path: fp.path}
+			if c.newPath != c.path {
+				paths[c.newPath] = fp.newPath
+			}
+			for path, name := range paths {
+				if touched[path] {
+					c.err = fmt.Errorf("%s is changed by more than one section of the patch; combine them into one", name)
+				}
+				touched[path] = true
+			}
+		}
+		if c.err != nil {
+			ok = false
+		}
+		for _, res := range c.results {
+			if res.err != nil {
+				ok = false
+			}
+		}
+	}
+	return changes, ok
+}
+
+func (r *Registry) planFile(c *plannedChange) error {
+	fp := c.fp
+	path, err := r.resolvePath(fp.path)
+	if err != nil {
+		return err
+	}
+	c.path, c.newPath = path, path
+	if fp.newPath != "" {
+		if c.newPath, err = r.resolvePath(fp.newPath); err != nil {
+			return err
+		}
+		if _, err := os.Stat(c.newPath); err == nil {
+			return fmt.Errorf("cannot move to %s: file already exists", fp.newPath)
+		}
+	}
+
+	info, statErr := os.Stat(path)
+	switch fp.op {
+	case patchAdd:
+		if statErr == nil {
+			return fmt.Errorf("cannot add %s: file already exists", fp.path)
+		}
+		var lines []string
+		eol := true
+		for _, h := range fp.hunks {
+			for _, l := range h.lines {
+				if l.kind == '+' {
+					lines = append(lines, l.text)
+				}
+			}
+			if h.newNoEOL {
+				eol = false
+			}
+		}
+		c.content = []byte(joinFileLines(lines, eol))
+		return nil
+	case patchDelete:
+		if statErr != nil {
+			return fmt.Errorf("cannot delete %s: %w", fp.path, statErr)
+		}
+		c.original, err = os.ReadFile(path)
+		return err
+	}
+
+	if statErr != nil {
+		return fmt.Errorf("cannot update %s: %w", fp.path, statErr)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("cannot update %s: it is a directory", fp.path)
+	}
+	c.mode = info.Mode().Perm()
+	if c.original, err = os.ReadFile(path); err != nil {
+		return fmt.Errorf("failed to read %s: %w", fp.path, err)
+	}
+
+	lines, eol := splitFileLines(string(c.original))
+	newLines, results := applyHunks(lines, fp.hunks)
+	c.results = results
+	for i, h := range fp.hunks {
+		if results[i].err != nil {
+			continue
+		}
+		if h.newNoEOL {
+			eol = false
+		} else if h.oldNoEOL {
+			eol = true
+		}
+	}
+	c.content = []byte(joinFileLines(newLines, eol))
+	return nil
+}
+
+// commitPatch writes all planned changes, restoring the previous state of
+// every file, and removing the directories created for new files, if any
+// write fails.
+func commitPatch(changes []plannedChange) error {
+	type undo struct {
+		path     string
+		original []byte
+		mode     os.FileMode
+	}
+	var done []undo
+	var createdDirs []string
+	rollback := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			u := done[i]
+			if u.original == nil {
+				os.Remove(u.path)
+			} else {
+				os.WriteFile(u.path, u.original, u.mode)
+			}
+		}
+		for i := len(createdDirs) - 1; i >= 0; i-- {
+			os.Remove(createdDirs[i])
+		}
+	}
+
+	for _, c := range changes {
+		if c.fp.op == patchDelete {
+			continue
+		}
+		createdDirs = append(createdDirs, missingDirs(filepath.Dir(c.newPath))...)
+		if err := writeFileAtomic(c.newPath, c.content, c.mode); err != nil {
+			rollback()
+			return fmt.Errorf("failed to write %s: %w", c.newPath, err)
+		}
+		original := c.original
+		if c.newPath != c.path {
+			original = nil
+		}
+		done = append(done, undo{c.newPath, original, c.mode})
+	}
+	for _, c := range changes {
+		if c.fp.op != patchDelete && c.newPath == c.path {
+			continue
+		}
+		if err := os.Remove(c.path); err != nil {
+			rollback()
+			return fmt.Errorf("failed to remove %s: %w", c.path, err)
+		}
+		done = append(done, undo{c.path, c.original, c.mode})
+	}
+	return nil
+}
+
+// missingDirs returns the directories in dir's path that do not exist yet,
+// outermost first.
+func missingDirs(dir string) []string {
+	var missing []string
+	for {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		missing = append([]string{dir}, missing...)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return missing
+}
+
+// writeFileAtomic writes to a temporary file next to path and renames it into
+// place, so readers never see a partly written file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tgpt-patch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func patchReport(changes []plannedChange) string {
+	var b strings.Builder
+	for _, c := range changes {
+		switch {
+		case c.fp.op == patchAdd:
+			fmt.Fprintf(&b, "A %s", c.fp.path)
+		case c.fp.op == patchDelete:
+			fmt.Fprintf(&b, "D %s", c.fp.path)
+		case c.fp.newPath != "":
+			fmt.Fprintf(&b, "R %s -> %s", c.fp.path, c.fp.newPath)
+		default:
+			fmt.Fprintf(&b, "M %s", c.fp.path)
+		}
+		if c.err != nil {
+			fmt.Fprintf(&b, ": FAILED: %v", c.err)
+		}
+		b.WriteString("\n")
+		for i, res := range c.results {
+			header := c.fp.hunks[i].header
+			if header == "" {
+				header = "(no header)"
+			}
+			fmt.Fprintf(&b, "  hunk %d/%d %s: %s\n", i+1, len(c.results), header, res)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+var (
+	diffAdded   = color.New(color.FgGreen)
+	diffRemoved = color.New(color.FgRed)
+	diffHunk    = color.New(color.FgCyan)
+)
+
+// renderPatchPreview formats the parsed patch as a coloured diff for the
+// confirmation prompt.
+func renderPatchPreview(files []filePatch) string {
+	var b strings.Builder
+	for _, fp := range files {
+		switch {
+		case fp.op == patchAdd:
+			b.WriteString(bold.Sprintf("Add %s", fp.path))
+		case fp.op == patchDelete:
+			b.WriteString(bold.Sprintf("Delete %s", fp.path))
+		case fp.newPath != "":
+			b.WriteString(bold.Sprintf("Update %s (move to %s)", fp.path, fp.newPath))
+		default:
+			b.WriteString(bold.Sprintf("Update %s", fp.path))
+		}
+		b.WriteString("\n")
+		for _, h := range fp.hunks {
+			if h.header != "" {
+				b.WriteString(diffHunk.Sprint(h.header) + "\n")
+			}
+			for _, l := range h.lines {
+				text := string(l.kind) + l.text
+				switch l.kind {
+				case '+':
+					b.WriteString(diffAdded.Sprint(text))
+				case '-':
+					b.WriteString(diffRemoved.Sprint(text))
+				default:
+					b.WriteString(text)
+				}
+				b.WriteString("\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// patchConfirmPrompt is the question asked before apply_patch runs.
+func (r *Registry) patchConfirmPrompt(patch string) string {
+	files, err := r.parsePatch(patch)
+	if err != nil {
+		// The handler reports the parse error without changing anything.
+		return ""
+	}
+	noun := "files"
+	if len(files) == 1 {
+		noun = "file"
+	}
+	return fmt.Sprintf("\n%s\nApply this patch to %d %s?", renderPatchPreview(files), len(files), noun)
+}
+
+func (r *Registry) applyPatch(ctx context.Context, patch string) (string, error) {
+	files, err := r.parsePatch(patch)
+	if err != nil {
+		return "", fmt.Errorf("invalid patch: %w", err)
+	}
+
+	autoExec, _ := ctx.Value(AutoExecKey).(bool)
+	confirmed, _ := ctx.Value(ConfirmedKey).(bool)
+	if !autoExec && !confirmed {
+		c, err := confirmAction(r.patchConfirmPrompt(patch))
+		if err != nil {
+			if errors.Is(err, bubbletea.ErrCanceled) {
+				return "Patch cancelled by user.", nil
+			}
+			return "", err
+		}
+		if !c {
+			return "Patch cancelled by user.", nil
+		}
+	}
+
+	changes, ok := r.planPatch(files)
+	if !ok {
+		return "", fmt.Errorf("patch not applied, no files were changed:\n%s", patchReport(changes))
+	}
+	for _, c := range changes {
+		if err := r.snapshot(c.path, "apply_patch"); err != nil {
+			return "", fmt.Errorf("patch not applied, no files were changed: %w", err)
+		}
+		if c.newPath != c.path {
+			if err := r.snapshot(c.newPath, "apply_patch"); err != nil {
+				return "", fmt.Errorf("patch not applied, no files were changed: %w", err)
+			}
+		}
+	}
+	if err := commitPatch(changes); err != nil {
+		return "", fmt.Errorf("patch not applied, no files were changed: %w", err)
+	}
+	for _, c := range changes {
+		r.wrote(c.path)
+		if c.newPath != c.path {
+			r.wrote(c.newPath)
+		}
+	}
+	return "Patch applied:\n" + patchReport(changes), nil
+}
@@ -0,0 +1,270 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runPatch(t *testing.T, r *Registry, patch string) (string, error) {
+	t.Helper()
+	args, _ := json.Marshal(map[string]string{"patch": patch})
+	ctx := context.WithValue(context.Background(), AutoExecKey, true)
+	return r.Execute(ctx, "apply_patch", string(args))
+}
+
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestApplyPatchUnifiedDiffWithDrift(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "apply_patch")
+	// Two lines were added above the hunk and the indentation changed
+	// since the diff was made.
+	writeTestFile(t, dir, "main.go", "package main\n\n// added\n// later\nfunc main() {\n    println(\"a\")\n    println(\"b\")\n}\n")
+
+	patch := `--- a/main.go
++++ b/main.go
+@@ -3,4 +3,4 @@
+ func main() {
+ 	println("a")
+-	println("b")
++	println("c")
+ }
+`
+	out, err := runPatch(t, r, patch)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out, "M main.go") || !strings.Contains(out, "hunk 1/1") || !strings.Contains(out, "offset +2 lines") || !strings.Contains(out, "ignoring whitespace") {
+		t.Errorf("unexpected report: %s", out)
+	}
+	want := "package main\n\n// added\n// later\nfunc main() {\n    println(\"a\")\n\tprintln(\"c\")\n}\n"
+	if got := readTestFile(t, dir, "main.go"); got != want {
+		t.Errorf("unexpected result:\n%s", got)
+	}
+}
+
+func TestApplyPatchPlainDiffLooksUpNamesInWorkspace(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "apply_patch")
+	// The workspace is not the current directory, where neither name exists.
+	writeTestFile(t, dir, "config.old", "a\nb\n")
+
+	patch := "--- config.old\n+++ config.new\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n"
+	if _, err := runPatch(t, r, patch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := readTestFile(t, dir, "config.old"); got != "a\nc\n" {
+		t.Errorf("expected the existing old name to be patched, got %q", got)
+	}
+}
+
+func TestApplyPatchEnvelopeMultiFile(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "apply_patch")
+	writeTestFile(t, dir, "a.txt", "one\ntwo\nthree\n")
+	writeTestFile(t, dir, "old.txt", "keep\nchange\n")
+	writeTestFile(t, dir, "gone.txt", "bye\n")
+
+	patch := `*** Begin Patch
+*** Add File: sub/new.txt
++hello
++world
+*** Update File: a.txt
+@@
+ one
+-two
++2
+ three
+*** Update File: old.txt
+*** Move to: moved.txt
+@@
+ keep
+-change
++changed
+*** Delete File: gone.txt
+*** End Patch`
+	out, err := runPatch(t, r, patch)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"A sub/new.txt", "M a.txt", "R old.txt -> moved.txt", "D gone.txt"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in report: %s", want, out)
+		}
+	}
+	if got := readTestFile(t, dir, "sub/new.txt"); got != "hello\nworld\n" {
+		t.Errorf("unexpected new file %q", got)
+	}
+	if got := readTestFile(t, dir, "a.txt"); got != "one\n2\nthree\n" {
+		t.Errorf("unexpected a.txt %q", got)
+	}
+	if got := readTestFile(t, dir, "moved.txt"); got != "keep\nchanged\n" {
+		t.Errorf("unexpected moved.txt %q", got)
+	}
+	for _, name := range []string{"old.txt", "gone.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+}
+
+func TestApplyPatchAllOrNothing(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "apply_patch")
+	writeTestFile(t, dir, "a.txt", "alpha\nbeta\n")
+	writeTestFile(t, dir, "b.txt", "gamma\ndelta\n")
+
+	patch := `--- a/a.txt
++++ b/a.txt
+@@ -1,2 +1,2 @@
+-alpha
++ALPHA
+ beta
+--- a/b.txt
++++ b/b.txt
+@@ -1,2 +1,2 @@
+ gamma
+-epsilon
++EPSILON
+`
+	_, err := runPatch(t, r, patch)
+	if err == nil {
+		t.Fatal("expected the patch to fail")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "no files were changed") || !strings.Contains(msg, "M a.txt\n  hunk 1/1 @@ -1,2 +1,2 @@: applied at line 1") || !strings.Contains(msg, "hunk 1/1 @@ -1,2 +1,2 @@: FAILED") {
+		t.Errorf("expected a per-hunk report, got: %s", msg)
+	}
+	if got := readTestFile(t, dir, "a.txt"); got != "alpha\nbeta\n" {
+		t.Errorf("expected a.txt to be untouched, got %q", got)
+	}
+}
+
+func TestApplyPatchRejectsRepeatedFile(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "apply_patch")
+	writeTestFile(t, dir, "a.txt", "one\ntwo\nthree\n")
+
+	patch := `*** Begin Patch
+*** Update File: a.txt
+@@
+-one
++1
+*** Update File: a.txt
+@@
+-three
++3
+*** End Patch`
+	_, err := runPatch(t, r, patch)
+	if err == nil || !strings.Contains(err.Error(), "more than one section") {
+		t.Fatalf("expected a patch updating a file twice to be rejected, got %v", err)
+	}
+	if got := readTestFile(t, dir, "a.txt"); got != "one\ntwo\nthree\n" {
+		t.Errorf("expected a.txt to be untouched, got %q", got)
+	}
+}
+
+func TestCommitPatchRollbackRemovesCreatedDirs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "blocker", "a file, not a directory\n")
+	changes := []plannedChange{
+		{fp: filePatch{op: patchAdd}, path: filepath.Join(dir, "new", "sub", "a.txt"), content: []byte("a\n"), mode: 0644},
+		{fp: filePatch{op: patchAdd}, path: filepath.Join(dir, "blocker", "b.txt"), content: []byte("b\n"), mode: 0644},
+	}
+	for i := range changes {
+		changes[i].newPath = changes[i].path
+	}
+	if err := commitPatch(changes); err == nil {
+		t.Fatal("expected writing under a file to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Errorf("expected the directories created for the rolled back file to be removed, got %v", err)
+	}
+}
+
+func TestApplyPatchGitNewDeleteAndNoNewline(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "apply_patch")
+	writeTestFile(t, dir, "old.txt", "x\n")
+	writeTestFile(t, dir, "end.txt", "a\nb")
+
+	patch := `diff --git a/new.txt b/new.txt
+new file mode 100644
+--- /dev/null
++++ b/new.txt
+@@ -0,0 +1,2 @@
++first
++second
+\ No newline at end of file
+diff --git a/old.txt b/old.txt
+deleted file mode 100644
+--- a/old.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-x
+diff --git a/end.txt b/end.txt
+--- a/end.txt
++++ b/end.txt
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++c
+`
+	if out, err := runPatch(t, r, patch); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out)
+	}
+	if got := readTestFile(t, dir, "new.txt"); got != "first\nsecond" {
+		t.Errorf("unexpected new.txt %q", got)
+	}
+	if got := readTestFile(t, dir, "end.txt"); got != "a\nc\n" {
+		t.Errorf("unexpected end.txt %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old.txt")); !os.IsNotExist(err) {
+		t.Error("expected old.txt to be deleted")
+	}
+}
+
+func TestApplyPatchRespectsWorkspace(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "apply_patch")
+	writeTestFile(t, dir, "ok.txt", "a\n")
+
+	patch := "*** Begin Patch\n*** Update File: ok.txt\n-a\n+b\n*** Add File: ../escape.txt\n+x\n*** End Patch"
+	if _, err := runPatch(t, r, patch); err == nil || !strings.Contains(err.Error(), "outside the workspace") {
+		t.Fatalf("expected workspace error, got %v", err)
+	}
+	if got := readTestFile(t, dir, "ok.txt"); got != "a\n" {
+		t.Errorf("expected ok.txt to be untouched, got %q", got)
+	}
+}
+
+func TestApplyPatchConfirmPreview(t *testing.T) {
+	r := NewRegistry()
+	args, _ := json.Marshal(map[string]string{"patch": "*** Begin Patch\n*** Update File: a.txt\n-old\n+new\n*** End Patch"})
+	var parsed map[string]any
+	_ = json.Unmarshal(args, &parsed)
+
+	prompt, cancel := r.confirmPrompt("apply_patch", parsed, string(args))
+	if !strings.Contains(prompt, "Update a.txt") || !strings.Contains(prompt, "+new") || !strings.Contains(prompt, "Apply this patch to 1 file?") {
+		t.Errorf("unexpected preview: %q", prompt)
+	}
+	if cancel != "Patch cancelled by user." {
+		t.Errorf("unexpected cancel message %q", cancel)
+	}
+}
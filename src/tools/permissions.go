@@ -0,0 +1,296 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// PermissionRule allows or denies tool calls without asking. Tool and Pattern
+// are globs where '*' matches any text (including '/') and '?' one
+// character. Pattern is matched against the call's subject: the command for
+// execute_command, the path for write_file and edit_file, the method and URL
+// for http_request ("POST https://api.example.com/items"), and the JSON
+// arguments for other tools. An empty pattern matches every call.
+type PermissionRule struct {
+	Tool    string `json:"tool"`
+	Pattern string `json:"pattern,omitempty"`
+	Action  string `json:"action"` // "allow" or "deny"
+}
+
+func (rule PermissionRule) String() string {
+	if rule.Pattern == "" {
+		return fmt.Sprintf("%s %s", rule.Action, rule.Tool)
+	}
+	return fmt.Sprintf("%s %s %q", rule.Action, rule.Tool, rule.Pattern)
+}
+
+type PermissionsConfig struct {
+	Rules []PermissionRule `json:"rules"`
+}
+
+// Outcomes recorded in a Decision.
+const (
+	OutcomeNotRequired  = "not_required"
+	OutcomeAutoExec     = "auto_exec"
+	OutcomeRuleAllow    = "rule_allow"
+	OutcomeRuleDeny     = "rule_deny"
+	OutcomeAllowOnce    = "user_allow_once"
+	OutcomeAllowSession = "user_allow_session"
+	OutcomeAllowAlways  = "user_allow_always"
+	OutcomeUserDeny     = "user_deny"
+	OutcomePlanned      = "planned"
+	OutcomePlanApply    = "user_plan_apply"
+)
+
+// Decision is the result of checking a tool call before it runs.
+type Decision struct {
+	Proceed bool
+	Message string // returned to the model instead of the tool output when !Proceed
+	Outcome string
+	Rule    *PermissionRule // the rule that decided, if any
+}
+
+// Permissions holds persistent rules loaded from permissions.json together
+// with rules added for the current session only.
+type Permissions struct {
+	mu      sync.Mutex
+	path    string
+	rules   []PermissionRule
+	session []PermissionRule
+}
+
+// shellOperatorRe finds shell syntax that chains or redirects commands. An
+// allow rule for a command prefix must not approve whatever follows it.
+var shellOperatorRe = regexp.MustCompile("[;&|<>`\n]|\\$\\(")
+
+// DefaultPermissionsPath returns ~/.config/tgpt/permissions.json.
+func DefaultPermissionsPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".config", "tgpt", "permissions.json")
+}
+
+// LoadPermissions reads rules from path, or from the default location when
+// path is empty. A missing default file yields an empty rule set.
+func LoadPermissions(path string) (*Permissions, error) {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultPermissionsPath()
+	}
+	p := &Permissions{path: path}
+	if path == "" {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return p, nil
+		}
+		return nil, fmt.Errorf("failed to read permissions: %w", err)
+	}
+
+	var cfg PermissionsConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse permissions JSON: %w", err)
+	}
+	for _, rule := range cfg.Rules {
+		if rule.Action != "allow" && rule.Action != "deny" {
+			return nil, fmt.Errorf("invalid permission rule %+v: action must be \"allow\" or \"deny\"", rule)
+		}
+		if rule.Tool == "" {
+			return nil, fmt.Errorf("invalid permission rule %+v: tool is required", rule)
+		}
+	}
+	p.rules = cfg.Rules
+	return p, nil
+}
+
+// Match returns the rule deciding a call to tool with the given subject.
+// Deny rules win over allow rules.
+func (p *Permissions) Match(tool, subject string) (*PermissionRule, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	all := append(append([]PermissionRule{}, p.rules...), p.session...)
+	for i := range all {
+		if all[i].Action == "deny" && ruleMatches(all[i], tool, subject) {
+			return &all[i], true
+		}
+	}
+	for i := range all {
+		if all[i].Action == "allow" && ruleMatches(all[i], tool, subject) {
+			return &all[i], true
+		}
+	}
+	return nil, false
+}
+
+// AddSession adds a rule that lasts until tgpt exits.
+func (p *Permissions) AddSession(rule PermissionRule) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.session = append(p.session, rule)
+}
+
+// AddPersistent adds a rule and writes it to the permissions file.
+func (p *Permissions) AddPersistent(rule PermissionRule) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = append(p.rules, rule)
+	if p.path == "" {
+		return fmt.Errorf("no permissions file location available")
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(PermissionsConfig{Rules: p.rules}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.path, data, 0600)
+}
+
+func ruleMatches(rule PermissionRule, tool, subject string) bool {
+	if !globMatch(rule.Tool, tool) {
+		return false
+	}
+	if rule.Pattern == "" {
+		return true
+	}
+	pattern := rule.Pattern
+	if tool == "write_file" || tool == "edit_file" {
+		pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "./")
+	}
+	if tool != "execute_command" {
+		return globMatch(pattern, subject)
+	}
+
+	if rule.Action == "deny" {
+		// A dangerous command can hide anywhere in a chain.
+		if globMatch(pattern, subject) {
+			return true
+		}
+		for _, part := range shellOperatorRe.Split(subject, -1) {
+			if globMatch(pattern, strings.TrimSpace(part)) {
+				return true
+			}
+		}
+		return false
+	}
+	if shellOperatorRe.MatchString(subject) && !shellOperatorRe.MatchString(pattern) {
+		return false
+	}
+	return globMatch(pattern, subject)
+}
+
+func globMatch(pattern, s string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString("(?s:.*)")
+		case '?':
+			b.WriteString("(?s:.)")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	return err == nil && re.MatchString(s)
+}
+
+// SuggestPattern proposes the pattern offered by "always allow": the command
+// name plus its subcommand for execute_command, the file's directory for
+// file tools, and the method and host for http_request.
+func SuggestPattern(tool, subject string) string {
+	switch tool {
+	case "execute_command":
+		fields := strings.Fields(subject)
+		if len(fields) == 0 {
+			return ""
+		}
+		prefix := fields[0]
+		if len(fields) > 1 && !strings.HasPrefix(fields[1], "-") && !strings.ContainsAny(fields[1], "/.\\'\"$") {
+			prefix += " " + fields[1]
+		}
+		return prefix + "*"
+	case "write_file", "edit_file":
+		dir := filepath.ToSlash(filepath.Dir(subject))
+		if dir == "." || dir == "" {
+			return "*"
+		}
+		return dir + "/*"
+	case "http_request":
+		method, rawURL, ok := strings.Cut(subject, " ")
+		u, err := url.Parse(rawURL)
+		if !ok || err != nil || u.Host == "" {
+			return ""
+		}
+		return fmt.Sprintf("%s %s://%s/*", method, u.Scheme, u.Host)
+	default:
+		return ""
+	}
+}
+
+// SetPermissions installs the rules consulted by Confirm.
+func (r *Registry) SetPermissions(p *Permissions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.permissions = p
+}
+
+// Permissions returns the registry's rule set, creating an empty one backed by
+// the default file if none was installed.
+func (r *Registry) Permissions() *Permissions {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.permissions == nil {
+		r.permissions = &Permissions{path: DefaultPermissionsPath()}
+	}
+	return r.permissions
+}
+
+// permissionSubject is what rule patterns are matched against for a call.
+func (r *Registry) permissionSubject(name string, args map[string]any, argsJSON string) string {
+	switch name {
+	case "execute_command":
+		cmd, _ := args["command"].(string)
+		return strings.TrimSpace(cmd)
+	case "write_file", "edit_file":
+		path, _ := args["path"].(string)
+		if resolved, err := r.resolvePath(path); err == nil {
+			path = resolved
+		}
+		if filepath.IsAbs(path) {
+			base := ""
+			if w := r.Workspace(); w != nil {
+				base = w.Root
+			} else if wd, err := os.Getwd(); err == nil {
+				base = wd
+			}
+			if rel, err := filepath.Rel(base, path); base != "" && err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+		}
+		return filepath.ToSlash(filepath.Clean(path))
+	case "http_request":
+		call, err := r.parseHTTPCall(args)
+		if err != nil {
+			return argsJSON
+		}
+		return call.method + " " + call.url.String()
+	default:
+		return argsJSON
+	}
+}
@@ -0,0 +1,236 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// planApplyKey marks that a call is being applied from a reviewed plan, so
+// Execute runs it instead of queueing it again.
+const planApplyKey contextKey = "plan_apply"
+
+// PlanStep is a mutating tool call queued in plan mode.
+type PlanStep struct {
+	Tool      string
+	Arguments string
+	Preview   string // the diff, command or request shown when reviewing
+}
+
+// Plan collects the mutating tool calls of a session in plan mode (--plan).
+// The calls are not executed; the model is told they were queued and the
+// user reviews them afterwards.
+type Plan struct {
+	mu    sync.Mutex
+	steps []PlanStep
+}
+
+func NewPlan() *Plan {
+	return &Plan{}
+}
+
+// Len returns the number of queued steps.
+func (p *Plan) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.steps)
+}
+
+// Take returns the queued steps and empties the plan.
+func (p *Plan) Take() []PlanStep {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	steps := p.steps
+	p.steps = nil
+	return steps
+}
+
+func (p *Plan) add(step PlanStep) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.steps = append(p.steps, step)
+	return len(p.steps)
+}
+
+// SetPlan turns plan mode on, queueing mutating calls in p, or off when p is
+// nil.
+func (r *Registry) SetPlan(p *Plan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.plan = p
+}
+
+// Plan returns the active plan, or nil outside plan mode.
+func (r *Registry) Plan() *Plan {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.plan
+}
+
+// IsMutating reports whether a call can change files or other state, and is
+// therefore queued in plan mode: the file-changing builtins, execute_command,
+// http_request with methods other than GET, HEAD and OPTIONS, custom tools not
+// marked read-only and destructive MCP tools.
+func (r *Registry) IsMutating(name string, args map[string]any) bool {
+	switch name {
+	case "write_file", "edit_file", "apply_patch", "execute_command":
+		return true
+	case "http_request":
+		method, _ := args["method"].(string)
+		return method != "" && !httpSafeMethods[strings.ToUpper(strings.TrimSpace(method))]
+	}
+	meta, ok := r.Meta(name)
+	if !ok {
+		return false
+	}
+	if meta.Source == "custom" {
+		return !meta.ReadOnly
+	}
+	return meta.Destructive
+}
+
+// queueStep adds a call to the plan and returns the result the model gets in
+// place of the tool output.
+func (r *Registry) queueStep(plan *Plan, name string, args map[string]any, argsJSON string) string {
+	n := plan.add(PlanStep{Tool: name, Arguments: argsJSON, Preview: r.planPreview(name, args, argsJSON)})
+	return fmt.Sprintf("Queued as step %d of the plan. Plan mode is on, so the call was not executed: "+
+		"the user reviews and applies the plan after your reply. Continue as if it succeeded, "+
+		"but do not rely on any output from it.", n)
+}
+
+// ApplyPlanStep runs a reviewed step. Applying the plan approves its steps,
+// except high-risk commands, which are confirmed one by one as outside plan
+// mode; declining one fails the step. A command that does not finish
+// successfully in the foreground fails the step too, so that the steps after
+// it are not applied blindly. A CommandStatus from WithCommandStatus in ctx
+// receives how the command ended.
+func (r *Registry) ApplyPlanStep(ctx context.Context, step PlanStep) (string, error) {
+	if step.Tool == "execute_command" {
+		var args map[string]any
+		if err := json.Unmarshal([]byte(step.Arguments), &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+		cmdStr, _ := args["command"].(string)
+		if risks := AnalyzeCommand(cmdStr); len(risks) > 0 {
+			prompt, cancelMsg := r.confirmPrompt(step.Tool, args, step.Arguments)
+			d, err := confirmRisky("\n"+FormatCommandRisks(risks)+prompt, cancelMsg)
+			if err != nil {
+				return "", err
+			}
+			if !d.Proceed {
+				return d.Message, fmt.Errorf("high-risk command declined")
+			}
+		}
+	}
+
+	status, ok := ctx.Value(commandStatusKey).(*CommandStatus)
+	if !ok {
+		ctx, status = WithCommandStatus(ctx)
+	}
+	ctx = context.WithValue(ctx, planApplyKey, true)
+	ctx = context.WithValue(ctx, ConfirmedKey, true)
+	out, err := r.ExecuteWithTimeout(ctx, step.Tool, step.Arguments)
+	if err == nil {
+		err = status.Err
+	}
+	return out, err
+}
+
+// planPreview describes what a queued call would do: a diff for file
+// changes, the command line for commands and the arguments otherwise.
+func (r *Registry) planPreview(name string, args map[string]any, argsJSON string) string {
+	path, _ := args["path"].(string)
+	switch name {
+	case "write_file":
+		content, _ := args["content"].(string)
+		old := ""
+		if resolved, err := r.resolvePath(path); err == nil {
+			if data, err := os.ReadFile(resolved); err == nil {
+				old = string(data)
+			}
+		}
+		if appendMode, _ := args["append"].(bool); appendMode {
+			content = old + content
+		}
+		return renderFileDiff(path, old, content)
+	case "edit_file":
+		oldContent, _ := args["old_content"].(string)
+		newContent, _ := args["new_content"].(string)
+		if resolved, err := r.resolvePath(path); err == nil {
+			if data, err := os.ReadFile(resolved); err == nil && oldContent != "" && strings.Count(string(data), oldContent) == 1 {
+				return renderFileDiff(path, string(data), strings.Replace(string(data), oldContent, newContent, 1))
+			}
+		}
+		// The edit would fail as it stands; show the replacement itself.
+		return renderFileDiff(path, oldContent, newContent)
+	case "apply_patch":
+		patch, _ := args["patch"].(string)
+		if files, err := r.parsePatch(patch); err == nil {
+			return strings.TrimRight(renderPatchPreview(files), "\n")
+		}
+		return patch
+	case "execute_command":
+		cmd, _ := args["command"].(string)
+		if risks := AnalyzeCommand(cmd); len(risks) > 0 {
+			return "$ " + cmd + "\n" + FormatCommandRisks(risks)
+		}
+		return "$ " + cmd
+	case "http_request":
+		if call, err := r.parseHTTPCall(args); err == nil {
+			return strings.TrimPrefix(strings.TrimSuffix(httpConfirmPrompt(call), "\nSend this HTTP request?"), "\n")
+		}
+	}
+	var pretty strings.Builder
+	var v any
+	if json.Unmarshal([]byte(argsJSON), &v) == nil {
+		if data, err := json.MarshalIndent(v, "", "  "); err == nil {
+			pretty.Write(data)
+		}
+	}
+	if pretty.Len() == 0 {
+		pretty.WriteString(argsJSON)
+	}
+	return fmt.Sprintf("%s %s", name, pretty.String())
+}
+
+// renderFileDiff returns a coloured unified diff between two versions of a
+// file.
+func renderFileDiff(path, old, updated string) string {
+	from := "a/" + path
+	if old == "" {
+		from = "/dev/null"
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(old),
+		B:        difflib.SplitLines(updated),
+		FromFile: from,
+		ToFile:   "b/" + path,
+		Context:  3,
+	})
+	if err != nil || diff == "" {
+		return bold.Sprintf("%s (no changes)", path)
+	}
+
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			b.WriteString(bold.Sprint(line))
+		case strings.HasPrefix(line, "@@"):
+			b.WriteString(diffHunk.Sprint(line))
+		case strings.HasPrefix(line, "+"):
+			b.WriteString(diffAdded.Sprint(line))
+		case strings.HasPrefix(line, "-"):
+			b.WriteString(diffRemoved.Sprint(line))
+		default:
+			b.WriteString(line)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,186 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanModeQueuesMutatingCalls(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "write_file", "edit_file", "read_file", "execute_command")
+	r.SetPermissions(&Permissions{})
+	r.SetPlan(NewPlan())
+	writeTestFile(t, dir, "notes.txt", "one\ntwo\n")
+	ctx := context.Background()
+
+	editArgs := `{"path": "notes.txt", "old_content": "two", "new_content": "2"}`
+	if d, _ := r.Confirm(ctx, "edit_file", editArgs); !d.Proceed || d.Outcome != OutcomePlanned {
+		t.Errorf("expected edit_file to be planned without asking, got %+v", d)
+	}
+	if d, _ := r.Confirm(ctx, "read_file", `{"path": "notes.txt"}`); d.Outcome != OutcomeNotRequired {
+		t.Errorf("expected read_file to run as usual, got %+v", d)
+	}
+
+	out, err := r.Execute(ctx, "edit_file", editArgs)
+	if err != nil || !strings.HasPrefix(out, "Queued as step 1 of the plan") {
+		t.Fatalf("expected the edit to be queued, got %q (%v)", out, err)
+	}
+	out, err = r.Execute(ctx, "execute_command", `{"command": "echo applied"}`)
+	if err != nil || !strings.HasPrefix(out, "Queued as step 2 of the plan") {
+		t.Fatalf("expected the command to be queued, got %q (%v)", out, err)
+	}
+	if out, err := r.Execute(ctx, "read_file", `{"path": "notes.txt"}`); err != nil || !strings.Contains(out, "two") {
+		t.Errorf("expected read_file to run and see the unchanged file, got %q (%v)", out, err)
+	}
+	if got := readTestFile(t, dir, "notes.txt"); got != "one\ntwo\n" {
+		t.Errorf("expected nothing to change before the plan is applied, got %q", got)
+	}
+
+	steps := r.Plan().Take()
+	if len(steps) != 2 || r.Plan().Len() != 0 {
+		t.Fatalf("expected 2 steps and an empty plan afterwards, got %+v", steps)
+	}
+	if !strings.Contains(steps[0].Preview, "-two") || !strings.Contains(steps[0].Preview, "+2") || !strings.Contains(steps[0].Preview, "b/notes.txt") {
+		t.Errorf("expected a diff preview, got %q", steps[0].Preview)
+	}
+	if steps[1].Preview != "$ echo applied" {
+		t.Errorf("unexpected command preview %q", steps[1].Preview)
+	}
+
+	for _, step := range steps {
+		if _, err := r.ApplyPlanStep(ctx, step); err != nil {
+			t.Fatalf("failed to apply %s: %v", step.Tool, err)
+		}
+	}
+	if got := readTestFile(t, dir, "notes.txt"); got != "one\n2\n" {
+		t.Errorf("expected the edit to be applied, got %q", got)
+	}
+	if r.Plan().Len() != 0 {
+		t.Error("expected applied steps not to be queued again")
+	}
+}
+
+func TestPlanModeWriteFilePreviewAndFailedCommand(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "write_file", "edit_file", "read_file", "execute_command")
+	r.SetPermissions(&Permissions{})
+	r.SetPlan(NewPlan())
+	ctx := context.Background()
+
+	if _, err := r.Execute(ctx, "write_file", `{"path": "sub/new.txt", "content": "hello\n"}`); err != nil {
+		t.Fatal(err)
+	}
+	steps := r.Plan().Take()
+	if len(steps) != 1 || !strings.Contains(steps[0].Preview, "/dev/null") || !strings.Contains(steps[0].Preview, "+hello") {
+		t.Fatalf("expected a new-file diff, got %+v", steps)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub")); !os.IsNotExist(err) {
+		t.Error("expected write_file not to run in plan mode")
+	}
+
+	if _, err := r.ApplyPlanStep(ctx, PlanStep{Tool: "execute_command", Arguments: `{"command": "exit 3"}`}); err == nil {
+		t.Error("expected a failing command to fail its step")
+	}
+}
+
+func TestApplyPlanStepUsesExitState(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX shell commands")
+	}
+	r, _ := newWorkspaceRegistry(t, nil, nil, "write_file", "edit_file", "read_file", "execute_command")
+	r.SetPermissions(&Permissions{})
+	r.SetPlan(NewPlan())
+	r.SetLimits(ToolLimits{Timeout: 200 * time.Millisecond})
+	t.Cleanup(r.StopCommands)
+	ctx := context.Background()
+	apply := func(command string) error {
+		_, err := r.ApplyPlanStep(ctx, PlanStep{Tool: "execute_command", Arguments: `{"command": "` + command + `"}`})
+		return err
+	}
+
+	if err := apply("echo Command failed with error: exit status 1"); err != nil {
+		t.Errorf("expected a command that succeeds to pass whatever it prints, got %v", err)
+	}
+	if err := apply("kill -9 $$"); err == nil {
+		t.Error("expected a command killed by a signal to fail its step")
+	}
+	if err := apply("sleep 5"); err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Errorf("expected a command moved to the background to fail its step, got %v", err)
+	}
+	t.Setenv("PATH", t.TempDir())
+	if err := apply("true"); err == nil || !strings.Contains(err.Error(), "failed to start") {
+		t.Errorf("expected a command that cannot start to fail its step, got %v", err)
+	}
+}
+
+func TestApplyPlanStepConfirmsHighRiskCommands(t *testing.T) {
+	r, dir := newWorkspaceRegistry(t, nil, nil, "write_file", "edit_file", "read_file", "execute_command")
+	r.SetPermissions(&Permissions{})
+	r.SetPlan(NewPlan())
+	writeTestFile(t, dir, "log.txt", "keep\n")
+	t.Chdir(dir)
+	ctx := context.Background()
+	step := PlanStep{Tool: "execute_command", Arguments: `{"command": "echo replaced > log.txt"}`}
+
+	calls := stubPermissionMenu(t, 1)
+	if _, err := r.ApplyPlanStep(ctx, step); err == nil || *calls != 1 {
+		t.Errorf("expected a declined high-risk step to fail, got %v after %d prompts", err, *calls)
+	}
+	if got := readTestFile(t, dir, "log.txt"); got != "keep\n" {
+		t.Errorf("expected the declined command not to run, got %q", got)
+	}
+
+	stubPermissionMenu(t, 0)
+	if _, err := r.ApplyPlanStep(ctx, step); err != nil {
+		t.Errorf("expected a confirmed high-risk step to run, got %v", err)
+	}
+	if got := readTestFile(t, dir, "log.txt"); got != "replaced\n" {
+		t.Errorf("expected the confirmed command to run, got %q", got)
+	}
+}
+
+func TestPlanModeKeepsDenyRules(t *testing.T) {
+	r, _ := newWorkspaceRegistry(t, nil, nil, "write_file", "edit_file", "read_file", "execute_command")
+	r.SetPermissions(&Permissions{})
+	r.SetPlan(NewPlan())
+	r.Permissions().AddSession(PermissionRule{Tool: "execute_command", Pattern: "rm *", Action: "deny"})
+
+	d, err := r.Confirm(context.Background(), "execute_command", `{"command": "rm -rf build"}`)
+	if err != nil || d.Proceed || d.Outcome != OutcomeRuleDeny {
+		t.Errorf("expected deny rules to apply in plan mode, got %+v (%v)", d, err)
+	}
+}
+
+func TestIsMutating(t *testing.T) {
+	r := NewRegistry()
+	spec := func(name string) ToolSpec { return ToolSpec{Type: "function", Function: FunctionSpec{Name: name}} }
+	r.RegisterWithMeta(spec("deploy"), ToolMeta{Source: "custom"}, nil)
+	r.RegisterWithMeta(spec("word_count"), ToolMeta{Source: "custom", ReadOnly: true}, nil)
+	r.RegisterWithMeta(spec("delete_issue"), ToolMeta{Source: "github", Destructive: true}, nil)
+	r.RegisterWithMeta(spec("add_comment"), ToolMeta{Source: "github"}, nil)
+
+	tests := []struct {
+		name string
+		args map[string]any
+		want bool
+	}{
+		{"write_file", nil, true},
+		{"apply_patch", nil, true},
+		{"read_file", nil, false},
+		{"http_request", map[string]any{"url": "https://api.example.com"}, false},
+		{"http_request", map[string]any{"method": "get", "url": "https://api.example.com"}, false},
+		{"http_request", map[string]any{"method": "POST", "url": "https://api.example.com"}, true},
+		{"deploy", nil, true},
+		{"word_count", nil, false},
+		{"delete_issue", nil, true},
+		{"add_comment", nil, false},
+	}
+	for _, tt := range tests {
+		if got := r.IsMutating(tt.name, tt.args); got != tt.want {
+			t.Errorf("IsMutating(%s, %v) = %v, want %v", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
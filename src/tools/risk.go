@@ -0,0 +1,549 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type shellRedirect struct {
+	op     string // ">", ">>", ">|", "&>", "<" ...
+	target string
+}
+
+// simpleCommand is one command of a shell line: its words with quotes
+// removed, its redirections, the commands substituted into it with $(...),
+// `...` or <(...), and the command piping into it, if any.
+type simpleCommand struct {
+	words     []string
+	redirects []shellRedirect
+	nested    []string
+	pipedFrom *simpleCommand
+}
+
+// forkBombRe matches the classic `:(){ :|:& };:` and its variants.
+var forkBombRe = regexp.MustCompile(`(\w+|:)\(\)\s*\{\s*(\w+|:)\s*\|\s*(\w+|:)\s*&\s*\}\s*;`)
+
+// broadSystemDirs are the top-level directories whose loss breaks the system.
+var broadSystemDirs = map[string]bool{
+	"/": true, "/bin": true, "/boot": true, "/dev": true, "/etc": true, "/home": true, "/lib": true,
+	"/lib64": true, "/opt": true, "/proc": true, "/root": true, "/sbin": true, "/srv": true, "/sys": true,
+	"/usr": true, "/var": true, "/Users": true, "/System": true, "/Applications": true, "/Library": true,
+}
+
+// wrapperOptionsWithValue are the options of sudo, nice, timeout, xargs and
+// the like that take the next argument as their value.
+var wrapperOptionsWithValue = map[string]bool{
+	"-u": true, "-g": true, "-n": true, "-C": true, "-s": true, "-I": true, "-P": true, "-L": true,
+}
+
+// shellInterpreters run code read from stdin or passed with -c.
+var shellInterpreters = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true, "fish": true,
+	"python": true, "python3": true, "perl": true, "ruby": true, "node": true,
+	"pwsh": true, "powershell": true,
+}
+
+// AnalyzeCommand looks for destructive patterns in a shell command line and
+// returns a reason for each one found. A command with reasons is high-risk:
+// it always needs explicit confirmation, even with -y.
+func AnalyzeCommand(command string) []string {
+	var risks []string
+	seen := make(map[string]bool)
+	add := func(reason string) {
+		if !seen[reason] {
+			seen[reason] = true
+			risks = append(risks, reason)
+		}
+	}
+	analyzeCommandLine(command, add, 0)
+	return risks
+}
+
+func analyzeCommandLine(line string, add func(string), depth int) {
+	if depth > 4 {
+		return
+	}
+	if forkBombRe.MatchString(line) {
+		add("fork bomb: starts processes until the system runs out of resources")
+	}
+	for _, cmd := range parseShellLine(line) {
+		analyzeSimpleCommand(cmd, add, depth)
+		for _, n := range cmd.nested {
+			analyzeCommandLine(n, add, depth+1)
+		}
+	}
+}
+
+func analyzeSimpleCommand(cmd *simpleCommand, add func(string), depth int) {
+	for _, r := range cmd.redirects {
+		analyzeRedirect(r, add)
+	}
+
+	args := unwrapCommand(cmd.words)
+	if len(args) == 0 {
+		return
+	}
+	name := path.Base(filepath.ToSlash(args[0]))
+	args = args[1:]
+
+	switch {
+	case name == "rm":
+		flags, targets := splitFlags(args)
+		if hasShortFlag(flags, 'r', 'R') || hasLongFlag(flags, "--recursive") {
+			for _, t := range targets {
+				if isBroadPath(t) {
+					add(fmt.Sprintf("rm -r on a broad path: %s", t))
+				}
+			}
+		}
+		if hasLongFlag(flags, "--no-preserve-root") {
+			add("rm --no-preserve-root can delete the whole filesystem")
+		}
+	case name == "chmod" || name == "chown" || name == "chgrp":
+		flags, targets := splitFlags(args)
+		if hasShortFlag(flags, 'R') || hasLongFlag(flags, "--recursive") {
+			for _, t := range targets {
+				if isBroadPath(t) {
+					add(fmt.Sprintf("%s -R on a broad path: %s", name, t))
+				}
+			}
+		}
+	case name == "dd":
+		for _, a := range args {
+			if of, ok := strings.CutPrefix(a, "of="); ok && of != "/dev/null" {
+				add(fmt.Sprintf("dd overwrites %s", of))
+			}
+		}
+	case name == "mkfs" || strings.HasPrefix(name, "mkfs.") || name == "mke2fs" || name == "mkswap" || name == "wipefs":
+		add(fmt.Sprintf("%s formats or wipes a disk or partition", name))
+	case name == "shred":
+		add("shred destroys file contents beyond recovery")
+	case name == "shutdown" || name == "reboot" || name == "halt" || name == "poweroff":
+		add(fmt.Sprintf("%s stops or restarts the machine", name))
+	case name == "git":
+		analyzeGit(args, add)
+	case shellInterpreters[name]:
+		if downloads(cmd.pipedFrom) {
+			add(fmt.Sprintf("pipes a download into %s, running code that was not reviewed", name))
+		}
+		for _, n := range cmd.nested {
+			if nestedDownloads(n) {
+				add(fmt.Sprintf("runs a download with %s, running code that was not reviewed", name))
+			}
+		}
+		for i, a := range args {
+			if a == "-c" && i+1 < len(args) {
+				analyzeCommandLine(args[i+1], add, depth+1)
+				break
+			}
+		}
+	case name == "eval":
+		analyzeCommandLine(strings.Join(args, " "), add, depth+1)
+	}
+}
+
+func analyzeGit(args []string, add func(string)) {
+	// Skip global options such as -C dir.
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if (args[0] == "-C" || args[0] == "-c") && len(args) > 1 {
+			args = args[1:]
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return
+	}
+	sub, rest := args[0], args[1:]
+	flags, targets := splitFlags(rest)
+	switch sub {
+	case "push":
+		force := hasShortFlag(flags, 'f') || hasLongFlag(flags, "--force", "--force-with-lease", "--mirror")
+		for _, t := range targets {
+			if strings.HasPrefix(t, "+") {
+				force = true
+			}
+		}
+		if force {
+			add("git force push can overwrite commits on the remote")
+		}
+	case "reset":
+		if hasLongFlag(flags, "--hard") {
+			add("git reset --hard discards uncommitted changes")
+		}
+	case "clean":
+		if hasShortFlag(flags, 'f') || hasLongFlag(flags, "--force") {
+			add("git clean -f deletes untracked files")
+		}
+	}
+}
+
+func analyzeRedirect(r shellRedirect, add func(string)) {
+	switch r.op {
+	case ">", ">|", "&>", ">&":
+	default:
+		return
+	}
+	target := r.target
+	if target == "" || strings.HasPrefix(target, "&") {
+		return
+	}
+	if strings.HasPrefix(target, "/dev/") {
+		switch target {
+		case "/dev/null", "/dev/stdout", "/dev/stderr", "/dev/tty":
+			return
+		}
+		add(fmt.Sprintf("writes directly to the device %s", target))
+		return
+	}
+	if info, err := os.Stat(expandHome(target)); err == nil && info.Mode().IsRegular() {
+		add(fmt.Sprintf("redirection overwrites the existing file %s", target))
+	}
+}
+
+// downloads reports whether cmd fetches something from the network.
+func downloads(cmd *simpleCommand) bool {
+	for ; cmd != nil; cmd = cmd.pipedFrom {
+		if args := unwrapCommand(cmd.words); len(args) > 0 {
+			switch path.Base(filepath.ToSlash(args[0])) {
+			case "curl", "wget", "fetch", "iwr", "Invoke-WebRequest", "irm", "Invoke-RestMethod":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func nestedDownloads(line string) bool {
+	for _, cmd := range parseShellLine(line) {
+		if downloads(cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+// unwrapCommand drops environment assignments and commands that run
+// another command (sudo, env, nohup ...) from the start of args.
+func unwrapCommand(args []string) []string {
+	for len(args) > 0 {
+		a := args[0]
+		if i := strings.IndexByte(a, '='); i > 0 && !strings.ContainsAny(a[:i], "/-") {
+			args = args[1:]
+			continue
+		}
+		switch path.Base(a) {
+		case "sudo", "doas", "env", "nohup", "time", "nice", "command", "exec", "xargs", "timeout", "stdbuf", "ionice", "builtin":
+			args = args[1:]
+			for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+				if wrapperOptionsWithValue[args[0]] && len(args) > 1 {
+					args = args[1:]
+				}
+				args = args[1:]
+			}
+			if path.Base(a) == "timeout" && len(args) > 0 {
+				args = args[1:] // the duration
+			}
+		default:
+			return args
+		}
+	}
+	return args
+}
+
+// splitFlags separates options from operands; everything after "--" is an
+// operand.
+func splitFlags(args []string) (flags, operands []string) {
+	for i, a := range args {
+		if a == "--" {
+			return flags, append(operands, args[i+1:]...)
+		}
+		if strings.HasPrefix(a, "-") && a != "-" {
+			flags = append(flags, a)
+		} else {
+			operands = append(operands, a)
+		}
+	}
+	return flags, operands
+}
+
+func hasShortFlag(flags []string, letters ...rune) bool {
+	for _, f := range flags {
+		if strings.HasPrefix(f, "--") {
+			continue
+		}
+		for _, l := range letters {
+			if strings.ContainsRune(f[1:], l) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasLongFlag(flags []string, names ...string) bool {
+	for _, f := range flags {
+		f, _, _ = strings.Cut(f, "=")
+		for _, n := range names {
+			if f == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBroadPath reports whether removing or changing path recursively would
+// hit far more than intended: the filesystem root, a top-level system
+// directory, the home directory, the current or parent directory, or a bare
+// wildcard.
+func isBroadPath(p string) bool {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return false
+	}
+	for _, home := range []string{"~", "$HOME", "${HOME}"} {
+		if rest, ok := strings.CutPrefix(p, home); ok && (rest == "" || rest == "/" || rest == "/*" || rest == "/.*") {
+			return true
+		}
+	}
+	switch p {
+	case ".", "./", "..", "../", "*", ".*", "./*", "../*":
+		return true
+	}
+	if !strings.HasPrefix(p, "/") {
+		return false
+	}
+	clean := path.Clean(strings.TrimSuffix(p, "*"))
+	if broadSystemDirs[clean] {
+		return true
+	}
+	// A user's home directory, e.g. /home/alice or /Users/alice.
+	dir := path.Dir(clean)
+	if dir == "/home" || dir == "/Users" {
+		return true
+	}
+	return clean == path.Clean(filepath.ToSlash(homeDir()))
+}
+
+func homeDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return "/nonexistent"
+	}
+	return home
+}
+
+func expandHome(p string) string {
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		return filepath.Join(homeDir(), rest)
+	}
+	return p
+}
+
+// FormatCommandRisks turns the reasons from AnalyzeCommand into a warning
+// shown before asking for confirmation.
+func FormatCommandRisks(risks []string) string {
+	var b strings.Builder
+	b.WriteString("⚠ High-risk command:")
+	for _, r := range risks {
+		b.WriteString("\n  - " + r)
+	}
+	return b.String()
+}
+
+// parseShellLine splits a shell command line into simple commands, removing
+// quotes and collecting redirections and command substitutions. It is not a
+// full shell parser, but it follows quoting well enough that operators and
+// redirections inside quotes are not mistaken for real ones.
+func parseShellLine(line string) []*simpleCommand {
+	var (
+		cmds     []*simpleCommand
+		cur      = &simpleCommand{}
+		word     strings.Builder
+		inWord   bool
+		redirect string // operator waiting for its target
+	)
+
+	flushWord := func() {
+		if !inWord {
+			return
+		}
+		if redirect != "" {
+			cur.redirects = append(cur.redirects, shellRedirect{op: redirect, target: word.String()})
+			redirect = ""
+		} else {
+			cur.words = append(cur.words, word.String())
+		}
+		word.Reset()
+		inWord = false
+	}
+	endCommand := func(piped bool) {
+		flushWord()
+		redirect = ""
+		var prev *simpleCommand
+		if len(cur.words) > 0 || len(cur.redirects) > 0 || len(cur.nested) > 0 {
+			cmds = append(cmds, cur)
+			prev = cur
+		}
+		cur = &simpleCommand{}
+		if piped {
+			cur.pipedFrom = prev
+		}
+	}
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\\' && i+1 < len(runes):
+			i++
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+		case c == '\'':
+			end := indexRune(runes, i+1, '\'')
+			word.WriteString(string(runes[i+1 : end]))
+			inWord = true
+			i = end
+		case c == '"':
+			inWord = true
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				switch {
+				case runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1]):
+					i++
+					word.WriteRune(runes[i])
+				case runes[i] == '$' && i+1 < len(runes) && runes[i+1] == '(':
+					end := matchParen(runes, i+1)
+					cur.nested = append(cur.nested, string(runes[i+2:end]))
+					word.WriteString(string(runes[i:min(end+1, len(runes))]))
+					i = end
+				case runes[i] == '`':
+					end := indexRune(runes, i+1, '`')
+					cur.nested = append(cur.nested, string(runes[i+1:end]))
+					i = end
+				default:
+					word.WriteRune(runes[i])
+				}
+			}
+		case c == '$' && i+1 < len(runes) && runes[i+1] == '(',
+			(c == '<' || c == '>') && i+1 < len(runes) && runes[i+1] == '(' && !inWord:
+			end := matchParen(runes, i+1)
+			cur.nested = append(cur.nested, string(runes[i+2:end]))
+			word.WriteString(string(runes[i:min(end+1, len(runes))]))
+			inWord = true
+			i = end
+		case c == '`':
+			end := indexRune(runes, i+1, '`')
+			cur.nested = append(cur.nested, string(runes[i+1:end]))
+			inWord = true
+			i = end
+		case c == '#' && !inWord:
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			endCommand(false)
+		case c == ' ' || c == '\t':
+			flushWord()
+		case c == '\n' || c == ';' || c == '(' || c == ')' || c == '{' && !inWord || c == '}' && !inWord:
+			endCommand(false)
+		case c == '|':
+			if i+1 < len(runes) && runes[i+1] == '|' {
+				i++
+				endCommand(false)
+			} else {
+				if i+1 < len(runes) && runes[i+1] == '&' {
+					i++
+				}
+				endCommand(true)
+			}
+		case c == '&':
+			if i+1 < len(runes) && runes[i+1] == '>' {
+				flushWord()
+				op := "&>"
+				i++
+				if i+1 < len(runes) && runes[i+1] == '>' {
+					op = "&>>"
+					i++
+				}
+				redirect = op
+			} else {
+				if i+1 < len(runes) && runes[i+1] == '&' {
+					i++
+				}
+				endCommand(false)
+			}
+		case c == '>' || c == '<':
+			// A word of digits right before the operator is a file
+			// descriptor, not an argument.
+			if inWord && isDigits(word.String()) {
+				word.Reset()
+				inWord = false
+			} else {
+				flushWord()
+			}
+			op := string(c)
+			if i+1 < len(runes) && (runes[i+1] == '>' || runes[i+1] == '|' || runes[i+1] == '&' || runes[i+1] == '<') {
+				op += string(runes[i+1])
+				i++
+			}
+			if op == ">&" && i+1 < len(runes) && (runes[i+1] == '-' || runes[i+1] >= '0' && runes[i+1] <= '9') {
+				// Duplicates a descriptor (2>&1); the target is not a file.
+				op = "&"
+			}
+			redirect = op
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	endCommand(false)
+	return cmds
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return len(runes)
+}
+
+// matchParen returns the index of the parenthesis closing the one at open,
+// or the end of input if it is never closed.
+func matchParen(runes []rune, open int) int {
+	depth := 0
+	for i := open; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			i++
+		case '\'':
+			i = indexRune(runes, i+1, '\'')
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(runes)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,299 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits used by --sandbox unless the spec overrides them.
+const (
+	DefaultSandboxMemory = 2 << 30 // bytes of virtual memory
+	DefaultSandboxCPU    = 2 * time.Minute
+	DefaultSandboxTime   = 10 * time.Minute
+)
+
+// sandboxKillGrace is how long a command that hit the time limit has to exit
+// after SIGTERM before it is killed.
+const sandboxKillGrace = 5
+
+// Sandbox runs commands in Linux namespaces with a read-only root, a
+// writable workdir, a private /tmp, no network unless allowed, and limits on
+// memory, CPU time and wall-clock time. It uses bubblewrap when installed and
+// unshare otherwise.
+type Sandbox struct {
+	Backend string // "bwrap" or "unshare"
+	Workdir string
+	Network bool
+	Memory  int64         // bytes of virtual memory, 0 for no limit
+	CPU     time.Duration // CPU time, 0 for no limit
+	Time    time.Duration // wall-clock time, 0 for no limit
+
+	binary string
+}
+
+// ParseSandbox parses a --sandbox value: a comma-separated list of "net" to
+// allow network access, "memory=" (with a K, M or G suffix), "cpu=" and
+// "time=" limits ("none" to drop one), "workdir=" and "backend=". An empty
+// spec, "true" or "1" gives the defaults. The workdir defaults to workdir,
+// or the current directory if that is empty.
+func ParseSandbox(spec, workdir string) (*Sandbox, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("--sandbox needs Linux (bubblewrap or unshare)")
+	}
+	s := &Sandbox{
+		Workdir: workdir,
+		Memory:  DefaultSandboxMemory,
+		CPU:     DefaultSandboxCPU,
+		Time:    DefaultSandboxTime,
+	}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "true" || part == "1" {
+			continue
+		}
+		if part == "net" || part == "network" {
+			s.Network = true
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid sandbox option %q", part)
+		}
+		value = strings.TrimSpace(value)
+		var err error
+		switch strings.TrimSpace(key) {
+		case "memory", "mem":
+			s.Memory, err = parseMemory(value)
+		case "cpu":
+			s.CPU, err = parseLimit(value)
+		case "time":
+			s.Time, err = parseLimit(value)
+		case "workdir":
+			s.Workdir = value
+		case "backend":
+			s.Backend = value
+		default:
+			return nil, fmt.Errorf("unknown sandbox option %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sandbox option %q: %w", part, err)
+		}
+	}
+
+	if s.Workdir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		s.Workdir = wd
+	}
+	abs, err := filepath.Abs(s.Workdir)
+	if err != nil {
+		return nil, err
+	}
+	if s.Workdir, err = filepath.EvalSymlinks(abs); err != nil {
+		return nil, fmt.Errorf("sandbox workdir: %w", err)
+	}
+
+	switch s.Backend {
+	case "":
+		for _, backend := range []string{"bwrap", "unshare"} {
+			if path, err := exec.LookPath(backend); err == nil {
+				s.Backend, s.binary = backend, path
+				break
+			}
+		}
+		if s.Backend == "" {
+			return nil, fmt.Errorf("--sandbox needs bubblewrap (bwrap) or unshare from util-linux")
+		}
+	case "bwrap", "unshare":
+		if s.binary, err = exec.LookPath(s.Backend); err != nil {
+			return nil, fmt.Errorf("sandbox backend %s: %w", s.Backend, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown sandbox backend %q, use bwrap or unshare", s.Backend)
+	}
+	if s.Time > 0 {
+		if _, err := exec.LookPath("timeout"); err != nil {
+			return nil, fmt.Errorf("the sandbox time limit needs timeout from coreutils (use time=none to drop it)")
+		}
+	}
+	return s, nil
+}
+
+func parseLimit(s string) (time.Duration, error) {
+	if s == "none" || s == "0" {
+		return 0, nil
+	}
+	return parseTimeout(s)
+}
+
+func parseMemory(s string) (int64, error) {
+	if s == "none" || s == "0" {
+		return 0, nil
+	}
+	if s == "" {
+		return 0, fmt.Errorf("must be a positive size such as 512M or 2G")
+	}
+	unit := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		unit = 1 << 10
+	case "M":
+		unit = 1 << 20
+	case "G":
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("must be a positive size such as 512M or 2G")
+	}
+	return n * unit, nil
+}
+
+// Describe lists the restrictions the sandbox applies, for the user and the
+// model.
+func (s *Sandbox) Describe() string {
+	parts := []string{"read-only root", "writable " + s.Workdir, "private /tmp"}
+	if s.Network {
+		parts = append(parts, "network allowed")
+	} else {
+		parts = append(parts, "no network")
+	}
+	if s.Memory > 0 {
+		parts = append(parts, "memory "+formatMemory(s.Memory))
+	}
+	if s.CPU > 0 {
+		parts = append(parts, "CPU time "+s.CPU.String())
+	}
+	if s.Time > 0 {
+		parts = append(parts, "time limit "+s.Time.String())
+	}
+	return fmt.Sprintf("Sandbox (%s): %s", s.Backend, strings.Join(parts, ", "))
+}
+
+func formatMemory(n int64) string {
+	switch {
+	case n%(1<<30) == 0:
+		return fmt.Sprintf("%dG", n>>30)
+	case n%(1<<20) == 0:
+		return fmt.Sprintf("%dM", n>>20)
+	case n%(1<<10) == 0:
+		return fmt.Sprintf("%dK", n>>10)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// unsharePrelude sets up the mounts for the unshare backend: the workdir,
+// held open while /tmp is replaced, is bound back writable, and every other
+// mount is made read-only. Its arguments are the workdir, the directory to
+// start in, the unshare binary and the command.
+const unsharePrelude = `set -e
+W=$1 D=$2 U=$3
+shift 3
+exec 3<"$W"
+mount -t proc proc /proc
+mount -t tmpfs tmpfs /tmp
+mkdir -p "$W" 2>/dev/null || true
+mount --no-canonicalize --bind /proc/self/fd/3 "$W"
+exec 3<&-
+mount -o remount,bind,ro /
+while read -r _ m _; do
+  case $m in
+  / | "$W" | /proc | /proc/* | /dev | /dev/* | /tmp) ;;
+  *) mount -o remount,bind,ro "$m" 2>/dev/null || true ;;
+  esac
+done </proc/self/mounts
+set +e
+cd "$D" 2>/dev/null || cd "$W"
+`
+
+// unshareDropPrivileges runs the command as the user who started tgpt, in a
+// user namespace nested in the one that did the mount setup. That drops the
+// capabilities the setup needed and locks the mounts, so the command cannot
+// remount the root writable.
+func unshareDropPrivileges() string {
+	return fmt.Sprintf(`"$U" --user --map-user=%d --map-group=%d -- `, os.Getuid(), os.Getgid())
+}
+
+// limitsPrelude applies the memory and CPU limits and runs "$@" under the
+// time limit, through prefix if it is set.
+func (s *Sandbox) limitsPrelude(prefix string) string {
+	var b strings.Builder
+	if s.Memory > 0 {
+		fmt.Fprintf(&b, "ulimit -v %d || exit 126\n", s.Memory>>10)
+	}
+	if s.CPU > 0 {
+		fmt.Fprintf(&b, "ulimit -t %d || exit 126\n", int64(s.CPU.Seconds()))
+	}
+	if s.Time > 0 {
+		// --foreground keeps the command in the terminal's process group;
+		// the rest of the sandbox goes when its first process exits.
+		fmt.Fprintf(&b, "exec %stimeout --foreground -k %d %d \"$@\"\n", prefix, sandboxKillGrace, int64(s.Time.Seconds()))
+	} else {
+		fmt.Fprintf(&b, "exec %s\"$@\"\n", prefix)
+	}
+	return b.String()
+}
+
+// Wrap changes cmd to run inside the sandbox. It starts in cmd.Dir or the
+// current directory, which is read-only unless it is inside the workdir.
+func (s *Sandbox) Wrap(cmd *exec.Cmd) error {
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	dir := cmd.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	if dir == "" {
+		dir = s.Workdir
+	}
+	inner := append([]string{cmd.Path}, cmd.Args[1:]...)
+
+	var args []string
+	switch s.Backend {
+	case "bwrap":
+		args = []string{"--ro-bind", "/", "/", "--dev", "/dev", "--proc", "/proc", "--tmpfs", "/tmp",
+			"--bind", s.Workdir, s.Workdir, "--chdir", dir,
+			"--unshare-all", "--die-with-parent", "--new-session"}
+		if s.Network {
+			args = append(args, "--share-net")
+		}
+		args = append(args, "--", "/bin/sh", "-c", s.limitsPrelude(""), "tgpt-sandbox")
+	default:
+		args = []string{"--user", "--map-root-user", "--mount", "--pid", "--fork", "--kill-child", "--ipc", "--uts"}
+		if !s.Network {
+			args = append(args, "--net")
+		}
+		args = append(args, "--", "/bin/sh", "-c", unsharePrelude+s.limitsPrelude(unshareDropPrivileges()), "tgpt-sandbox", s.Workdir, dir, s.binary)
+	}
+
+	cmd.Path = s.binary
+	cmd.Args = append(append([]string{s.binary}, args...), inner...)
+	return nil
+}
+
+// SetSandbox makes execute_command, and the commands tgpt runs in shell
+// modes, run inside s. A nil sandbox runs them directly.
+func (r *Registry) SetSandbox(s *Sandbox) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sandbox = s
+}
+
+// Sandbox returns the sandbox commands run in, or nil.
+func (r *Registry) Sandbox() *Sandbox {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sandbox
+}
@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSandbox(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := ParseSandbox("", ""); err == nil {
+			t.Error("expected --sandbox to be refused off Linux")
+		}
+		t.Skip("the sandbox needs Linux")
+	}
+	if _, err := exec.LookPath("unshare"); err != nil {
+		t.Skip("unshare is not installed")
+	}
+	dir := t.TempDir()
+
+	s, err := ParseSandbox("backend=unshare, net, memory=512M, cpu=none, time=90", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Backend != "unshare" || !s.Network || s.Memory != 512<<20 || s.CPU != 0 || s.Time != 90*time.Second {
+		t.Errorf("unexpected sandbox: %+v", s)
+	}
+	want := "Sandbox (unshare): read-only root, writable " + s.Workdir + ", private /tmp, network allowed, memory 512M, time limit 1m30s"
+	if got := s.Describe(); got != want {
+		t.Errorf("Describe() = %q, want %q", got, want)
+	}
+
+	s, err = ParseSandbox("true", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Network || s.Memory != DefaultSandboxMemory || s.CPU != DefaultSandboxCPU || s.Time != DefaultSandboxTime {
+		t.Errorf("expected the default limits, got %+v", s)
+	}
+
+	for _, spec := range []string{"memory=lots", "cpu=-1s", "disk=1G", "backend=docker", "workdir=" + filepath.Join(dir, "missing"), "nonet"} {
+		if _, err := ParseSandbox(spec, dir); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+func TestExecuteCommandInSandbox(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the sandbox needs Linux")
+	}
+	if err := exec.Command("unshare", "--user", "--map-root-user", "--mount", "true").Run(); err != nil {
+		t.Skip("unprivileged user namespaces are not available")
+	}
+	dir := t.TempDir()
+	s, err := ParseSandbox("backend=unshare,time=30s", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newCommandRegistry(t, time.Minute)
+	r.SetSandbox(s)
+	// The package directory is outside the workdir and, unlike /tmp, shared
+	// with the sandbox.
+	outside, err := filepath.Abs("sandbox-outside.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(outside) })
+
+	out, _ := r.ExecuteWithTimeout(commandContext(nil), "execute_command", `{"command": "`+
+		`echo inside > `+filepath.Join(dir, "inside.txt")+`; `+
+		`echo outside > `+outside+` || echo refused; `+
+		`mount -o remount,bind,rw / 2>/dev/null; echo remounted > `+outside+` || echo refused again; `+
+		`grep -c : /proc/net/dev"}`)
+	if !strings.HasPrefix(out, "["+s.Describe()+"]\n") {
+		t.Errorf("expected the output to start with the sandbox restrictions, got %q", out)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "inside.txt")); err != nil || string(data) != "inside\n" {
+		t.Errorf("expected a write in the workdir to succeed, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(outside); err == nil || !strings.Contains(out, "refused") || !strings.Contains(out, "refused again") {
+		t.Errorf("expected a write outside the workdir to fail, even after remounting the root, got %q", out)
+	}
+	// Only the loopback interface exists without network access.
+	if !strings.HasSuffix(strings.TrimSpace(out), "\n1") {
+		t.Errorf("expected no network interfaces but loopback, got %q", out)
+	}
+}
@@ -10,12 +10,11 @@ import (
 	"io"
 	"io/fs"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
-	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
 	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/base"
@@ -40,10 +39,32 @@ type ToolSpec struct {
 
 type ToolHandler func(ctx context.Context, args map[string]any) (string, error)
 
+// ToolMeta carries registry-level information about a tool that is not part
+// of the spec sent to the model.
+type ToolMeta struct {
+	Source   string        // "builtin", "custom" or the name of the MCP server
+	Confirm  bool          // ask the user before every call
+	ReadOnly bool          // has no side effects, so it may run concurrently with other read-only calls
+	Timeout  time.Duration // declared timeout, used unless overridden with --tool-timeout
+
+	Destructive bool // may change or delete data, so it is queued in plan mode
+}
+
 type Registry struct {
 	mu       sync.RWMutex
 	tools    map[string]ToolSpec
 	handlers map[string]ToolHandler
+	meta     map[string]ToolMeta
+
+	workspace   *WorkspacePolicy
+	permissions *Permissions
+	limits      ToolLimits
+	httpAllow   []string
+	audit       *AuditLog
+	plan        *Plan
+	checkpoints *Checkpoints
+	sandbox     *Sandbox
+	commands    commandJobs
 }
 
 var DefaultRegistry = NewRegistry()
@@ -52,6 +73,7 @@ func NewRegistry() *Registry {
 	return &Registry{
 		tools:    make(map[string]ToolSpec),
 		handlers: make(map[string]ToolHandler),
+		meta:     make(map[string]ToolMeta),
 	}
 }
 
@@ -66,6 +88,13 @@ var AllBuiltinTools = []string{
 	"edit_file",
 	"grep",
 	"glob",
+	"apply_patch",
+	"git_status",
+	"git_diff",
+	"git_log",
+	"git_blame",
+	"git_show",
+	"http_request",
 }
 
 func IsBuiltinTool(name string) bool {
@@ -114,6 +143,9 @@ const AutoExecKey contextKey = "auto_exec"
 // handler should not prompt again.
 const ConfirmedKey contextKey = "confirmed"
 
+// VerboseKey enables reporting which permission rule decided a tool call.
+const VerboseKey contextKey = "verbose"
+
 var bold = color.New(color.Bold)
 
 // confirmAction prompts the user with an interactive yes/no question using Bubble Tea.
@@ -129,73 +161,176 @@ func confirmAction(prompt string) (bool, error) {
 // contains the cancellation message that should be returned as the tool's
 // result without running the handler at all.
 func PreConfirm(ctx context.Context, name string, argsJSON string) (bool, string, error) {
-	if autoExec, _ := ctx.Value(AutoExecKey).(bool); autoExec {
-		return true, "", nil
-	}
+	return DefaultRegistry.PreConfirm(ctx, name, argsJSON)
+}
+
+// PreConfirm is like the package-level PreConfirm but also knows about the
+// tools registered in r, such as custom tools that require confirmation.
+func (r *Registry) PreConfirm(ctx context.Context, name string, argsJSON string) (bool, string, error) {
+	d, err := r.Confirm(ctx, name, argsJSON)
+	return d.Proceed, d.Message, err
+}
+
+// permissionMenu shows the confirmation choices. Tests replace it.
+var permissionMenu = bubbletea.SelectMenu
 
+// Confirm decides whether a tool call may run. Deny rules are applied first,
+// even with auto-exec (-y); then calls that need confirmation are approved by
+// a matching allow rule or by asking the user, who can also add a rule for
+// the rest of the session or permanently.
+func (r *Registry) Confirm(ctx context.Context, name string, argsJSON string) (Decision, error) {
 	var args map[string]any
 	if argsJSON != "" && argsJSON != "{}" {
 		_ = json.Unmarshal([]byte(argsJSON), &args)
 	}
 
-	switch name {
-	case "execute_command":
+	subject := r.permissionSubject(name, args, argsJSON)
+	perms := r.Permissions()
+	rule, matched := perms.Match(name, subject)
+	verbose, _ := ctx.Value(VerboseKey).(bool)
+
+	if matched && rule.Action == "deny" {
+		if verbose {
+			bold.Fprintf(os.Stderr, "[Permission] %s denied by rule: %s\n", name, rule)
+		}
+		return Decision{
+			Proceed: false,
+			Message: fmt.Sprintf("Tool call denied by permission rule: %s", rule),
+			Outcome: OutcomeRuleDeny,
+			Rule:    rule,
+		}, nil
+	}
+
+	if r.Plan() != nil && r.IsMutating(name, args) {
+		// Nothing runs until the user has reviewed the plan.
+		return Decision{Proceed: true, Outcome: OutcomePlanned}, nil
+	}
+
+	// High-risk commands are always confirmed, even with -y or an allow rule.
+	var risks []string
+	if name == "execute_command" {
 		cmdStr, _ := args["command"].(string)
-		confirmed, err := confirmAction(fmt.Sprintf("\nExecute tool shell command: `%s` ?", cmdStr))
-		if err != nil {
-			if errors.Is(err, bubbletea.ErrCanceled) {
-				return false, "Command execution cancelled by user.", nil
-			}
-			return false, "", err
+		risks = AnalyzeCommand(cmdStr)
+	}
+
+	if autoExec, _ := ctx.Value(AutoExecKey).(bool); autoExec && len(risks) == 0 {
+		return Decision{Proceed: true, Outcome: OutcomeAutoExec}, nil
+	}
+
+	prompt, cancelMsg := r.confirmPrompt(name, args, argsJSON)
+	if prompt == "" {
+		return Decision{Proceed: true, Outcome: OutcomeNotRequired}, nil
+	}
+
+	if len(risks) > 0 {
+		return confirmRisky("\n"+FormatCommandRisks(risks)+prompt, cancelMsg)
+	}
+
+	if matched {
+		if verbose {
+			bold.Fprintf(os.Stderr, "[Permission] %s allowed by rule: %s\n", name, rule)
+		}
+		return Decision{Proceed: true, Outcome: OutcomeRuleAllow, Rule: rule}, nil
+	}
+
+	pattern := SuggestPattern(name, subject)
+	scope := name
+	if pattern != "" {
+		scope = fmt.Sprintf("%s matching `%s`", name, pattern)
+	}
+	options := []string{
+		"Yes (allow once)",
+		fmt.Sprintf("Allow %s for this session", scope),
+		fmt.Sprintf("Always allow %s", scope),
+		"No (deny)",
+	}
+
+	choice, _, err := permissionMenu(prompt, options, 0)
+	if err != nil {
+		if errors.Is(err, bubbletea.ErrCanceled) {
+			return Decision{Proceed: false, Message: cancelMsg, Outcome: OutcomeUserDeny}, nil
+		}
+		return Decision{Proceed: false, Outcome: OutcomeUserDeny}, err
+	}
+
+	newRule := PermissionRule{Tool: name, Pattern: pattern, Action: "allow"}
+	switch choice {
+	case 0:
+		return Decision{Proceed: true, Outcome: OutcomeAllowOnce}, nil
+	case 1:
+		perms.AddSession(newRule)
+		return Decision{Proceed: true, Outcome: OutcomeAllowSession, Rule: &newRule}, nil
+	case 2:
+		if err := perms.AddPersistent(newRule); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to save permission rule: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Saved permission rule: %s\n", newRule)
 		}
-		if !confirmed {
-			return false, "Command execution cancelled by user.", nil
+		return Decision{Proceed: true, Outcome: OutcomeAllowAlways, Rule: &newRule}, nil
+	default:
+		return Decision{Proceed: false, Message: cancelMsg, Outcome: OutcomeUserDeny}, nil
+	}
+}
+
+// confirmRisky asks before a high-risk command. Only a single run can be
+// approved, and "No" is preselected.
+func confirmRisky(prompt, cancelMsg string) (Decision, error) {
+	choice, _, err := permissionMenu(prompt, []string{"Yes, run it", "No (deny)"}, 1)
+	if err != nil {
+		if errors.Is(err, bubbletea.ErrCanceled) {
+			return Decision{Proceed: false, Message: cancelMsg, Outcome: OutcomeUserDeny}, nil
 		}
+		return Decision{Proceed: false, Outcome: OutcomeUserDeny}, err
+	}
+	if choice != 0 {
+		return Decision{Proceed: false, Message: cancelMsg, Outcome: OutcomeUserDeny}, nil
+	}
+	return Decision{Proceed: true, Outcome: OutcomeAllowOnce}, nil
+}
+
+// confirmPrompt returns the question to ask before running the call, or an
+// empty prompt when it needs no confirmation, along with the message returned
+// to the model if the user declines.
+func (r *Registry) confirmPrompt(name string, args map[string]any, argsJSON string) (string, string) {
+	switch name {
+	case "execute_command":
+		cmdStr, _ := args["command"].(string)
+		return fmt.Sprintf("\nExecute tool shell command: `%s` ?", cmdStr), "Command execution cancelled by user."
 	case "write_file":
 		filePath, _ := args["path"].(string)
 		appendMode, _ := args["append"].(bool)
-		if filePath != "" {
-			if _, err := os.Stat(filePath); err == nil {
-				var prompt string
-				var cancelMsg string
+		if resolved, err := r.resolvePath(filePath); filePath != "" && err == nil {
+			if _, err := os.Stat(resolved); err == nil {
 				if appendMode {
-					prompt = fmt.Sprintf("\nAppend to file `%s` ?", filePath)
-					cancelMsg = "File append cancelled by user."
-				} else {
-					prompt = fmt.Sprintf("\nFile `%s` already exists. Overwrite it?", filePath)
-					cancelMsg = "File overwrite cancelled by user."
-				}
-				confirmed, err := confirmAction(prompt)
-				if err != nil {
-					if errors.Is(err, bubbletea.ErrCanceled) {
-						return false, cancelMsg, nil
-					}
-					return false, "", err
-				}
-				if !confirmed {
-					return false, cancelMsg, nil
+					return fmt.Sprintf("\nAppend to file `%s` ?", filePath), "File append cancelled by user."
 				}
+				return fmt.Sprintf("\nFile `%s` already exists. Overwrite it?", filePath), "File overwrite cancelled by user."
 			}
 		}
 	case "edit_file":
 		filePath, _ := args["path"].(string)
-		if filePath != "" {
-			if _, err := os.Stat(filePath); err == nil {
-				confirmed, err := confirmAction(fmt.Sprintf("\nEdit file `%s` ?", filePath))
-				if err != nil {
-					if errors.Is(err, bubbletea.ErrCanceled) {
-						return false, "File edit cancelled by user.", nil
-					}
-					return false, "", err
-				}
-				if !confirmed {
-					return false, "File edit cancelled by user.", nil
-				}
+		if resolved, err := r.resolvePath(filePath); filePath != "" && err == nil {
+			if _, err := os.Stat(resolved); err == nil {
+				return fmt.Sprintf("\nEdit file `%s` ?", filePath), "File edit cancelled by user."
 			}
 		}
+	case "apply_patch":
+		patch, _ := args["patch"].(string)
+		return r.patchConfirmPrompt(patch), "Patch cancelled by user."
+	case "http_request":
+		call, err := r.parseHTTPCall(args)
+		if err != nil || httpSafeMethods[call.method] {
+			// Invalid or disallowed calls fail in the handler without
+			// sending anything.
+			return "", ""
+		}
+		return httpConfirmPrompt(call), "HTTP request cancelled by user."
+	default:
+		if meta, ok := r.Meta(name); ok && meta.Confirm {
+			return fmt.Sprintf("\nRun tool `%s` with %s ?", name, argsJSON), "Tool call cancelled by user."
+		}
 	}
-
-	return true, "", nil
+	return "", ""
 }
 
 func (r *Registry) Register(spec ToolSpec, handler ToolHandler) {
@@ -203,6 +338,48 @@ func (r *Registry) Register(spec ToolSpec, handler ToolHandler) {
 	defer r.mu.Unlock()
 	r.tools[spec.Function.Name] = spec
 	r.handlers[spec.Function.Name] = handler
+	delete(r.meta, spec.Function.Name)
+}
+
+// RegisterWithMeta registers a tool along with its ToolMeta.
+func (r *Registry) RegisterWithMeta(spec ToolSpec, meta ToolMeta, handler ToolHandler) {
+	r.Register(spec, handler)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.meta[spec.Function.Name] = meta
+}
+
+// Meta returns the ToolMeta recorded for name, if any.
+func (r *Registry) Meta(name string) (ToolMeta, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	meta, ok := r.meta[name]
+	return meta, ok
+}
+
+// readOnlyBuiltins are the builtin tools without side effects.
+var readOnlyBuiltins = map[string]bool{
+	"web_search_exa":       true,
+	"web_search_firecrawl": true,
+	"read_directory":       true,
+	"read_file":            true,
+	"web_fetch":            true,
+	"grep":                 true,
+	"glob":                 true,
+	"git_status":           true,
+	"git_diff":             true,
+	"git_log":              true,
+	"git_blame":            true,
+	"git_show":             true,
+}
+
+// IsReadOnly reports whether calls to name can run concurrently. Tools that
+// ask for confirmation never do, so that prompts stay sequential.
+func (r *Registry) IsReadOnly(name string) bool {
+	if meta, ok := r.Meta(name); ok {
+		return meta.ReadOnly && !meta.Confirm
+	}
+	return readOnlyBuiltins[name] && r.Has(name)
 }
 
 func (r *Registry) Has(name string) bool {
@@ -250,7 +427,14 @@ func (r *Registry) Execute(ctx context.Context, name string, argsJSON string) (s
 		args = make(map[string]any)
 	}
 
-	return handler(ctx, args)
+	if plan := r.Plan(); plan != nil && r.IsMutating(name, args) {
+		if applying, _ := ctx.Value(planApplyKey).(bool); !applying {
+			return r.queueStep(plan, name, args, argsJSON), nil
+		}
+	}
+
+	out, err := handler(ctx, args)
+	return limitOutput(out, r.outputBudget()), err
 }
 
 func (r *Registry) registerBuiltinTools(selectedTools ...string) {
@@ -361,12 +545,19 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 			if dirPath == "" {
 				dirPath = "."
 			}
+			dirPath, err := r.resolvePath(dirPath)
+			if err != nil {
+				return "", err
+			}
 			entries, err := os.ReadDir(dirPath)
 			if err != nil {
 				return "", fmt.Errorf("failed to read directory: %w", err)
 			}
 			var out string
 			for _, entry := range entries {
+				if r.skipPath(filepath.Join(dirPath, entry.Name())) {
+					continue
+				}
 				kind := "file"
 				if entry.IsDir() {
 					kind = "dir"
@@ -408,16 +599,15 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 			if filePath == "" {
 				return "", fmt.Errorf("path parameter is required")
 			}
+			filePath, err := r.resolvePath(filePath)
+			if err != nil {
+				return "", err
+			}
 			content, err := os.ReadFile(filePath)
 			if err != nil {
 				return "", fmt.Errorf("failed to read file: %w", err)
 			}
-			str := string(content)
-			runes := []rune(str)
-			if len(runes) > 10000 {
-				str = string(runes[:10000]) + "\n... [content truncated]"
-			}
-			return str, nil
+			return string(content), nil
 		})
 	}
 
@@ -427,7 +617,7 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 			Type: "function",
 			Function: FunctionSpec{
 				Name:        "execute_command",
-				Description: "Execute a shell command",
+				Description: "Execute a shell command. A command still running after the tool timeout keeps running in the background; check on it with command_status",
 				Parameters: map[string]any{
 					"type": "object",
 					"properties": map[string]any{
@@ -435,6 +625,10 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 							"type":        "string",
 							"description": "The shell command line to execute",
 						},
+						"background": map[string]any{
+							"type":        "boolean",
+							"description": "Start the command in the background and return at once, for servers, watchers and long builds",
+						},
 					},
 					"required": []string{"command"},
 				},
@@ -447,8 +641,13 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 
 			autoExec, _ := ctx.Value(AutoExecKey).(bool)
 			confirmed, _ := ctx.Value(ConfirmedKey).(bool)
-			if !autoExec && !confirmed {
-				c, err := confirmAction(fmt.Sprintf("\nExecute tool shell command: `%s` ?", cmdStr))
+			risks := AnalyzeCommand(cmdStr)
+			if (!autoExec || len(risks) > 0) && !confirmed {
+				prompt := fmt.Sprintf("\nExecute tool shell command: `%s` ?", cmdStr)
+				if len(risks) > 0 {
+					prompt = "\n" + FormatCommandRisks(risks) + prompt
+				}
+				c, err := confirmAction(prompt)
 				if err != nil {
 					if errors.Is(err, bubbletea.ErrCanceled) {
 						return "Command execution cancelled by user.", nil
@@ -460,18 +659,10 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 				}
 			}
 
-			var cmd *exec.Cmd
-			if runtime.GOOS == "windows" {
-				cmd = exec.CommandContext(ctx, "cmd.exe", "/C", cmdStr)
-			} else {
-				cmd = exec.CommandContext(ctx, "sh", "-c", cmdStr)
-			}
-			out, err := cmd.CombinedOutput()
-			if err != nil {
-				return fmt.Sprintf("Command failed with error: %v\nOutput: %s", err, string(out)), nil
-			}
-			return string(out), nil
+			background, _ := args["background"].(bool)
+			return r.runCommand(ctx, cmdStr, background), nil
 		})
+		r.registerCommandStatusTool()
 	}
 
 	// 5. web_fetch
@@ -529,32 +720,12 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 				return "", fmt.Errorf("failed to read response body: %w", err)
 			}
 
-			conv := converter.NewConverter(
-				converter.WithPlugins(
-					base.NewBasePlugin(),
-					commonmark.NewCommonmarkPlugin(),
-				),
-			)
-
-			tagsToRemove := []string{"nav", "footer", "header", "aside", "iframe", "svg"}
-			for _, tag := range tagsToRemove {
-				conv.Register.TagType(tag, converter.TagTypeRemove, converter.PriorityStandard)
-			}
-
-			markdown, err := conv.ConvertString(
-				string(bodyBytes),
-				converter.WithDomain(fetchURL),
-			)
+			markdown, err := htmlToMarkdown(string(bodyBytes), fetchURL)
 			if err != nil {
 				return string(bodyBytes), nil
 			}
 
-			maxLen := 12000
-			rMarkdown := []rune(markdown)
-			if len(rMarkdown) > maxLen {
-				markdown = string(rMarkdown[:maxLen]) + "\n\n... [content truncated]"
-			}
-
+			// Long pages are cut down to the output budget by Execute.
 			return markdown, nil
 		})
 	}
@@ -595,6 +766,10 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 				return "", fmt.Errorf("content parameter is required")
 			}
 			appendMode, _ := args["append"].(bool)
+			filePath, err := r.resolvePath(filePath)
+			if err != nil {
+				return "", err
+			}
 
 			autoExec, _ := ctx.Value(AutoExecKey).(bool)
 			confirmed, _ := ctx.Value(ConfirmedKey).(bool)
@@ -622,6 +797,10 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 				}
 			}
 
+			if err := r.snapshot(filePath, "write_file"); err != nil {
+				return "", err
+			}
+
 			dir := filepath.Dir(filePath)
 			if dir != "" && dir != "." {
 				if err := os.MkdirAll(dir, 0755); err != nil {
@@ -634,14 +813,20 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 				if err != nil {
 					return "", fmt.Errorf("failed to open file for appending: %w", err)
 				}
-				defer f.Close()
-				if _, err := f.WriteString(content); err != nil {
+				_, err = f.WriteString(content)
+				if closeErr := f.Close(); err == nil {
+					err = closeErr
+				}
+				r.wrote(filePath)
+				if err != nil {
 					return "", fmt.Errorf("failed to append to file: %w", err)
 				}
 				return fmt.Sprintf("Successfully appended to %s", filePath), nil
 			}
 
-			if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			err = os.WriteFile(filePath, []byte(content), 0644)
+			r.wrote(filePath)
+			if err != nil {
 				return "", fmt.Errorf("failed to write file: %w", err)
 			}
 
@@ -695,6 +880,10 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 				return "", fmt.Errorf("old_content and new_content are identical; no changes to make")
 			}
 
+			filePath, err := r.resolvePath(filePath)
+			if err != nil {
+				return "", err
+			}
 			if _, err := os.Stat(filePath); err != nil {
 				return "", fmt.Errorf("failed to read file for editing: %w", err)
 			}
@@ -729,7 +918,12 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 			}
 
 			updatedStr := strings.Replace(fileStr, oldContent, newContent, 1)
-			if err := os.WriteFile(filePath, []byte(updatedStr), 0644); err != nil {
+			if err := r.snapshot(filePath, "edit_file"); err != nil {
+				return "", err
+			}
+			err = os.WriteFile(filePath, []byte(updatedStr), 0644)
+			r.wrote(filePath)
+			if err != nil {
 				return "", fmt.Errorf("failed to write edited file: %w", err)
 			}
 
@@ -777,6 +971,10 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 			if searchPath == "" {
 				searchPath = "."
 			}
+			searchPath, err = r.resolvePath(searchPath)
+			if err != nil {
+				return "", err
+			}
 			include, _ := args["include"].(string)
 			if include != "" && !strings.Contains(include, "*") && !strings.HasPrefix(include, ".") {
 				include = "*." + include
@@ -792,6 +990,9 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 			maxMatches := 200
 
 			searchFile := func(filePath string) error {
+				if r.skipPath(filePath) {
+					return nil
+				}
 				if include != "" {
 					matched, err := filepath.Match(include, filepath.Base(filePath))
 					if err != nil || !matched {
@@ -852,6 +1053,9 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 						if name == "node_modules" || name == "vendor" {
 							return filepath.SkipDir
 						}
+						if p != searchPath && r.skipPath(p) {
+							return filepath.SkipDir
+						}
 						return nil
 					}
 					if matchCount >= maxMatches {
@@ -872,10 +1076,6 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 			if matchCount >= maxMatches {
 				out += fmt.Sprintf("\n... [truncated at %d matches]", maxMatches)
 			}
-			runes := []rune(out)
-			if len(runes) > 10000 {
-				out = string(runes[:10000]) + "\n... [content truncated]"
-			}
 			return out, nil
 		})
 	}
@@ -911,6 +1111,10 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 			if searchPath == "" {
 				searchPath = "."
 			}
+			searchPath, err := r.resolvePath(searchPath)
+			if err != nil {
+				return "", err
+			}
 
 			var matches []string
 			maxMatches := 500
@@ -918,7 +1122,7 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 			cleanPattern := filepath.ToSlash(pattern)
 			hasPath := strings.Contains(cleanPattern, "/")
 
-			err := filepath.WalkDir(searchPath, func(p string, d fs.DirEntry, err error) error {
+			err = filepath.WalkDir(searchPath, func(p string, d fs.DirEntry, err error) error {
 				if err != nil {
 					return nil
 				}
@@ -931,6 +1135,12 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 						return filepath.SkipDir
 					}
 				}
+				if p != searchPath && r.skipPath(p) {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
 
 				relPath, _ := filepath.Rel(searchPath, p)
 				slashRel := filepath.ToSlash(relPath)
@@ -978,4 +1188,59 @@ func (r *Registry) registerBuiltinTools(selectedTools ...string) {
 			return strings.Join(matches, "\n"), nil
 		})
 	}
+
+	// 10. apply_patch
+	if shouldRegister("apply_patch") {
+		r.Register(ToolSpec{
+			Type: "function",
+			Function: FunctionSpec{
+				Name: "apply_patch",
+				Description: "Apply a patch that can change, create, rename and delete several files at once. " +
+					"Accepts a unified diff (as produced by `diff -u` or `git diff`) or an envelope starting with `*** Begin Patch` " +
+					"and containing `*** Add File: path`, `*** Update File: path` (optionally followed by `*** Move to: path`) " +
+					"or `*** Delete File: path` sections, ending with `*** End Patch`. Hunk lines start with ' ' (context), '-' or '+'. " +
+					"Hunks are matched tolerantly, and either all files are changed or none.",
+				Parameters: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"patch": map[string]any{
+							"type":        "string",
+							"description": "The patch text",
+						},
+					},
+					"required": []string{"patch"},
+				},
+			},
+		}, func(ctx context.Context, args map[string]any) (string, error) {
+			patch, _ := args["patch"].(string)
+			if strings.TrimSpace(patch) == "" {
+				return "", fmt.Errorf("patch parameter is required")
+			}
+			return r.applyPatch(ctx, patch)
+		})
+	}
+
+	// 11. git_status, git_diff, git_log, git_blame and git_show
+	r.registerGitTools(shouldRegister)
+
+	// 12. http_request
+	r.registerHTTPTool(shouldRegister)
+}
+
+// htmlToMarkdown converts a web page to Markdown, dropping navigation and
+// other page chrome.
+func htmlToMarkdown(html, domain string) (string, error) {
+	conv := converter.NewConverter(
+		converter.WithPlugins(
+			base.NewBasePlugin(),
+			commonmark.NewCommonmarkPlugin(),
+		),
+	)
+
+	tagsToRemove := []string{"nav", "footer", "header", "aside", "iframe", "svg"}
+	for _, tag := range tagsToRemove {
+		conv.Register.TagType(tag, converter.TagTypeRemove, converter.PriorityStandard)
+	}
+
+	return conv.ConvertString(html, converter.WithDomain(domain))
 }
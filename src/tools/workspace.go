@@ -0,0 +1,259 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultWorkspaceDeny lists files the file tools refuse to touch even inside
+// the workspace, because they usually hold credentials.
+var DefaultWorkspaceDeny = []string{
+	".env",
+	".env.*",
+	"*.pem",
+	"*.key",
+	"*.p12",
+	"*.pfx",
+	"id_rsa*",
+	"id_ecdsa*",
+	"id_ed25519*",
+	".ssh",
+	".aws",
+	".gnupg",
+	".netrc",
+	".git-credentials",
+}
+
+// DefaultWorkspaceAllow re-allows common non-secret files matched by the
+// default deny list.
+var DefaultWorkspaceAllow = []string{
+	".env.example",
+	".env.sample",
+	".env.template",
+	"*.pub",
+}
+
+// WorkspacePolicy confines the file tools (read_file, write_file, edit_file,
+// apply_patch, read_directory, grep and glob) to a directory tree. Patterns
+// without a slash are matched against every component of the path relative
+// to Root, patterns with a slash against the whole relative path. Allow
+// patterns take precedence over Deny patterns.
+type WorkspacePolicy struct {
+	Root  string
+	Allow []string
+	Deny  []string
+}
+
+// NewWorkspacePolicy resolves root (following symlinks) and combines the
+// given patterns with the defaults.
+func NewWorkspacePolicy(root string, allow, deny []string) (*WorkspacePolicy, error) {
+	if root == "" {
+		root = "."
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace %q: %w", root, err)
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace %q: %w", root, err)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace %q: %w", root, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("invalid workspace %q: not a directory", root)
+	}
+
+	return &WorkspacePolicy{
+		Root:  resolved,
+		Allow: append(append([]string{}, DefaultWorkspaceAllow...), allow...),
+		Deny:  append(append([]string{}, DefaultWorkspaceDeny...), deny...),
+	}, nil
+}
+
+// ParsePatternList splits a comma-separated list of glob patterns.
+func ParsePatternList(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// Resolve returns the absolute, symlink-free form of path, which may be
+// relative to the workspace root. It fails if the path leaves the workspace
+// or matches a deny pattern.
+func (w *WorkspacePolicy) Resolve(path string) (string, error) {
+	if path == "" {
+		path = "."
+	}
+	abs := path
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(w.Root, abs)
+	}
+	abs = filepath.Clean(abs)
+
+	resolved, err := resolveExistingPrefix(abs)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve path %q: %w", path, err)
+	}
+
+	rel, ok := w.relative(resolved)
+	if !ok {
+		return "", fmt.Errorf("access to %q denied: it is outside the workspace %s. File tools are restricted to the workspace; start tgpt with --workspace to choose a different root", path, w.Root)
+	}
+	if pattern, denied := w.deniedBy(rel); denied {
+		return "", fmt.Errorf("access to %q denied: it matches the workspace deny pattern %q, which protects sensitive files. Add it to TGPT_WORKSPACE_ALLOW to permit access", path, pattern)
+	}
+	return resolved, nil
+}
+
+// Denied reports whether an already resolved path inside the workspace is
+// blocked. It is used to skip entries while walking directories.
+func (w *WorkspacePolicy) Denied(path string) bool {
+	resolved, err := resolveExistingPrefix(path)
+	if err != nil {
+		return true
+	}
+	rel, ok := w.relative(resolved)
+	if !ok {
+		return true
+	}
+	_, denied := w.deniedBy(rel)
+	return denied
+}
+
+func (w *WorkspacePolicy) relative(path string) (string, bool) {
+	rel, err := filepath.Rel(w.Root, path)
+	if err != nil {
+		return "", false
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+func (w *WorkspacePolicy) deniedBy(rel string) (string, bool) {
+	if rel == "." {
+		return "", false
+	}
+	if _, ok := matchWorkspacePattern(w.Allow, rel); ok {
+		return "", false
+	}
+	return matchWorkspacePattern(w.Deny, rel)
+}
+
+func matchWorkspacePattern(patterns []string, rel string) (string, bool) {
+	parts := strings.Split(rel, "/")
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(pattern)
+		if strings.Contains(pattern, "/") {
+			if ok, _ := filepath.Match(pattern, rel); ok {
+				return pattern, true
+			}
+			continue
+		}
+		for _, part := range parts {
+			if ok, _ := filepath.Match(pattern, part); ok {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+// maxDanglingLinks bounds how many dangling symlinks resolveExistingPrefix
+// follows, in case they form a cycle.
+const maxDanglingLinks = 40
+
+// resolveExistingPrefix evaluates symlinks in the longest existing prefix of
+// path, so that files which do not exist yet (write_file) are still checked
+// against where their parent directory really is. A dangling symlink is
+// followed to its target, since writing to it creates the target.
+func resolveExistingPrefix(path string) (string, error) {
+	var rest []string
+	current := path
+	links := 0
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			for i := len(rest) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, rest[i])
+			}
+			return resolved, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if info, err := os.Lstat(current); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if links++; links > maxDanglingLinks {
+				return "", fmt.Errorf("too many symbolic links in %s", path)
+			}
+			target, err := os.Readlink(current)
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(current), target)
+			}
+			current = filepath.Clean(target)
+			continue
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return path, nil
+		}
+		rest = append(rest, filepath.Base(current))
+		current = parent
+	}
+}
+
+// SetWorkspace confines the file tools of r to w. A nil policy removes the
+// restriction.
+func (r *Registry) SetWorkspace(w *WorkspacePolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.workspace = w
+}
+
+// Workspace returns the active workspace policy, or nil if file tools are
+// unrestricted.
+func (r *Registry) Workspace() *WorkspacePolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.workspace
+}
+
+// resolvePath checks path against the workspace policy and returns the path
+// the tool should use. Without a policy the path is returned unchanged.
+func (r *Registry) resolvePath(path string) (string, error) {
+	w := r.Workspace()
+	if w == nil {
+		return path, nil
+	}
+	return w.Resolve(path)
+}
+
+// pathExists reports whether path, resolved in the workspace, exists.
+func (r *Registry) pathExists(path string) bool {
+	resolved, err := r.resolvePath(path)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(resolved)
+	return err == nil
+}
+
+// skipPath reports whether a path found while walking a directory must be
+// hidden from the tool output.
+func (r *Registry) skipPath(path string) bool {
+	w := r.Workspace()
+	return w != nil && w.Denied(path)
+}
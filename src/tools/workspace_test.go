@@ -0,0 +1,171 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newWorkspaceRegistry returns a registry with the named builtin tools, all
+// of them if none are named, confined to a new temporary workspace, and the
+// workspace root.
+func newWorkspaceRegistry(t *testing.T, allow, deny []string, names ...string) (*Registry, string) {
+	t.Helper()
+	root := t.TempDir()
+	policy, err := NewWorkspacePolicy(root, allow, deny)
+	if err != nil {
+		t.Fatalf("failed to create workspace policy: %v", err)
+	}
+	r := NewRegistry()
+	r.SetWorkspace(policy)
+	r.RegisterBuiltinTools(names...)
+	return r, policy.Root
+}
+
+func argsJSON(t *testing.T, args map[string]any) string {
+	t.Helper()
+	b, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestWorkspaceRelativePathsAndEscapes(t *testing.T) {
+	r, root := newWorkspaceRegistry(t, nil, nil)
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(filepath.Dir(root), "outside-"+filepath.Base(root)+".txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(outside) })
+
+	res, err := r.Execute(context.Background(), "read_file", argsJSON(t, map[string]any{"path": "notes.txt"}))
+	if err != nil || res != "inside" {
+		t.Fatalf("expected relative path to resolve inside workspace, got %q, %v", res, err)
+	}
+
+	for _, p := range []string{outside, "../" + filepath.Base(outside), "sub/../../" + filepath.Base(outside)} {
+		_, err := r.Execute(context.Background(), "read_file", argsJSON(t, map[string]any{"path": p}))
+		if err == nil || !strings.Contains(err.Error(), "outside the workspace") {
+			t.Fatalf("expected %q to be rejected, got %v", p, err)
+		}
+	}
+
+	ctx := context.WithValue(context.Background(), AutoExecKey, true)
+	if _, err := r.Execute(ctx, "write_file", argsJSON(t, map[string]any{"path": outside, "content": "x"})); err == nil {
+		t.Fatal("expected write outside the workspace to fail")
+	}
+	if _, err := r.Execute(ctx, "write_file", argsJSON(t, map[string]any{"path": "new/dir/file.txt", "content": "x"})); err != nil {
+		t.Fatalf("expected write of a new nested file to succeed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "new", "dir", "file.txt")); err != nil {
+		t.Fatalf("expected file to be created under the workspace root: %v", err)
+	}
+}
+
+func TestWorkspaceRejectsSymlinkEscape(t *testing.T) {
+	r, root := newWorkspaceRegistry(t, nil, nil)
+	outsideDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outsideDir, "data.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outsideDir, filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	_, err := r.Execute(context.Background(), "read_file", argsJSON(t, map[string]any{"path": "link/data.txt"}))
+	if err == nil || !strings.Contains(err.Error(), "outside the workspace") {
+		t.Fatalf("expected symlink escape to be rejected, got %v", err)
+	}
+
+	ctx := context.WithValue(context.Background(), AutoExecKey, true)
+	_, err = r.Execute(ctx, "write_file", argsJSON(t, map[string]any{"path": "link/new.txt", "content": "x"}))
+	if err == nil {
+		t.Fatal("expected write through symlink to be rejected")
+	}
+}
+
+func TestWorkspaceRejectsDanglingSymlinkEscape(t *testing.T) {
+	r, root := newWorkspaceRegistry(t, nil, nil)
+	target := filepath.Join(t.TempDir(), "new.txt")
+	if err := os.Symlink(target, filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink("link", filepath.Join(root, "chain")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("inside.txt", filepath.Join(root, "local")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), AutoExecKey, true)
+	for _, path := range []string{"link", "chain"} {
+		_, err := r.Execute(ctx, "write_file", argsJSON(t, map[string]any{"path": path, "content": "x"}))
+		if err == nil || !strings.Contains(err.Error(), "outside the workspace") {
+			t.Errorf("expected a write through the dangling symlink %s to be rejected, got %v", path, err)
+		}
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Error("expected no file to be created outside the workspace")
+	}
+
+	if _, err := r.Execute(ctx, "write_file", argsJSON(t, map[string]any{"path": "local", "content": "x"})); err != nil {
+		t.Errorf("expected a dangling symlink into the workspace to be writable, got %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "inside.txt")); err != nil || string(data) != "x" {
+		t.Errorf("expected the write to create the link target, got %q (%v)", data, err)
+	}
+}
+
+func TestWorkspaceDenyAndAllowPatterns(t *testing.T) {
+	r, root := newWorkspaceRegistry(t, []string{"config/*.key"}, []string{"private"})
+	files := map[string]string{
+		".env":           "TOKEN=1",
+		".env.example":   "TOKEN=",
+		"cert.pem":       "pem",
+		"config/app.key": "allowed",
+		"private/a.txt":  "hidden",
+		"main.go":        "package main // TOKEN",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range []string{".env", "cert.pem", "private/a.txt"} {
+		_, err := r.Execute(context.Background(), "read_file", argsJSON(t, map[string]any{"path": p}))
+		if err == nil || !strings.Contains(err.Error(), "deny pattern") {
+			t.Fatalf("expected %s to be denied, got %v", p, err)
+		}
+	}
+	for _, p := range []string{".env.example", "config/app.key"} {
+		if _, err := r.Execute(context.Background(), "read_file", argsJSON(t, map[string]any{"path": p})); err != nil {
+			t.Fatalf("expected %s to be allowed, got %v", p, err)
+		}
+	}
+
+	res, err := r.Execute(context.Background(), "grep", argsJSON(t, map[string]any{"pattern": "TOKEN|hidden"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(res, "TOKEN=1") || strings.Contains(res, "hidden") || !strings.Contains(res, "main.go") {
+		t.Fatalf("expected grep to skip denied files, got %q", res)
+	}
+
+	res, err = r.Execute(context.Background(), "read_directory", `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(res, "cert.pem") || strings.Contains(res, "private") || !strings.Contains(res, "main.go") {
+		t.Fatalf("expected read_directory to hide denied entries, got %q", res)
+	}
+}
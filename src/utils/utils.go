@@ -94,14 +94,27 @@ func DownloadImage(url string, destDir string) error {
 	return nil
 }
 
+// OpenImage opens an image file in the default viewer.
 func OpenImage(path string) error {
+	return openDefault(path)
+}
+
+// OpenURL opens a web URL in the default browser.
+func OpenURL(url string) error {
+	return openDefault(url)
+}
+
+// openDefault opens a file or URL with the desktop's default application.
+func openDefault(target string) error {
 	switch runtime.GOOS {
-	case "linux":
-		return exec.Command("xdg-open", path).Start()
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return exec.Command("xdg-open", target).Start()
 	case "darwin":
-		return exec.Command("open", path).Start()
+		return exec.Command("open", target).Start()
 	case "windows":
-		return exec.Command("cmd", "/c", "start", "", path).Start()
+		// rundll32 passes the target on as is; cmd /c start would split
+		// URLs at &.
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", target).Start()
 	default:
 		return exec.ErrNotFound
 	}
@@ -131,4 +144,4 @@ func GetLastCodeBlock(markdown string) string {
 	}
 
 	return strings.Join(codeBlock, "\n")
-}
\ No newline at end of file
+}